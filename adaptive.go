@@ -0,0 +1,174 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// adaptiveCheckInterval is how often the adaptive mode looks at FFmpeg's
+// progress reports
+const adaptiveCheckInterval = 5 * time.Second
+
+// adaptiveBitrateFactor is how much each step lowers the bitrate, and
+// adaptiveScaleStep how much each step lowers the resolution once the
+// bitrate is at AdaptiveMinBitrate, down to adaptiveMinScale
+const (
+	adaptiveBitrateFactor = 0.75
+	adaptiveScaleStep     = 0.25
+	adaptiveMinScale      = 0.5
+)
+
+// bitrateKbps converts a bitrate like 2500k, 4M or 800000 to kbit/s
+func bitrateKbps(bitrate string) (int, error) {
+	if !bitratePattern.MatchString(bitrate) {
+		return 0, fmt.Errorf("invalid bitrate '%s'", bitrate)
+	}
+	multiplier := 0.001
+	switch strings.ToLower(bitrate[len(bitrate)-1:]) {
+	case "k":
+		multiplier = 1
+		bitrate = bitrate[:len(bitrate)-1]
+	case "m":
+		multiplier = 1000
+		bitrate = bitrate[:len(bitrate)-1]
+	}
+	value, err := strconv.ParseFloat(bitrate, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid bitrate '%s'", bitrate)
+	}
+	return int(value * multiplier), nil
+}
+
+// adaptiveQuality returns the bitrate and resolution scale after step
+// reductions: the bitrate drops by a quarter per step until it reaches
+// AdaptiveMinBitrate, then the resolution by a quarter until half of
+// the screen size. ok is false when step is beyond the lowest quality.
+func (c *Config) adaptiveQuality(step int) (kbps int, scale float64, ok bool) {
+	kbps, _ = bitrateKbps(c.videoBitrate())
+	minKbps, _ := bitrateKbps(c.AdaptiveMinBitrate)
+	scale = 1
+	for i := 0; i < step; i++ {
+		switch {
+		case kbps > minKbps:
+			kbps = int(float64(kbps) * adaptiveBitrateFactor)
+			if kbps < minKbps {
+				kbps = minKbps
+			}
+		case scale > adaptiveMinScale:
+			scale -= adaptiveScaleStep
+		default:
+			return kbps, scale, false
+		}
+	}
+	return kbps, scale, true
+}
+
+// adaptiveStepNow returns how far adaptive mode has lowered the quality
+// of the running stream
+func (s *ShellCast) adaptiveStepNow() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.adaptiveStep
+}
+
+// rateControlArgs returns the bitrate and keyframe arguments for the
+// stream, with the bitrate lowered by adaptive mode
+func (s *ShellCast) rateControlArgs() []string {
+	args := s.cfg().rateControlArgs()
+	step := s.adaptiveStepNow()
+	if step == 0 {
+		return args
+	}
+	kbps, _, _ := s.cfg().adaptiveQuality(step)
+	bitrate := fmt.Sprintf("%dk", kbps)
+	for i := 0; i+1 < len(args); i++ {
+		switch args[i] {
+		case "-b:v", "-maxrate", "-bufsize":
+			args[i+1] = bitrate
+		}
+	}
+	return args
+}
+
+// adaptiveScaleFilter returns the filter that scales the video down for
+// adaptive mode, or "" at full resolution
+func (s *ShellCast) adaptiveScaleFilter() string {
+	step := s.adaptiveStepNow()
+	if step == 0 {
+		return ""
+	}
+	_, scale, _ := s.cfg().adaptiveQuality(step)
+	if scale == 1 {
+		return ""
+	}
+	width, height := s.cfg().scaledSize(scale)
+	return fmt.Sprintf(",scale=%d:%d", width, height)
+}
+
+// scaledSize returns the screen size times scale, rounded down to even
+// numbers as encoders want
+func (c *Config) scaledSize(scale float64) (width, height int) {
+	return int(float64(c.ScreenWidth)*scale) / 2 * 2, int(float64(c.ScreenHeight)*scale) / 2 * 2
+}
+
+// adapt watches FFmpeg's progress reports while the stream runs. When
+// frames keep being dropped, the frame rate stays below AdaptiveMinFPS
+// or the reports stop for AdaptiveWindow, FFmpeg is restarted at the
+// next lower quality. It returns when done is closed.
+func (s *ShellCast) adapt(done <-chan struct{}) {
+	ticker := time.NewTicker(adaptiveCheckInterval)
+	defer ticker.Stop()
+
+	var last StreamStats
+	var pressureSince time.Time
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+
+		stats, ok := s.StreamStats()
+		if !ok {
+			continue
+		}
+		// FFmpeg stops reporting while it is blocked on a slow connection
+		dropped := stats.DroppedFrames - last.DroppedFrames
+		stalled := time.Since(stats.Updated) > adaptiveCheckInterval
+		pressure := !last.Updated.IsZero() && (dropped > 0 || stalled || stats.FPS < s.cfg().AdaptiveMinFPS)
+		last = stats
+		if !pressure {
+			pressureSince = time.Time{}
+			continue
+		}
+		if pressureSince.IsZero() {
+			pressureSince = time.Now()
+		}
+		if time.Since(pressureSince) < time.Duration(s.cfg().AdaptiveWindow) {
+			continue
+		}
+
+		step := s.adaptiveStepNow() + 1
+		kbps, scale, ok := s.cfg().adaptiveQuality(step)
+		if !ok {
+			s.logger.Errorf("Stream can't keep up (%.1f fps, %d frames dropped), but it is already at the lowest quality", stats.FPS, dropped)
+			pressureSince = time.Time{}
+			continue
+		}
+		width, height := s.cfg().scaledSize(scale)
+		s.logger.Errorf("Stream can't keep up (%.1f fps, %d frames dropped); lowering quality to %dk at %dx%d",
+			stats.FPS, dropped, kbps, width, height)
+
+		s.mutex.Lock()
+		s.adaptiveStep = step
+		s.mutex.Unlock()
+		// Restarting closes done, ending this loop; the new stream
+		// starts its own
+		if err := s.RestartStreaming(); err != nil {
+			s.logger.Errorf("Error restarting stream: %v", err)
+		}
+		return
+	}
+}
@@ -11,27 +11,43 @@ then
     exit 1
 fi
 
+# Platform specific process handling
+PROC_FILE=proc_unix.go
+OUTPUT=shellcast
+if [[ "$(go env GOOS)" == "windows" ]]; then
+    PROC_FILE=proc_windows.go
+    OUTPUT=shellcast.exe
+fi
+
+SOURCES="config.go shellcast.go interactive.go logger.go filter.go script.go selftest.go typing.go ratelimit.go partial.go tempfiles.go events.go colors.go prefix.go keepalive.go streamstats.go version.go streamkey.go platform.go configkeys.go linenumbers.go renderer.go gif.go streamfile.go subtitles.go render.go ffmpeglog.go jobs.go retention.go tail.go timestamps.go manifest.go lineending.go themes.go adaptive.go fonts.go width.go runner.go server.go playback.go main.go $PROC_FILE"
+
 # Ensure all files exist
-for file in config.go shellcast.go interactive.go main.go; do
+for file in $SOURCES fonts/DejaVuSansMono.ttf; do
     if [[ ! -f $file ]]; then
         echo "Error: Required file $file not found"
         exit 1
     fi
 done
 
+# Stamp the binary with version information from git, if available
+VERSION=${VERSION:-$(git describe --tags --always --dirty 2>/dev/null || echo dev)}
+COMMIT=$(git rev-parse --short HEAD 2>/dev/null)
+BUILD_DATE=$(date -u +%Y-%m-%dT%H:%M:%SZ)
+LDFLAGS="-X main.Version=$VERSION -X main.GitCommit=$COMMIT -X main.BuildDate=$BUILD_DATE"
+
 # Build the application
-go build -o shellcast config.go shellcast.go interactive.go main.go
+go build -ldflags "$LDFLAGS" -o $OUTPUT $SOURCES
 
 # Check if build was successful
-if [[ $? -eq 0 && -f shellcast ]]; then
-    echo "Build successful: $(pwd)/shellcast"
+if [[ $? -eq 0 && -f $OUTPUT ]]; then
+    echo "Build successful: $(pwd)/$OUTPUT"
     echo ""
     echo "Usage examples:"
     echo "  ./shellcast -interactive"
     echo "  ./shellcast -rtmp rtmp://server/app ls -la"
     echo "  ./shellcast -theme hacker -timestamp on -record command"
     echo "  ./shellcast -split \"ls -la\" \"top -n 1\""
-    chmod +x shellcast
+    chmod +x $OUTPUT
 else
     echo "Build failed"
     exit 1
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// ffmpegColors maps the color names FFmpeg accepts, in lower case, to
+// their RGB values. FFmpeg also accepts "random".
+var ffmpegColors = map[string]string{
+	"aliceblue":            "f0f8ff",
+	"antiquewhite":         "faebd7",
+	"aqua":                 "00ffff",
+	"aquamarine":           "7fffd4",
+	"azure":                "f0ffff",
+	"beige":                "f5f5dc",
+	"bisque":               "ffe4c4",
+	"black":                "000000",
+	"blanchedalmond":       "ffebcd",
+	"blue":                 "0000ff",
+	"blueviolet":           "8a2be2",
+	"brown":                "a52a2a",
+	"burlywood":            "deb887",
+	"cadetblue":            "5f9ea0",
+	"chartreuse":           "7fff00",
+	"chocolate":            "d2691e",
+	"coral":                "ff7f50",
+	"cornflowerblue":       "6495ed",
+	"cornsilk":             "fff8dc",
+	"crimson":              "dc143c",
+	"cyan":                 "00ffff",
+	"darkblue":             "00008b",
+	"darkcyan":             "008b8b",
+	"darkgoldenrod":        "b8860b",
+	"darkgray":             "a9a9a9",
+	"darkgreen":            "006400",
+	"darkkhaki":            "bdb76b",
+	"darkmagenta":          "8b008b",
+	"darkolivegreen":       "556b2f",
+	"darkorange":           "ff8c00",
+	"darkorchid":           "9932cc",
+	"darkred":              "8b0000",
+	"darksalmon":           "e9967a",
+	"darkseagreen":         "8fbc8f",
+	"darkslateblue":        "483d8b",
+	"darkslategray":        "2f4f4f",
+	"darkturquoise":        "00ced1",
+	"darkviolet":           "9400d3",
+	"deeppink":             "ff1493",
+	"deepskyblue":          "00bfff",
+	"dimgray":              "696969",
+	"dodgerblue":           "1e90ff",
+	"firebrick":            "b22222",
+	"floralwhite":          "fffaf0",
+	"forestgreen":          "228b22",
+	"fuchsia":              "ff00ff",
+	"gainsboro":            "dcdcdc",
+	"ghostwhite":           "f8f8ff",
+	"gold":                 "ffd700",
+	"goldenrod":            "daa520",
+	"gray":                 "808080",
+	"green":                "008000",
+	"greenyellow":          "adff2f",
+	"honeydew":             "f0fff0",
+	"hotpink":              "ff69b4",
+	"indianred":            "cd5c5c",
+	"indigo":               "4b0082",
+	"ivory":                "fffff0",
+	"khaki":                "f0e68c",
+	"lavender":             "e6e6fa",
+	"lavenderblush":        "fff0f5",
+	"lawngreen":            "7cfc00",
+	"lemonchiffon":         "fffacd",
+	"lightblue":            "add8e6",
+	"lightcoral":           "f08080",
+	"lightcyan":            "e0ffff",
+	"lightgoldenrodyellow": "fafad2",
+	"lightgreen":           "90ee90",
+	"lightgrey":            "d3d3d3",
+	"lightpink":            "ffb6c1",
+	"lightsalmon":          "ffa07a",
+	"lightseagreen":        "20b2aa",
+	"lightskyblue":         "87cefa",
+	"lightslategray":       "778899",
+	"lightsteelblue":       "b0c4de",
+	"lightyellow":          "ffffe0",
+	"lime":                 "00ff00",
+	"limegreen":            "32cd32",
+	"linen":                "faf0e6",
+	"magenta":              "ff00ff",
+	"maroon":               "800000",
+	"mediumaquamarine":     "66cdaa",
+	"mediumblue":           "0000cd",
+	"mediumorchid":         "ba55d3",
+	"mediumpurple":         "9370d8",
+	"mediumseagreen":       "3cb371",
+	"mediumslateblue":      "7b68ee",
+	"mediumspringgreen":    "00fa9a",
+	"mediumturquoise":      "48d1cc",
+	"mediumvioletred":      "c71585",
+	"midnightblue":         "191970",
+	"mintcream":            "f5fffa",
+	"mistyrose":            "ffe4e1",
+	"moccasin":             "ffe4b5",
+	"navajowhite":          "ffdead",
+	"navy":                 "000080",
+	"oldlace":              "fdf5e6",
+	"olive":                "808000",
+	"olivedrab":            "6b8e23",
+	"orange":               "ffa500",
+	"orangered":            "ff4500",
+	"orchid":               "da70d6",
+	"palegoldenrod":        "eee8aa",
+	"palegreen":            "98fb98",
+	"paleturquoise":        "afeeee",
+	"palevioletred":        "d87093",
+	"papayawhip":           "ffefd5",
+	"peachpuff":            "ffdab9",
+	"peru":                 "cd853f",
+	"pink":                 "ffc0cb",
+	"plum":                 "dda0dd",
+	"powderblue":           "b0e0e6",
+	"purple":               "800080",
+	"red":                  "ff0000",
+	"rosybrown":            "bc8f8f",
+	"royalblue":            "4169e1",
+	"saddlebrown":          "8b4513",
+	"salmon":               "fa8072",
+	"sandybrown":           "f4a460",
+	"seagreen":             "2e8b57",
+	"seashell":             "fff5ee",
+	"sienna":               "a0522d",
+	"silver":               "c0c0c0",
+	"skyblue":              "87ceeb",
+	"slateblue":            "6a5acd",
+	"slategray":            "708090",
+	"snow":                 "fffafa",
+	"springgreen":          "00ff7f",
+	"steelblue":            "4682b4",
+	"tan":                  "d2b48c",
+	"teal":                 "008080",
+	"thistle":              "d8bfd8",
+	"tomato":               "ff6347",
+	"turquoise":            "40e0d0",
+	"violet":               "ee82ee",
+	"wheat":                "f5deb3",
+	"white":                "ffffff",
+	"whitesmoke":           "f5f5f5",
+	"yellow":               "ffff00",
+	"yellowgreen":          "9acd32",
+}
+
+// NormalizeColor checks that color is something FFmpeg understands: a
+// color name, or #RRGGBB / 0xRRGGBB with optional alpha digits, each
+// optionally followed by @ and an opacity. Names are returned in lower
+// case and hex colors as #rrggbb, which browsers understand as well.
+func NormalizeColor(color string) (string, error) {
+	value := strings.TrimSpace(color)
+	if value == "" {
+		return "", fmt.Errorf("empty color")
+	}
+
+	base, alpha, hasAlpha := strings.Cut(value, "@")
+	if hasAlpha {
+		opacity, err := strconv.ParseFloat(alpha, 64)
+		if err != nil || opacity < 0 || opacity > 1 {
+			return "", fmt.Errorf("invalid opacity in color '%s': must be between 0.0 and 1.0", color)
+		}
+		alpha = "@" + alpha
+	}
+
+	lower := strings.ToLower(base)
+	if _, ok := ffmpegColors[lower]; ok || lower == "random" {
+		return lower + alpha, nil
+	}
+
+	hex := lower
+	if rest, ok := strings.CutPrefix(hex, "#"); ok {
+		hex = rest
+	} else if rest, ok := strings.CutPrefix(hex, "0x"); ok {
+		hex = rest
+	} else {
+		return "", fmt.Errorf("unknown color '%s': use a name like white or a hex value like #ffffff", color)
+	}
+	if len(hex) != 6 && len(hex) != 8 {
+		return "", fmt.Errorf("invalid color '%s': hex colors need 6 or 8 digits", color)
+	}
+	if _, err := strconv.ParseUint(hex, 16, 32); err != nil {
+		return "", fmt.Errorf("invalid color '%s': not a hex value", color)
+	}
+	return "#" + hex + alpha, nil
+}
+
+// ansiColor returns the escape sequence setting a terminal's text to a
+// normalized color, or "" for colors without a fixed value
+func ansiColor(color string) string {
+	base, _, _ := strings.Cut(color, "@")
+	hex, ok := ffmpegColors[base]
+	if !ok {
+		hex = strings.TrimPrefix(base, "#")
+	}
+	if len(hex) < 6 {
+		return ""
+	}
+	rgb, err := strconv.ParseUint(hex[:6], 16, 32)
+	if err != nil {
+		return ""
+	}
+	return fmt.Sprintf("\x1b[38;2;%d;%d;%dm", rgb>>16, rgb>>8&0xff, rgb&0xff)
+}
+
+// NoColorEnv turns off ANSI colors in ShellCast's terminal output when
+// set to anything but "", following https://no-color.org
+const NoColorEnv = "NO_COLOR"
+
+// useColor reports whether ANSI escape sequences may be written to w:
+// only when w is a terminal, and neither NoColor nor $NO_COLOR is set.
+// This concerns ShellCast's terminal output only; the video, recordings
+// and browser viewers never get color codes.
+func (s *ShellCast) useColor(w io.Writer) bool {
+	if s.cfg().NoColor || os.Getenv(NoColorEnv) != "" {
+		return false
+	}
+	return isTerminal(w)
+}
+
+// isTerminal reports whether w is a terminal rather than a pipe, file or
+// buffer
+func isTerminal(w io.Writer) bool {
+	file, ok := w.(*os.File)
+	if !ok {
+		return false
+	}
+	info, err := file.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// colorWriter tints everything written to w with an ANSI color
+type colorWriter struct {
+	w     io.Writer
+	color string // escape sequence from ansiColor
+}
+
+// Write writes p to the underlying writer in color
+func (c *colorWriter) Write(p []byte) (int, error) {
+	data := make([]byte, 0, len(c.color)+len(p)+len(ansiReset))
+	data = append(data, c.color...)
+	data = append(data, p...)
+	data = append(data, ansiReset...)
+	if _, err := c.w.Write(data); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// ansiReset restores a terminal's default text color
+const ansiReset = "\x1b[0m"
+
+// Validate checks the colors of a theme preset
+func (t ThemePreset) Validate() error {
+	colors := map[string]string{
+		"font color":       t.FontColor,
+		"background color": t.BackgroundColor,
+		"border color":     t.BorderColor,
+		"highlight color":  t.HighlightColor,
+	}
+	for name, color := range colors {
+		if _, err := NormalizeColor(color); err != nil {
+			return fmt.Errorf("theme '%s' %s: %v", t.Name, name, err)
+		}
+	}
+	return nil
+}
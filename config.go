@@ -5,28 +5,235 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"regexp"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
 )
 
 // Config holds application configuration
 type Config struct {
 	RTMPUrl         string `json:"rtmp_url"`
+	RTMPBase        string `json:"rtmp_base"`  // RTMP URL without the stream key
+	StreamKey       string `json:"-"`          // joined to RTMPBase, never saved
+	Platform        string `json:"platform"`   // streaming service preset, see platform.go
+	VideoBitrate    string `json:"video_bitrate"`    // e.g. "3000k", empty = encoder default
+	KeyframeSeconds int    `json:"keyframe_seconds"` // 0 = encoder default
 	FFmpegPath      string `json:"ffmpeg_path"`
 	FontSize        int    `json:"font_size"`
 	FontColor       string `json:"font_color"`
+	FontFile        string `json:"font_file"` // comma-separated, first existing one is used; empty = platform default
+	TextX           string `json:"text_x"` // drawtext x expression, empty = text_padding
+	TextY           string `json:"text_y"` // drawtext y expression, empty = text_padding
+	TextPadding     int    `json:"text_padding"`
+	TextBox         bool    `json:"text_box"`    // draw the background color behind the text
+	BoxOpacity      float64 `json:"box_opacity"` // 0 (transparent) to 1 (opaque)
 	BackgroundColor string `json:"background_color"`
 	OutputFile      string `json:"output_file"`
 
 	ShowTimestamp   bool     `json:"show_timestamp"`
 	TimestampFormat string   `json:"timestamp_format"`
+	TimestampMode   string   `json:"timestamp_mode"`
+	TimestampLines  string        `json:"timestamp_lines"`    // which lines are stamped, see SupportedTimestampLines
+	TimestampInterval Duration      `json:"timestamp_interval"` // burst gap or stamp interval
+	PrefixTemplate  string   `json:"prefix_template"` // replaces timestamp and labels when set
+	LineNumbers     bool     `json:"line_numbers"`
+	LineNumberScope string   `json:"line_number_scope"`
 	ScreenWidth     int      `json:"screen_width"`
 	ScreenHeight    int      `json:"screen_height"`
 	RecordSession   bool     `json:"record_session"`
 	RecordPath      string   `json:"record_path"`
+	RecordFile      string   `json:"record_file"`
+	RecordAppend    bool     `json:"record_append"`
+	RecordVideo     bool     `json:"record_video"`
+	RecordFlushInterval Duration      `json:"record_flush_interval"`
+	RecordFsync     bool     `json:"record_fsync"`
+	RecordFormat    string   `json:"record_format"`
+	CompressRecording bool   `json:"compress_recording"` // gzip, also when the record file ends in .gz
+	LineEnding      string        `json:"line_ending"`    // lf or crlf, for recordings and dumps
+	RawRecording    bool          `json:"raw_recording"`  // only the output lines, without header, footer or prefixes
+	RecordKeep      int           `json:"record_keep"`    // most recent files kept in RecordPath, 0 = all
+	RecordMaxAge    Duration      `json:"record_max_age"` // older files in RecordPath are removed, 0 = never
 	SplitScreen     bool     `json:"split_screen"`
-	SplitCommands   []string `json:"split_commands"`
+	SplitCommands   []SplitCommandSpec `json:"split_commands"`
+	MaxSplitCommands int     `json:"max_split_commands"`
 	ThemeName      string   `json:"theme_name"`
+	ThemesFile     string   `json:"themes_file"` // more themes, see LoadThemes
+	CommandTimeout  Duration      `json:"command_timeout"`
+	PreCommandHook  string        `json:"pre_command_hook"`
+	PostCommandHook string        `json:"post_command_hook"`
+	SuppressHookOutput bool       `json:"suppress_hook_output"`
+	TypingEffect    bool          `json:"typing_effect"`
+	TypingSpeed     Duration      `json:"typing_speed"` // delay per character
+	IntroText       string        `json:"intro_text"`
+	IntroDuration   Duration      `json:"intro_duration"`
+	MaxLinesPerSecond int         `json:"max_lines_per_second"` // 0 = no limit
+	CoalesceLines   bool          `json:"coalesce_lines"`
+	MaxLineBytes    int           `json:"max_line_bytes"` // longer lines are truncated
+	StreamStartupSeconds int      `json:"stream_startup_seconds"` // wait before running the command
+	StreamGraceSeconds   int      `json:"stream_grace_seconds"`   // keep streaming after it ends
+	KeepaliveInterval Duration      `json:"keepalive_interval"` // idle indicator after this long, 0 = off
+	Adaptive        bool          `json:"adaptive"`             // lower the quality when the stream can't keep up
+	AdaptiveMinFPS  float64       `json:"adaptive_min_fps"`     // lower frame rates count as falling behind
+	AdaptiveWindow  Duration      `json:"adaptive_window"`      // how long before the quality is lowered
+	AdaptiveMinBitrate string     `json:"adaptive_min_bitrate"` // then the resolution is lowered instead
+	ServeAddr       string        `json:"serve_addr"`
+	DryRun          bool          `json:"dry_run"`
+	KeepTemp        bool          `json:"keep_temp"`
+	ShellMode       bool          `json:"shell_mode"`
+	ShellProgram    string        `json:"shell_program"` // empty = sh, or cmd on Windows
+	LogLevel        string        `json:"log_level"`
+	OutputFormat    string        `json:"output_format"`
+	MergeStreams    bool          `json:"merge_streams"`
+	NoColor         bool          `json:"no_color"` // no ANSI colors on the terminal, also with $NO_COLOR
 
+	AutoSave  bool              `json:"auto_save"` // interactive changes are written back to the config file
+	Aliases   map[string]string `json:"aliases"`
+	Highlight []string          `json:"highlight"` // regular expressions
+
+	IncludeRegex string `json:"include_regex"`
+	ExcludeRegex string `json:"exclude_regex"`
+
+	Redact          []string `json:"redact"` // regular expressions
+	NoDefaultRedact bool     `json:"no_default_redact"`
+
+	Renderer        string   `json:"renderer"`
+	CaptureMode     string   `json:"capture_mode"`
+	CaptureDevice   string   `json:"capture_device"` // avfoundation video device for screen capture
+
+	Encoder         string   `json:"encoder"`
+	VAAPIDevice     string   `json:"vaapi_device"`
     EncoderPriority []string `json:"encoder_priority"`
+	ExtraFFmpegArgs []string `json:"extra_ffmpeg_args"`
+
+	Outputs []OutputSpec `json:"outputs"`
+
+	// Named sets of settings overriding the ones above, see
+	// LoadConfigProfile. Kept as written so saving doesn't expand them.
+	Profiles map[string]json.RawMessage `json:"profiles,omitempty"`
+	profile  string                     // profile applied when loading
+}
+
+// OutputSpec is an additional destination for the rendered video, such
+// as a local MP4 archive or an HLS playlist
+type OutputSpec struct {
+	URL    string `json:"url"`
+	Format string `json:"format"` // FFmpeg muxer, guessed from URL if empty
+}
+
+// SplitCommandSpec is a command for split screen mode. Label replaces
+// the automatic CMDn label of its output and Color, if set, tints its
+// lines on the terminal. In the config file a plain string is a command
+// without label or color.
+type SplitCommandSpec struct {
+	Command string `json:"command"`
+	Label   string `json:"label,omitempty"`
+	Color   string `json:"color,omitempty"`
+}
+
+// UnmarshalJSON accepts either a command string or an object
+func (spec *SplitCommandSpec) UnmarshalJSON(data []byte) error {
+	var command string
+	if err := json.Unmarshal(data, &command); err == nil {
+		*spec = SplitCommandSpec{Command: command}
+		return nil
+	}
+
+	type plain SplitCommandSpec
+	var value plain
+	if err := json.Unmarshal(data, &value); err != nil {
+		return fmt.Errorf("split command must be a string or an object with command, label and color: %v", err)
+	}
+	*spec = SplitCommandSpec(value)
+	return nil
+}
+
+// MarshalJSON writes a spec with only a command as a plain string
+func (spec SplitCommandSpec) MarshalJSON() ([]byte, error) {
+	if spec.Label == "" && spec.Color == "" {
+		return json.Marshal(spec.Command)
+	}
+	type plain SplitCommandSpec
+	return json.Marshal(plain(spec))
+}
+
+// Duration is a time.Duration written in the config file like "50ms" or
+// "30s"
+type Duration time.Duration
+
+func (d Duration) String() string {
+	return time.Duration(d).String()
+}
+
+// MarshalJSON writes the duration as a string
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+// UnmarshalJSON accepts a duration string, or a number of nanoseconds as
+// written by older versions
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var nanoseconds int64
+	if err := json.Unmarshal(data, &nanoseconds); err == nil {
+		*d = Duration(nanoseconds)
+		return nil
+	}
+
+	var value string
+	if err := json.Unmarshal(data, &value); err != nil {
+		return fmt.Errorf("duration must be a string like \"500ms\" or \"2s\": %v", err)
+	}
+	parsed, err := time.ParseDuration(value)
+	if err != nil {
+		return fmt.Errorf("invalid duration '%s': %v", value, err)
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+// SplitSpecs turns plain commands into split command specs
+func SplitSpecs(commands []string) []SplitCommandSpec {
+	specs := make([]SplitCommandSpec, len(commands))
+	for i, command := range commands {
+		specs[i] = SplitCommandSpec{Command: command}
+	}
+	return specs
+}
+
+// StreamOutputs returns every video destination: RTMPUrl or RTMPBase
+// with the stream key, if set, followed by Outputs
+func (c *Config) StreamOutputs() []OutputSpec {
+	var outputs []OutputSpec
+	if c.RTMPUrl != "" {
+		outputs = append(outputs, OutputSpec{URL: c.RTMPUrl, Format: "flv"})
+	} else if base := c.streamBase(); base != "" {
+		outputs = append(outputs, OutputSpec{URL: joinStreamKey(base, c.StreamKey), Format: "flv"})
+	}
+	return append(outputs, c.Outputs...)
+}
+
+// RedactPatterns returns the patterns whose matches are hidden in output
+func (c *Config) RedactPatterns() []string {
+	var patterns []string
+	if !c.NoDefaultRedact {
+		patterns = append(patterns, DefaultRedactPatterns...)
+	}
+	if c.StreamKey != "" {
+		patterns = append(patterns, regexp.QuoteMeta(c.StreamKey))
+	}
+	return append(patterns, c.Redact...)
+}
+
+// RendersVideo reports whether the renderer needs to run, either to
+// stream or to record video
+func (c *Config) RendersVideo() bool {
+	return len(c.StreamOutputs()) > 0 || c.RecordVideo
+}
+
+// usesFFmpeg reports whether the renderer needs FFmpeg
+func (c *Config) usesFFmpeg() bool {
+	return c.Renderer != "none"
 }
 
 // ThemePreset color schema
@@ -43,13 +250,37 @@ func GetDefaultConfig() Config {
 	return Config{
 		FFmpegPath:      "ffmpeg",
 		FontSize:        24,
+		TextPadding:     20,
+		BoxOpacity:      1,
 		FontColor:       "white",
 		BackgroundColor: "black",
 		TimestampFormat: "2006-01-02 15:04:05",
+		TimestampMode:   "absolute",
+		TimestampLines:  "per-line",
+		TimestampInterval: Duration(time.Second),
+		LineNumberScope: "command",
 		ScreenWidth:     1280,
 		ScreenHeight:    720,
 		RecordPath:      "./recordings",
+		RecordFlushInterval: Duration(time.Second),
+		RecordFormat:    "text",
+		LineEnding:      "lf",
+		TypingSpeed:     Duration(50 * time.Millisecond),
+		MaxLineBytes:    1024 * 1024,
+		StreamStartupSeconds: 2,
+		StreamGraceSeconds:   5,
+		AdaptiveMinFPS:       25,
+		AdaptiveWindow:       Duration(30 * time.Second),
+		AdaptiveMinBitrate:   "500k",
 		ThemeName:       "default",
+		MaxSplitCommands: 4,
+		LogLevel:        "info",
+		OutputFormat:    "text",
+		Renderer:        "ffmpeg",
+		CaptureMode:     "text",
+		CaptureDevice:   "Capture screen 0",
+		Encoder:         "libx264",
+		VAAPIDevice:     "/dev/dri/renderD128",
 		        EncoderPriority: []string{
     "mpeg4",
     "h264_nvenc",   
@@ -60,9 +291,25 @@ func GetDefaultConfig() Config {
 	}
 }
 
+// SupportedEncoders lists the video encoders ShellCast knows how to
+// configure. "auto" picks the first available one from EncoderPriority.
+var SupportedEncoders = []string{"libx264", "h264_nvenc", "h264_vaapi", "h264_videotoolbox", "auto"}
+
+// SupportedTimestampModes lists how timestamps can be shown. "absolute"
+// uses TimestampFormat; "relative" shows the time since the session started.
+var SupportedTimestampModes = []string{"absolute", "relative"}
+
+// SupportedOutputFormats lists the ways output lines can be formatted.
+// "json" emits one JSON object per line for other tools to consume.
+var SupportedOutputFormats = []string{"text", "json"}
+
+// SupportedCaptureModes lists where the video comes from. "text" renders
+// the output with drawtext; "screen" captures the screen on macOS.
+var SupportedCaptureModes = []string{"text", "screen"}
+
 // Predefined theme presets
 func GetThemePresets() map[string]ThemePreset {
-	return map[string]ThemePreset{
+	presets := map[string]ThemePreset{
 		"default": {
 			Name:            "Default",
 			FontColor:       "white",
@@ -99,6 +346,8 @@ func GetThemePresets() map[string]ThemePreset {
 			HighlightColor:  "#f92672",
 		},
 	}
+	addCustomThemes(presets)
+	return presets
 }
 
 // ApplyTheme applies a theme preset to the configuration
@@ -108,6 +357,9 @@ func (c *Config) ApplyTheme(themeName string) error {
 	if !exists {
 		return fmt.Errorf("theme '%s' not found", themeName)
 	}
+	if err := theme.Validate(); err != nil {
+		return err
+	}
 
 	c.ThemeName = themeName
 	c.FontColor = theme.FontColor
@@ -115,6 +367,200 @@ func (c *Config) ApplyTheme(themeName string) error {
 	return nil
 }
 
+// Validate checks the configuration for values that cannot work
+func (c *Config) Validate() error {
+	if c.FontSize <= 0 {
+		return fmt.Errorf("font size must be positive, got %d", c.FontSize)
+	}
+	if c.TextPadding < 0 {
+		return fmt.Errorf("text padding must not be negative, got %d", c.TextPadding)
+	}
+	if c.BoxOpacity < 0 || c.BoxOpacity > 1 {
+		return fmt.Errorf("box opacity must be between 0 and 1, got %g", c.BoxOpacity)
+	}
+	if strings.Contains(c.TextX+c.TextY, "'") {
+		return fmt.Errorf("text position must not contain quotes")
+	}
+	fontColor, err := NormalizeColor(c.FontColor)
+	if err != nil {
+		return fmt.Errorf("font color: %v", err)
+	}
+	backgroundColor, err := NormalizeColor(c.BackgroundColor)
+	if err != nil {
+		return fmt.Errorf("background color: %v", err)
+	}
+	c.FontColor, c.BackgroundColor = fontColor, backgroundColor
+	if c.ScreenWidth <= 0 || c.ScreenHeight <= 0 {
+		return fmt.Errorf("invalid screen size %dx%d", c.ScreenWidth, c.ScreenHeight)
+	}
+	if c.CommandTimeout < 0 {
+		return fmt.Errorf("command timeout must not be negative")
+	}
+	if c.RecordFlushInterval < 0 {
+		return fmt.Errorf("record flush interval must not be negative")
+	}
+	if c.RecordKeep < 0 || c.RecordMaxAge < 0 {
+		return fmt.Errorf("record_keep and record_max_age must not be negative")
+	}
+	if c.TypingSpeed < 0 {
+		return fmt.Errorf("typing speed must not be negative")
+	}
+	if c.IntroDuration < 0 {
+		return fmt.Errorf("intro duration must not be negative")
+	}
+	if c.StreamStartupSeconds < 0 || c.StreamGraceSeconds < 0 {
+		return fmt.Errorf("stream startup and grace seconds must not be negative")
+	}
+	if c.KeepaliveInterval < 0 || (c.KeepaliveInterval > 0 && c.KeepaliveInterval < Duration(time.Second)) {
+		return fmt.Errorf("keepalive interval must be 0 (off) or at least 1s, got %s", c.KeepaliveInterval)
+	}
+	if c.Adaptive {
+		if c.videoBitrate() == "" {
+			return fmt.Errorf("adaptive mode needs a video bitrate (-bitrate or -platform) to lower")
+		}
+		if !bitratePattern.MatchString(c.AdaptiveMinBitrate) {
+			return fmt.Errorf("invalid adaptive minimum bitrate '%s': use a number of bits per second like 500k", c.AdaptiveMinBitrate)
+		}
+		if c.AdaptiveMinFPS < 0 || c.AdaptiveMinFPS > 30 {
+			return fmt.Errorf("adaptive minimum fps must be between 0 and 30, got %g", c.AdaptiveMinFPS)
+		}
+		if c.AdaptiveWindow < Duration(adaptiveCheckInterval) {
+			return fmt.Errorf("adaptive window must be at least %s, got %s", adaptiveCheckInterval, c.AdaptiveWindow)
+		}
+	}
+	if c.MaxLineBytes <= 0 {
+		return fmt.Errorf("max line bytes must be positive, got %d", c.MaxLineBytes)
+	}
+	if c.MaxLinesPerSecond < 0 {
+		return fmt.Errorf("max lines per second must not be negative, got %d", c.MaxLinesPerSecond)
+	}
+	if c.MaxSplitCommands <= 0 {
+		return fmt.Errorf("max split commands must be at least 1, got %d", c.MaxSplitCommands)
+	}
+	if _, err := ParseLogLevel(c.LogLevel); err != nil {
+		return err
+	}
+	if err := checkPrefixTemplate(c.PrefixTemplate); err != nil {
+		return fmt.Errorf("prefix template: %v", err)
+	}
+	if !containsString(SupportedTimestampModes, c.TimestampMode) {
+		return fmt.Errorf("unsupported timestamp mode '%s' (supported: %s)",
+			c.TimestampMode, strings.Join(SupportedTimestampModes, ", "))
+	}
+	if !containsString(SupportedTimestampLines, c.TimestampLines) {
+		return fmt.Errorf("unsupported timestamp lines '%s' (supported: %s)",
+			c.TimestampLines, strings.Join(SupportedTimestampLines, ", "))
+	}
+	if c.TimestampLines != "per-line" && c.TimestampInterval <= 0 {
+		return fmt.Errorf("timestamp interval must be positive with %s timestamps, got %s", c.TimestampLines, c.TimestampInterval)
+	}
+	if !containsString(SupportedLineNumberScopes, c.LineNumberScope) {
+		return fmt.Errorf("unsupported line number scope '%s' (supported: %s)",
+			c.LineNumberScope, strings.Join(SupportedLineNumberScopes, ", "))
+	}
+	if !containsString(SupportedRecordFormats, c.RecordFormat) {
+		return fmt.Errorf("unsupported record format '%s' (supported: %s)",
+			c.RecordFormat, strings.Join(SupportedRecordFormats, ", "))
+	}
+	if c.isSubtitleFormat() && c.OutputFormat == "json" {
+		return fmt.Errorf("record format %s can't be used with JSON output", c.RecordFormat)
+	}
+	if !containsString(SupportedLineEndings, c.LineEnding) {
+		return fmt.Errorf("unsupported line ending '%s' (supported: %s)",
+			c.LineEnding, strings.Join(SupportedLineEndings, ", "))
+	}
+	if c.RawRecording && (c.isSubtitleFormat() || c.OutputFormat == "json") {
+		return fmt.Errorf("raw recordings are plain text and can't be used with JSON output or subtitle formats")
+	}
+	if !containsString(SupportedOutputFormats, c.OutputFormat) {
+		return fmt.Errorf("unsupported output format '%s' (supported: %s)",
+			c.OutputFormat, strings.Join(SupportedOutputFormats, ", "))
+	}
+	if _, err := compilePatterns(c.Highlight); err != nil {
+		return fmt.Errorf("highlight: %v", err)
+	}
+	if _, err := compilePatterns(c.Redact); err != nil {
+		return fmt.Errorf("redact: %v", err)
+	}
+	if _, err := compileFilter(c.IncludeRegex); err != nil {
+		return fmt.Errorf("include filter: %v", err)
+	}
+	if _, err := compileFilter(c.ExcludeRegex); err != nil {
+		return fmt.Errorf("exclude filter: %v", err)
+	}
+	for i, output := range c.Outputs {
+		if output.URL == "" {
+			return fmt.Errorf("output %d has no url", i+1)
+		}
+	}
+	if !containsString(SupportedRenderers, c.Renderer) {
+		return fmt.Errorf("unsupported renderer '%s' (supported: %s)",
+			c.Renderer, strings.Join(SupportedRenderers, ", "))
+	}
+	if !containsString(SupportedCaptureModes, c.CaptureMode) {
+		return fmt.Errorf("unsupported capture mode '%s' (supported: %s)",
+			c.CaptureMode, strings.Join(SupportedCaptureModes, ", "))
+	}
+	if c.CaptureMode == "screen" && runtime.GOOS != "darwin" && !c.DryRun {
+		return fmt.Errorf("screen capture is only supported on macOS")
+	}
+	if !containsString(SupportedEncoders, c.Encoder) {
+		return fmt.Errorf("unsupported encoder '%s' (supported: %s)",
+			c.Encoder, strings.Join(SupportedEncoders, ", "))
+	}
+	if err := checkPlatform(c.Platform); err != nil {
+		return err
+	}
+	if c.VideoBitrate != "" && !bitratePattern.MatchString(c.VideoBitrate) {
+		return fmt.Errorf("invalid video bitrate '%s': use a number of bits per second like 2500k or 4M", c.VideoBitrate)
+	}
+	if c.KeyframeSeconds < 0 {
+		return fmt.Errorf("keyframe seconds must not be negative")
+	}
+	if c.RTMPBase != "" && c.RTMPUrl != "" {
+		return fmt.Errorf("set either rtmp_url or rtmp_base, not both")
+	}
+	if c.RTMPUrl == "" && c.streamBase() != "" && c.StreamKey == "" {
+		return fmt.Errorf("streaming to %s needs a stream key: set %s or use -stream-key-prompt",
+			c.streamBase(), StreamKeyEnv)
+	}
+	if len(c.SplitCommands) > c.MaxSplitCommands {
+		return fmt.Errorf("%d split commands configured, but at most %d are allowed",
+			len(c.SplitCommands), c.MaxSplitCommands)
+	}
+	labels := make(map[string]bool)
+	for i := range c.SplitCommands {
+		spec := &c.SplitCommands[i]
+		if strings.TrimSpace(spec.Command) == "" {
+			return fmt.Errorf("split command %d is empty", i+1)
+		}
+		if spec.Label != "" {
+			if labels[spec.Label] {
+				return fmt.Errorf("split command label '%s' is used twice", spec.Label)
+			}
+			labels[spec.Label] = true
+		}
+		if spec.Color != "" {
+			color, err := NormalizeColor(spec.Color)
+			if err != nil {
+				return fmt.Errorf("split command %d color: %v", i+1, err)
+			}
+			spec.Color = color
+		}
+	}
+	return nil
+}
+
+// containsString reports whether list contains value
+func containsString(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
+
 // SaveConfig saves the configuration to a file
 func (c *Config) SaveConfig(filePath string) error {
 	data, err := json.MarshalIndent(c, "", "  ")
@@ -136,8 +582,67 @@ func (c *Config) SaveConfig(filePath string) error {
 	return nil
 }
 
+// DefaultConfigFile is the config file written and read when no path is
+// given
+const DefaultConfigFile = "shellcast_config.json"
+
+// ConfigSearchPaths returns where a config file is looked for when none
+// is given, in order: shellcast/config.json in the user's config
+// directory ($XDG_CONFIG_HOME, ~/.config on Linux), ~/.shellcast.json,
+// then DefaultConfigFile in the working directory
+func ConfigSearchPaths() []string {
+	var paths []string
+	if dir, err := os.UserConfigDir(); err == nil {
+		paths = append(paths, filepath.Join(dir, "shellcast", "config.json"))
+	}
+	if home, err := os.UserHomeDir(); err == nil {
+		paths = append(paths, filepath.Join(home, ".shellcast.json"))
+	}
+	return append(paths, DefaultConfigFile)
+}
+
+// FindConfigFile returns the first of ConfigSearchPaths that exists, or
+// "" if there is none
+func FindConfigFile() string {
+	for _, path := range ConfigSearchPaths() {
+		if info, err := os.Stat(path); err == nil && info.Mode().IsRegular() {
+			return path
+		}
+	}
+	return ""
+}
+
+// WriteDefaultConfig writes the default configuration, with every field
+// present, as a template to edit. An existing file is only replaced when
+// force is set.
+func WriteDefaultConfig(filePath string, force bool) error {
+	if !force {
+		if _, err := os.Stat(filePath); err == nil {
+			return fmt.Errorf("%s already exists (use -force to overwrite)", filePath)
+		}
+	}
+
+	// Show empty lists and maps rather than null
+	config := GetDefaultConfig()
+	config.SplitCommands = []SplitCommandSpec{}
+	config.Aliases = map[string]string{}
+	config.Highlight = []string{}
+	config.Redact = []string{}
+	config.ExtraFFmpegArgs = []string{}
+	config.Outputs = []OutputSpec{}
+	return config.SaveConfig(filePath)
+}
+
 // LoadConfig loads the configuration from a file
 func LoadConfig(filePath string) (Config, error) {
+	return LoadConfigProfile(filePath, "")
+}
+
+// LoadConfigProfile loads the config file like LoadConfig, then applies
+// the settings of the named profile in its profiles section on top.
+// Settings the profile doesn't mention keep their base value. An empty
+// name loads the base config.
+func LoadConfigProfile(filePath, profile string) (Config, error) {
 	config := GetDefaultConfig()
 
 	data, err := os.ReadFile(filePath)
@@ -152,9 +657,41 @@ func LoadConfig(filePath string) (Config, error) {
 		return config, fmt.Errorf("error unmarshaling config: %v", err)
 	}
 
+	if profile != "" {
+		settings, exists := config.Profiles[profile]
+		if !exists {
+			return config, fmt.Errorf("unknown profile %q in %s (available: %s)", profile, filePath, orNone(strings.Join(config.ProfileNames(), ", ")))
+		}
+		if err := json.Unmarshal(settings, &config); err != nil {
+			return config, fmt.Errorf("error unmarshaling profile %s: %v", profile, err)
+		}
+		config.profile = profile
+	}
+
+	// The stream key is kept out of config files
+	if config.streamBase() != "" {
+		config.StreamKey = os.Getenv(StreamKeyEnv)
+	}
+
 	return config, nil
 }
 
+// ProfileNames returns the names of the profiles in the config, sorted
+func (c *Config) ProfileNames() []string {
+	names := make([]string, 0, len(c.Profiles))
+	for name := range c.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Profile returns the name of the profile applied when the config was
+// loaded, or "" for the base config
+func (c *Config) Profile() string {
+	return c.profile
+}
+
 // ListThemes prints all available theme presets
 func ListThemes() {
 	presets := GetThemePresets()
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// durationType is handled separately from other int64 fields
+var durationType = reflect.TypeOf(Duration(0))
+
+// configField finds the Config field with the given JSON name. Only
+// fields of simple types, and lists of strings, can be read and set.
+func configField(config *Config, key string) (reflect.Value, error) {
+	value := reflect.ValueOf(config).Elem()
+	for i := 0; i < value.NumField(); i++ {
+		name := jsonName(value.Type().Field(i))
+		if name == key && settableKind(value.Field(i)) {
+			return value.Field(i), nil
+		}
+	}
+	return reflect.Value{}, fmt.Errorf("unknown config key '%s' (valid keys: %s)",
+		key, strings.Join(ConfigKeys(), ", "))
+}
+
+// jsonName returns the JSON name of a struct field, or "" if it is not
+// serialized
+func jsonName(field reflect.StructField) string {
+	name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+	if name == "-" {
+		return ""
+	}
+	return name
+}
+
+// settableKind reports whether a field can be set from a string
+func settableKind(field reflect.Value) bool {
+	switch field.Kind() {
+	case reflect.String, reflect.Bool, reflect.Int, reflect.Int64, reflect.Float64:
+		return true
+	case reflect.Slice:
+		return field.Type().Elem().Kind() == reflect.String
+	}
+	return false
+}
+
+// ConfigKeys lists the keys accepted by GetConfigValue and
+// SetConfigValue, sorted
+func ConfigKeys() []string {
+	var keys []string
+	var config Config
+	value := reflect.ValueOf(&config).Elem()
+	for i := 0; i < value.NumField(); i++ {
+		if name := jsonName(value.Type().Field(i)); name != "" && settableKind(value.Field(i)) {
+			keys = append(keys, name)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// GetConfigValue returns the value of a config field by its JSON name.
+// Durations are formatted like 1m30s and lists are comma separated.
+func GetConfigValue(config Config, key string) (string, error) {
+	field, err := configField(&config, key)
+	if err != nil {
+		return "", err
+	}
+
+	switch {
+	case field.Type() == durationType:
+		return time.Duration(field.Int()).String(), nil
+	case field.Kind() == reflect.Slice:
+		return strings.Join(field.Interface().([]string), ","), nil
+	default:
+		return fmt.Sprint(field.Interface()), nil
+	}
+}
+
+// SetConfigValue returns config with the field named key set to value,
+// parsed for the field's type, after checking the result with Validate.
+// Lists take comma separated values; an empty value clears them.
+func SetConfigValue(config Config, key, value string) (Config, error) {
+	updated := config
+	field, err := configField(&updated, key)
+	if err != nil {
+		return config, err
+	}
+
+	switch {
+	case field.Type() == durationType:
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return config, fmt.Errorf("%s needs a duration like 500ms or 2s, got '%s'", key, value)
+		}
+		field.SetInt(int64(d))
+	case field.Kind() == reflect.String:
+		field.SetString(value)
+	case field.Kind() == reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return config, fmt.Errorf("%s needs true or false, got '%s'", key, value)
+		}
+		field.SetBool(b)
+	case field.Kind() == reflect.Int || field.Kind() == reflect.Int64:
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return config, fmt.Errorf("%s needs a whole number, got '%s'", key, value)
+		}
+		field.SetInt(n)
+	case field.Kind() == reflect.Float64:
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return config, fmt.Errorf("%s needs a number, got '%s'", key, value)
+		}
+		field.SetFloat(f)
+	case field.Kind() == reflect.Slice:
+		var items []string
+		if value != "" {
+			items = strings.Split(value, ",")
+		}
+		field.Set(reflect.ValueOf(items))
+	}
+
+	if err := updated.Validate(); err != nil {
+		return config, err
+	}
+	return updated, nil
+}
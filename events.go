@@ -0,0 +1,38 @@
+package main
+
+// EventHandler observes a session, for programs embedding ShellCast and
+// for tests. Methods are called synchronously, OnLine from the goroutines
+// reading command output, so they must be safe for concurrent use and
+// return quickly. They must not call back into ShellCast's stream or
+// recording methods.
+type EventHandler interface {
+	OnStreamStart(targets string)
+	OnStreamStop()
+	OnRecordStart(path string)
+	OnRecordStop(path string)
+	// OnLine receives each complete output line that passes the filters,
+	// redacted but before formatting. stream is "stdout" or "stderr".
+	OnLine(stream, text string)
+	// OnCommandExit is called when a command ends. code is -1 if the
+	// command was killed by a signal.
+	OnCommandExit(command string, code int)
+}
+
+// NopEventHandler ignores every event. Embed it to handle only some.
+type NopEventHandler struct{}
+
+func (NopEventHandler) OnStreamStart(targets string)           {}
+func (NopEventHandler) OnStreamStop()                          {}
+func (NopEventHandler) OnRecordStart(path string)              {}
+func (NopEventHandler) OnRecordStop(path string)               {}
+func (NopEventHandler) OnLine(stream, text string)             {}
+func (NopEventHandler) OnCommandExit(command string, code int) {}
+
+// SetEventHandler installs handler, or the no-op handler if it is nil.
+// Call it before starting commands, streams or recordings.
+func (s *ShellCast) SetEventHandler(handler EventHandler) {
+	if handler == nil {
+		handler = NopEventHandler{}
+	}
+	s.events = handler
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ffmpegLogRate is how many FFmpeg messages a second are shown before
+// the rest of that second's are counted instead
+const ffmpegLogRate = 5
+
+// ffmpegStatsInterval is how often FFmpeg's own frame=... status line is
+// shown at debug level, where FFmpeg prints it several times a second
+const ffmpegStatsInterval = 5 * time.Second
+
+// ffmpegLog takes FFmpeg's stderr and passes it on line by line through
+// the logger, so it doesn't flood the terminal. Below debug level FFmpeg
+// runs with -loglevel error and every line is an error, shown at most
+// ffmpegLogRate times a second with a count of the ones left out. At
+// debug level everything is shown except most status lines.
+type ffmpegLog struct {
+	logger *Logger
+
+	mu        sync.Mutex
+	partial   []byte
+	window    time.Time
+	shown     int
+	dropped   int
+	lastStats time.Time
+}
+
+// newFFmpegLog returns a writer for the stderr of an FFmpeg process
+func (s *ShellCast) newFFmpegLog() *ffmpegLog {
+	return &ffmpegLog{logger: s.logger}
+}
+
+// Write logs every complete line in p. FFmpeg ends status lines with a
+// carriage return, so that ends a line too.
+func (l *ffmpegLog) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.partial = append(l.partial, p...)
+	for {
+		end := bytes.IndexAny(l.partial, "\r\n")
+		if end < 0 {
+			break
+		}
+		line := strings.TrimSpace(string(l.partial[:end]))
+		l.partial = l.partial[end+1:]
+		if line != "" {
+			l.logLine(line, time.Now())
+		}
+	}
+	return len(p), nil
+}
+
+// logLine shows one line of FFmpeg output, if the limits allow. The
+// caller must hold l.mu.
+func (l *ffmpegLog) logLine(line string, now time.Time) {
+	if l.logger.Level >= LogDebug {
+		if strings.HasPrefix(line, "frame=") || strings.HasPrefix(line, "size=") {
+			if now.Sub(l.lastStats) < ffmpegStatsInterval {
+				return
+			}
+			l.lastStats = now
+		}
+		l.logger.Debugf("ffmpeg: %s", line)
+		return
+	}
+
+	if now.Sub(l.window) >= time.Second {
+		if l.dropped > 0 {
+			l.logger.Errorf("FFmpeg: (%d more messages not shown, use -log-level debug to see them)", l.dropped)
+		}
+		l.window = now
+		l.shown = 0
+		l.dropped = 0
+	}
+	if l.shown >= ffmpegLogRate {
+		l.dropped++
+		return
+	}
+	l.shown++
+	l.logger.Errorf("FFmpeg: %s", line)
+}
+
+// Flush logs an unfinished last line and the count of messages left out
+// since the last one shown. Call it once FFmpeg has exited.
+func (l *ffmpegLog) Flush() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if line := strings.TrimSpace(string(l.partial)); line != "" {
+		l.logLine(line, time.Now())
+	}
+	l.partial = nil
+	if l.dropped > 0 {
+		l.logger.Errorf("FFmpeg: (%d more messages not shown, use -log-level debug to see them)", l.dropped)
+		l.dropped = 0
+	}
+}
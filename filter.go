@@ -0,0 +1,184 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// highlightMarker is put in front of lines matching a highlight pattern
+const highlightMarker = ">> "
+
+// redactReplacement replaces redacted text. The first capture group of a
+// pattern is kept, so "(password=)\S+" turns into "password=****".
+const redactReplacement = "${1}****"
+
+// DefaultRedactPatterns catch common secrets. They are used in addition
+// to Config.Redact unless Config.NoDefaultRedact is set.
+var DefaultRedactPatterns = []string{
+	`AKIA[0-9A-Z]{16}`,           // AWS access key IDs
+	`gh[pousr]_[A-Za-z0-9]{36,}`, // GitHub tokens
+	`(?i)(bearer\s+)[A-Za-z0-9._~+/=-]+`,
+	`(?i)((?:password|passwd|secret|token|api[_-]?key)\s*[=:]\s*)\S+`,
+}
+
+// redact replaces every match of the redaction patterns in line
+func (s *ShellCast) redact(line string) string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for _, re := range s.redactions {
+		line = re.ReplaceAllString(line, redactReplacement)
+	}
+	return line
+}
+
+// compilePatterns compiles a list of regular expressions
+func compilePatterns(patterns []string) ([]*regexp.Regexp, error) {
+	compiled := make([]*regexp.Regexp, 0, len(patterns))
+	for _, pattern := range patterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern '%s': %v", pattern, err)
+		}
+		compiled = append(compiled, re)
+	}
+	return compiled, nil
+}
+
+// matchesAny reports whether line matches one of the patterns
+func matchesAny(patterns []*regexp.Regexp, line string) bool {
+	for _, re := range patterns {
+		if re.MatchString(line) {
+			return true
+		}
+	}
+	return false
+}
+
+// compileConfigPatterns compiles the highlight and filter patterns of
+// the configuration. Patterns are checked by Config.Validate, so invalid
+// ones are ignored here.
+func (s *ShellCast) compileConfigPatterns() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	config := s.cfg()
+	s.highlights, _ = compilePatterns(config.Highlight)
+	s.redactions, _ = compilePatterns(config.RedactPatterns())
+	s.include, _ = compileFilter(config.IncludeRegex)
+	s.exclude, _ = compileFilter(config.ExcludeRegex)
+}
+
+// isHighlighted reports whether line matches a highlight pattern
+func (s *ShellCast) isHighlighted(line string) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return matchesAny(s.highlights, line)
+}
+
+// AddHighlight starts highlighting lines matching pattern
+func (s *ShellCast) AddHighlight(pattern string) error {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return fmt.Errorf("invalid pattern '%s': %v", pattern, err)
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.updateConfig(func(c *Config) {
+		c.Highlight = append(append([]string(nil), c.Highlight...), pattern)
+	})
+	s.highlights = append(s.highlights, re)
+	return nil
+}
+
+// RemoveHighlight stops highlighting lines matching pattern
+func (s *ShellCast) RemoveHighlight(pattern string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for i, existing := range s.cfg().Highlight {
+		if existing == pattern {
+			s.updateConfig(func(c *Config) {
+				c.Highlight = append(c.Highlight[:i:i], c.Highlight[i+1:]...)
+			})
+			s.highlights = append(s.highlights[:i:i], s.highlights[i+1:]...)
+			return nil
+		}
+	}
+	return fmt.Errorf("no such highlight pattern: %s", pattern)
+}
+
+// ClearHighlights removes all highlight patterns
+func (s *ShellCast) ClearHighlights() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.updateConfig(func(c *Config) { c.Highlight = nil })
+	s.highlights = nil
+}
+
+// compileFilter compiles an include or exclude pattern; an empty pattern
+// means no filter
+func compileFilter(pattern string) (*regexp.Regexp, error) {
+	if pattern == "" {
+		return nil, nil
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid pattern '%s': %v", pattern, err)
+	}
+	return re, nil
+}
+
+// passesFilters reports whether line should be forwarded to the buffer,
+// stream and recording: it must match the include pattern, if any, and
+// must not match the exclude pattern
+func (s *ShellCast) passesFilters(line string) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.include != nil && !s.include.MatchString(line) {
+		return false
+	}
+	if s.exclude != nil && s.exclude.MatchString(line) {
+		return false
+	}
+	return true
+}
+
+// SetIncludeFilter only forwards lines matching pattern; an empty pattern
+// forwards everything
+func (s *ShellCast) SetIncludeFilter(pattern string) error {
+	re, err := compileFilter(pattern)
+	if err != nil {
+		return err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.updateConfig(func(c *Config) { c.IncludeRegex = pattern })
+	s.include = re
+	return nil
+}
+
+// SetExcludeFilter drops lines matching pattern; an empty pattern drops
+// nothing
+func (s *ShellCast) SetExcludeFilter(pattern string) error {
+	re, err := compileFilter(pattern)
+	if err != nil {
+		return err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.updateConfig(func(c *Config) { c.ExcludeRegex = pattern })
+	s.exclude = re
+	return nil
+}
+
+// Highlights returns the current highlight patterns
+func (s *ShellCast) Highlights() []string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return append([]string(nil), s.cfg().Highlight...)
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	_ "embed"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// embeddedFont is DejaVu Sans Mono, see fonts/LICENSE. drawtext falls
+// back to it when it can't load the configured font, so some text is
+// shown even on hosts without fonts or fontconfig.
+//
+//go:embed fonts/DejaVuSansMono.ttf
+var embeddedFont []byte
+
+// embeddedFontName names the embedded font in messages
+const embeddedFontName = "DejaVu Sans Mono"
+
+// embeddedFontPattern names the file in the temp directory the embedded
+// font is written to for FFmpeg
+const embeddedFontPattern = "shellcast_font_*.ttf"
+
+// embeddedFontFile returns the path of the embedded font, writing it to a
+// temp file the first time, or again if the file went missing. The
+// caller must hold the mutex.
+func (s *ShellCast) embeddedFontFile() (string, error) {
+	if s.embeddedFontPath != "" {
+		if _, err := os.Stat(s.embeddedFontPath); err == nil {
+			return s.embeddedFontPath, nil
+		}
+	}
+
+	file, err := os.CreateTemp("", embeddedFontPattern)
+	if err != nil {
+		return "", fmt.Errorf("error creating font file: %v", err)
+	}
+	_, err = file.Write(embeddedFont)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(file.Name())
+		return "", fmt.Errorf("error writing font file: %v", err)
+	}
+	s.embeddedFontPath = file.Name()
+	return s.embeddedFontPath, nil
+}
+
+// removeEmbeddedFont deletes the temp file of the embedded font, if it
+// was written. The caller must hold the mutex.
+func (s *ShellCast) removeEmbeddedFont() {
+	if s.embeddedFontPath != "" {
+		os.Remove(s.embeddedFontPath)
+		s.embeddedFontPath = ""
+	}
+}
+
+// fontName describes font in messages
+func fontName(font string) string {
+	if font == "" {
+		return "FFmpeg's default font"
+	}
+	return font
+}
+
+// fontCandidates splits a FontFile setting, a comma-separated list of
+// font files in order of preference, into its entries
+func fontCandidates(setting string) []string {
+	var fonts []string
+	for _, font := range strings.Split(setting, ",") {
+		if font = strings.TrimSpace(font); font != "" {
+			fonts = append(fonts, font)
+		}
+	}
+	return fonts
+}
+
+// resolveFontFile returns the first font file listed in setting that
+// exists on this host, or "" if none does
+func resolveFontFile(setting string) string {
+	for _, font := range fontCandidates(setting) {
+		if info, err := os.Stat(font); err == nil && !info.IsDir() {
+			return font
+		}
+	}
+	return ""
+}
+
+// chooseFontFile resolves the FontFile setting to the font file drawtext
+// renders with. An empty setting uses the platform default; when none of
+// the listed files exist, the embedded font is used. The choice is
+// logged whenever it changes, so it is reported when the stream starts.
+func (s *ShellCast) chooseFontFile() string {
+	setting := s.cfg().FontFile
+	font := resolveFontFile(setting)
+	missing := setting != "" && font == ""
+
+	s.mutex.Lock()
+	var embeddedErr error
+	switch {
+	case missing:
+		font, embeddedErr = s.embeddedFontFile()
+		if embeddedErr != nil {
+			font = defaultFontFile()
+		}
+	case font == "":
+		font = defaultFontFile()
+	}
+	changed := font != s.chosenFont || setting != s.chosenFontSetting
+	s.chosenFont, s.chosenFontSetting = font, setting
+	s.mutex.Unlock()
+	if !changed {
+		return font
+	}
+
+	switch {
+	case missing && embeddedErr != nil:
+		s.logger.Errorf("None of the font files %s exist, and the embedded font is unavailable: %v; using %s", setting, embeddedErr, fontName(font))
+	case missing:
+		s.logger.Errorf("None of the font files %s exist, using the embedded font %s", setting, embeddedFontName)
+	case len(fontCandidates(setting)) > 1:
+		s.logger.Infof("Using font %s", font)
+	}
+	return font
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// gifFPS is the frame rate of GIF outputs. Terminal output rarely needs
+// more, and every frame adds to the file size.
+const gifFPS = 10
+
+// gifSourcePath is the MP4 a GIF output is rendered to while streaming.
+// A GIF's palette can only be chosen once all frames are known, so the
+// GIF is made from this file when the stream stops.
+func gifSourcePath(path string) string {
+	return path + ".part.mp4"
+}
+
+// gifPalettePath is where the palette of a GIF output is kept between
+// the two passes
+func gifPalettePath(path string) string {
+	return path + ".palette.png"
+}
+
+// liveOutputs returns the outputs FFmpeg writes while streaming, with
+// GIF outputs replaced by their MP4 source
+func (s *ShellCast) liveOutputs() []OutputSpec {
+	var outputs []OutputSpec
+	for _, output := range s.cfg().StreamOutputs() {
+		if outputFormat(output) == "gif" {
+			output = OutputSpec{URL: gifSourcePath(output.URL), Format: "mp4"}
+		}
+		outputs = append(outputs, output)
+	}
+	return outputs
+}
+
+// gifOutputs returns the GIF files to make when the stream stops
+func (s *ShellCast) gifOutputs() []string {
+	var paths []string
+	for _, output := range s.cfg().StreamOutputs() {
+		if outputFormat(output) == "gif" {
+			paths = append(paths, output.URL)
+		}
+	}
+	return paths
+}
+
+// makeGIF turns the MP4 source of the GIF at path into the GIF: the
+// first pass picks a palette for the whole recording, the second maps
+// the frames onto it. The source and palette are removed afterwards.
+func (s *ShellCast) makeGIF(path string) error {
+	ffmpegPath, err := s.resolveFFmpeg()
+	if err != nil {
+		return err
+	}
+	source, palette := gifSourcePath(path), gifPalettePath(path)
+	defer os.Remove(source)
+	defer os.Remove(palette)
+
+	filter := fmt.Sprintf("fps=%d,scale=%d:-1:flags=lanczos", gifFPS, s.cfg().ScreenWidth)
+	passes := [][]string{
+		{"-y", "-i", source, "-vf", filter + ",palettegen", palette},
+		{"-y", "-i", source, "-i", palette, "-lavfi", filter + "[x];[x][1:v]paletteuse", "-f", "gif", path},
+	}
+	for i, args := range passes {
+		args = append([]string{"-hide_banner", "-loglevel", "error"}, args...)
+		s.logger.Debugf("Running FFmpeg: %s %s", ffmpegPath, strings.Join(args, " "))
+
+		stderrLog := s.newFFmpegLog()
+		cmd := exec.Command(ffmpegPath, args...)
+		cmd.Stderr = stderrLog
+		err := cmd.Run()
+		stderrLog.Flush()
+		if err != nil {
+			return fmt.Errorf("error making GIF %s (pass %d): %v", path, i+1, err)
+		}
+	}
+	return nil
+}
@@ -2,28 +2,70 @@ package main
 
 import (
 	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"os"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
 )
 
 // InteractiveOptions
 type InteractiveOptions struct {
-	ConfigPath string
+	ConfigPath string // config file in use, written back with autosave
 }
 
 // Interactive shell
 func RunInteractiveMode(sc *ShellCast, options InteractiveOptions) {
 	reader := bufio.NewReader(os.Stdin)
 
-	fmt.Println("ShellCast Interactive Mode")
-	fmt.Println("==========================")
-	fmt.Println("Type 'help' for available commands")
-	fmt.Println("Type 'exit' or 'quit' to exit")
+	// With autosave, a command that changed the configuration has it
+	// written back to the config file in use. The check runs before the
+	// next command and on exit, so it covers every command.
+	configPath := options.ConfigPath
+	var before []byte
+
+	// Lines entered so far, after history expansion, for history and !n
+	var history []string
+
+	// Split commands run in the background, so they can be listed with
+	// jobs and stopped with kill. Leaving waits for them.
+	var splitDone chan struct{}
+	defer func() {
+		if splitDone != nil {
+			<-splitDone
+		}
+	}()
+	checkAutoSave := func() {
+		if before == nil || !sc.cfg().AutoSave || configPath == "" {
+			return
+		}
+		after, err := json.Marshal(sc.cfg())
+		if err != nil || bytes.Equal(before, after) {
+			return
+		}
+		if err := sc.cfg().SaveConfig(configPath); err != nil {
+			fmt.Fprintf(os.Stderr, "Error saving config: %v\n", err)
+		} else {
+			sc.logger.Debugf("Config saved to %s", configPath)
+		}
+	}
+	defer checkAutoSave()
+
+	sc.logger.Infof("ShellCast Interactive Mode")
+	sc.logger.Infof("==========================")
+	sc.logger.Infof("Type 'help' for available commands")
+	sc.logger.Infof("Type 'exit' or 'quit' to exit")
 
 	for {
-		fmt.Print("\nshellcast> ")
+		checkAutoSave()
+		before, _ = json.Marshal(sc.cfg())
+
+		fmt.Fprint(os.Stderr, "\nshellcast> ")
 		input, err := reader.ReadString('\n')
 		if err != nil {
 			if err == io.EOF {
@@ -33,11 +75,52 @@ func RunInteractiveMode(sc *ShellCast, options InteractiveOptions) {
 			continue
 		}
 
-		input = strings.TrimSpace(input)
+		// A trailing backslash continues the line
+		input, err = readContinuation(reader, strings.TrimSpace(input))
+		if err != nil {
+			fmt.Fprintln(os.Stderr)
+			break
+		}
 		if input == "" {
 			continue
 		}
 
+		// begin starts a block of lines run as one shell script. Without
+		// shell mode the block is still read, so none of its lines runs
+		// on its own.
+		if strings.ToLower(input) == "begin" {
+			script, err := readBlock(reader)
+			if err != nil {
+				fmt.Fprintln(os.Stderr)
+				break
+			}
+			if !sc.cfg().ShellMode {
+				fmt.Fprintln(os.Stderr, "begin needs shell mode: start with -shell or use 'config set shell_mode true'")
+				continue
+			}
+			if script != "" {
+				history = append(history, script)
+				runCommand(sc, script)
+			}
+			continue
+		}
+
+		// Expand !!, !n and !prefix, showing the line that runs like a
+		// shell does
+		if strings.HasPrefix(input, "!") {
+			expanded, err := expandHistory(history, input)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				continue
+			}
+			fmt.Fprintln(os.Stderr, expanded)
+			input = expanded
+		}
+		history = append(history, input)
+
+		// Expand a user-defined alias in the first word
+		input = expandAlias(sc.cfg().Aliases, input)
+
 		// Split input into command and arguments
 		parts := strings.SplitN(input, " ", 2)
 		cmd := strings.ToLower(parts[0])
@@ -54,27 +137,168 @@ func RunInteractiveMode(sc *ShellCast, options InteractiveOptions) {
 		case "help":
 			showHelp()
 
+		case "status":
+			showStatus(sc.Status())
+
+		case "grep":
+			grepOutput(sc, args)
+
+		case "history":
+			for i, line := range history {
+				fmt.Printf("%5d  %s\n", i+1, line)
+			}
+
+		case "run":
+			// Always execute as a shell command, even if it matches a built-in
+			if args == "" {
+				fmt.Fprintln(os.Stderr, "Usage: run COMMAND [ARGS...]")
+				continue
+			}
+
+			if err := sc.ExecuteCommand(args); err != nil {
+				fmt.Fprintf(os.Stderr, "Command error: %v\n", err)
+			}
+
+		case "alias":
+			if args == "" {
+				listAliases(sc.cfg().Aliases)
+				continue
+			}
+
+			name, command, ok := strings.Cut(args, "=")
+			name = strings.TrimSpace(name)
+			command = strings.TrimSpace(command)
+			if !ok || name == "" || command == "" || strings.ContainsAny(name, " \t") {
+				fmt.Fprintln(os.Stderr, "Usage: alias NAME=COMMAND")
+				continue
+			}
+
+			sc.updateConfig(func(c *Config) { c.Aliases = setAlias(c.Aliases, name, command) })
+			sc.logger.Infof("Alias set: %s=%s", name, command)
+
+		case "unalias":
+			if _, exists := sc.cfg().Aliases[args]; !exists {
+				fmt.Fprintf(os.Stderr, "No such alias: %s\n", args)
+				continue
+			}
+
+			sc.updateConfig(func(c *Config) { c.Aliases = setAlias(c.Aliases, args, "") })
+			sc.logger.Infof("Alias removed: %s", args)
+
 		case "stream":
-			if sc.config.RTMPUrl == "" {
-				fmt.Print("Enter RTMP URL: ")
+			if !sc.cfg().RendersVideo() {
+				fmt.Fprint(os.Stderr, "Enter RTMP URL: ")
 				rtmpUrl, _ := reader.ReadString('\n')
 				rtmpUrl = strings.TrimSpace(rtmpUrl)
 				if rtmpUrl == "" {
-					fmt.Println("No RTMP URL provided")
+					fmt.Fprintln(os.Stderr, "No RTMP URL provided")
+					continue
+				}
+				sc.updateConfig(func(c *Config) { c.RTMPUrl = rtmpUrl })
+			}
+
+			if sc.cfg().usesFFmpeg() && !sc.cfg().DryRun {
+				if err := sc.CheckFFmpeg(); err != nil {
+					fmt.Fprintf(os.Stderr, "Cannot stream: %v\n", err)
 					continue
 				}
-				sc.config.RTMPUrl = rtmpUrl
 			}
 
 			if err := sc.StartStreaming(); err != nil {
 				fmt.Fprintf(os.Stderr, "Error starting stream: %v\n", err)
 			}
 
+		case "clear":
+			if err := sc.ClearBuffer(); err != nil {
+				fmt.Fprintf(os.Stderr, "Error clearing buffer: %v\n", err)
+				continue
+			}
+			// Clear the terminal too, unless it is no terminal
+			if isTerminal(os.Stderr) {
+				fmt.Fprint(os.Stderr, "\033[H\033[2J")
+			}
+			sc.logger.Infof("Output buffer cleared")
+
+		case "dump":
+			if args == "" {
+				args = fmt.Sprintf("shellcast_dump_%s.txt", time.Now().Format("2006-01-02_15-04-05"))
+			}
+
+			if err := os.WriteFile(args, []byte(sc.cfg().withLineEndings(sc.Snapshot())), 0644); err != nil {
+				fmt.Fprintf(os.Stderr, "Error writing dump: %v\n", err)
+			} else {
+				sc.logger.Infof("Output buffer written to %s", args)
+			}
+
+		case "highlight":
+			action, pattern, _ := strings.Cut(args, " ")
+			pattern = strings.TrimSpace(pattern)
+			switch {
+			case action == "":
+				patterns := sc.Highlights()
+				if len(patterns) == 0 {
+					fmt.Println("No highlight patterns")
+				}
+				for _, p := range patterns {
+					fmt.Println(p)
+				}
+			case action == "add" && pattern != "":
+				if err := sc.AddHighlight(pattern); err != nil {
+					fmt.Fprintf(os.Stderr, "Error adding highlight: %v\n", err)
+				} else {
+					sc.logger.Infof("Highlighting lines matching %s", pattern)
+				}
+			case action == "remove" && pattern != "":
+				if err := sc.RemoveHighlight(pattern); err != nil {
+					fmt.Fprintf(os.Stderr, "Error removing highlight: %v\n", err)
+				} else {
+					sc.logger.Infof("Highlight removed: %s", pattern)
+				}
+			case action == "clear":
+				sc.ClearHighlights()
+				sc.logger.Infof("Highlights cleared")
+			default:
+				fmt.Fprintln(os.Stderr, "Usage: highlight [add REGEX|remove REGEX|clear]")
+			}
+
+		case "filter":
+			action, pattern, _ := strings.Cut(args, " ")
+			pattern = strings.TrimSpace(pattern)
+			switch action {
+			case "":
+				fmt.Printf("Include: %s\n", orNone(sc.cfg().IncludeRegex))
+				fmt.Printf("Exclude: %s\n", orNone(sc.cfg().ExcludeRegex))
+			case "include":
+				if err := sc.SetIncludeFilter(pattern); err != nil {
+					fmt.Fprintf(os.Stderr, "Error setting filter: %v\n", err)
+				} else {
+					sc.logger.Infof("Include filter: %s", orNone(pattern))
+				}
+			case "exclude":
+				if err := sc.SetExcludeFilter(pattern); err != nil {
+					fmt.Fprintf(os.Stderr, "Error setting filter: %v\n", err)
+				} else {
+					sc.logger.Infof("Exclude filter: %s", orNone(pattern))
+				}
+			case "clear":
+				sc.SetIncludeFilter("")
+				sc.SetExcludeFilter("")
+				sc.logger.Infof("Filters cleared")
+			default:
+				fmt.Fprintln(os.Stderr, "Usage: filter [include REGEX|exclude REGEX|clear]")
+			}
+
+		case "ffmpeg-args":
+			fmt.Println(sc.FFmpegCommandLine())
+
 		case "stop":
 			if err := sc.StopStreaming(); err != nil {
 				fmt.Fprintf(os.Stderr, "Error stopping stream: %v\n", err)
 			}
 
+		case "pause", "resume":
+			pauseOrResume(sc, cmd, args)
+
 		case "record":
 			if err := sc.StartRecording(); err != nil {
 				fmt.Fprintf(os.Stderr, "Error starting recording: %v\n", err)
@@ -85,109 +309,579 @@ func RunInteractiveMode(sc *ShellCast, options InteractiveOptions) {
 				fmt.Fprintf(os.Stderr, "Error stopping recording: %v\n", err)
 			}
 
+		case "rotate":
+			if err := sc.RotateRecording(); err != nil {
+				fmt.Fprintf(os.Stderr, "Error rotating recording: %v\n", err)
+			}
+
 		case "theme":
 			if args == "" {
 				ListThemes()
 				continue
 			}
 
-			if err := sc.config.ApplyTheme(args); err != nil {
+			if err := sc.SetTheme(args); err != nil {
 				fmt.Fprintf(os.Stderr, "Error applying theme: %v\n", err)
 			} else {
-				fmt.Printf("Applied theme: %s\n", args)
+				sc.logger.Infof("Applied theme: %s", args)
 			}
 
 		case "timestamp":
 			switch args {
 			case "on":
-				sc.config.ShowTimestamp = true
-				fmt.Println("Timestamps enabled")
+				sc.updateConfig(func(c *Config) { c.ShowTimestamp = true })
+				sc.logger.Infof("Timestamps enabled")
 			case "off":
-				sc.config.ShowTimestamp = false
-				fmt.Println("Timestamps disabled")
+				sc.updateConfig(func(c *Config) { c.ShowTimestamp = false })
+				sc.logger.Infof("Timestamps disabled")
+			case "absolute", "relative":
+				sc.updateConfig(func(c *Config) {
+					c.ShowTimestamp = true
+					c.TimestampMode = args
+				})
+				sc.logger.Infof("Timestamps enabled (%s)", args)
 			default:
-				fmt.Println("Usage: timestamp [on|off]")
+				fmt.Fprintln(os.Stderr, "Usage: timestamp [on|off|absolute|relative]")
 			}
 
 		case "size":
 			if args == "" {
 				fmt.Printf("Current screen size: %dx%d\n",
-					sc.config.ScreenWidth, sc.config.ScreenHeight)
+					sc.cfg().ScreenWidth, sc.cfg().ScreenHeight)
 				continue
 			}
 
 			var width, height int
 			if _, err := fmt.Sscanf(args, "%dx%d", &width, &height); err != nil {
-				fmt.Println("Usage: size WIDTHxHEIGHT (e.g., 1280x720)")
+				fmt.Fprintln(os.Stderr, "Usage: size WIDTHxHEIGHT (e.g., 1280x720)")
 				continue
 			}
 
-			sc.config.ScreenWidth = width
-			sc.config.ScreenHeight = height
-			fmt.Printf("Screen size set to %dx%d\n", width, height)
+			sc.updateConfig(func(c *Config) {
+				c.ScreenWidth = width
+				c.ScreenHeight = height
+			})
+			sc.logger.Infof("Screen size set to %dx%d", width, height)
 
 		case "split":
 			// Parse command list
 			if args == "" {
-				fmt.Println("Usage: split \"command1\" \"command2\" ...")
+				fmt.Fprintln(os.Stderr, "Usage: split \"command1\" \"command2\" ...")
 				continue
 			}
 
-			// Very simple parsing for demonstration
-			commands := strings.Split(args, "\" \"")
-			commands[0] = strings.TrimPrefix(commands[0], "\"")
-			commands[len(commands)-1] = strings.TrimSuffix(commands[len(commands)-1], "\"")
+			commands, err := parseSplitCommands(args)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error parsing split commands: %v\n", err)
+				continue
+			}
+			if len(commands) == 0 {
+				fmt.Fprintln(os.Stderr, "Usage: split \"command1\" \"command2\" ...")
+				continue
+			}
 
-			fmt.Printf("Running %d commands in split mode\n", len(commands))
-			if err := sc.ExecuteSplitCommands(commands); err != nil {
-				fmt.Fprintf(os.Stderr, "Error executing split commands: %v\n", err)
+			if len(sc.SplitJobs()) > 0 {
+				fmt.Fprintln(os.Stderr, "Split commands are already running; see jobs")
+				continue
+			}
+
+			sc.logger.Infof("Running %d commands in split mode (see jobs and kill)", len(commands))
+			done := make(chan struct{})
+			splitDone = done
+			go func() {
+				defer close(done)
+				if err := sc.ExecuteSplitCommands(commands); err != nil {
+					fmt.Fprintf(os.Stderr, "Error executing split commands: %v\n", err)
+				} else {
+					sc.logger.Infof("Split commands finished")
+				}
+			}()
+
+		case "jobs":
+			jobs := sc.SplitJobs()
+			if len(jobs) == 0 {
+				fmt.Println("No split commands running")
+				continue
+			}
+			for _, job := range jobs {
+				state := "running"
+				if job.Killed {
+					state = "killed"
+				} else if !job.Running {
+					state = "done"
+				}
+				pid := "-"
+				if job.PID != 0 {
+					pid = fmt.Sprintf("%d", job.PID)
+				}
+				fmt.Printf("[%d] %-8s %-7s %-8s %s\n", job.Index, pid, state, job.Label, job.Command)
+			}
+
+		case "kill":
+			index, err := strconv.Atoi(args)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, "Usage: kill INDEX (see jobs)")
+				continue
+			}
+			if err := sc.KillSplitJob(index); err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
 			}
 
 		case "fontsize":
 			if args == "" {
-				fmt.Printf("Current font size: %d\n", sc.config.FontSize)
+				fmt.Printf("Current font size: %d\n", sc.cfg().FontSize)
 				continue
 			}
 
 			var size int
 			if _, err := fmt.Sscanf(args, "%d", &size); err != nil {
-				fmt.Println("Usage: fontsize SIZE (e.g., 24)")
+				fmt.Fprintln(os.Stderr, "Usage: fontsize SIZE (e.g., 24)")
 				continue
 			}
 
-			sc.config.FontSize = size
-			fmt.Printf("Font size set to %d\n", size)
+			sc.updateConfig(func(c *Config) { c.FontSize = size })
+			sc.logger.Infof("Font size set to %d", size)
+
+		case "config":
+			configCommand(sc, args)
 
 		case "save":
 			if args == "" {
-				args = "shellcast_config.json"
+				args = options.ConfigPath
+			}
+			if args == "" {
+				args = DefaultConfigFile
 			}
 
-			if err := sc.config.SaveConfig(args); err != nil {
+			if err := sc.cfg().SaveConfig(args); err != nil {
 				fmt.Fprintf(os.Stderr, "Error saving config: %v\n", err)
 			} else {
-				fmt.Printf("Config saved to %s\n", args)
+				configPath = args
+				sc.logger.Infof("Config saved to %s", args)
+			}
+
+		case "autosave":
+			switch args {
+			case "":
+				fmt.Printf("Autosave: %s\n", onOff(sc.cfg().AutoSave))
+				if configPath != "" {
+					fmt.Printf("Config file: %s\n", configPath)
+				}
+				continue
+			case "on":
+				if configPath == "" {
+					fmt.Fprintln(os.Stderr, "No config file in use; load or save one first")
+					continue
+				}
+				sc.updateConfig(func(c *Config) { c.AutoSave = true })
+			case "off":
+				sc.updateConfig(func(c *Config) { c.AutoSave = false })
+				// Remember the choice in the file too
+				if configPath != "" {
+					if err := sc.cfg().SaveConfig(configPath); err != nil {
+						fmt.Fprintf(os.Stderr, "Error saving config: %v\n", err)
+					}
+				}
+			default:
+				fmt.Fprintln(os.Stderr, "Usage: autosave [on|off]")
+				continue
+			}
+			sc.logger.Infof("Autosave %s", onOff(sc.cfg().AutoSave))
+
+		case "init":
+			if args == "" {
+				args = DefaultConfigFile
+			}
+
+			if err := WriteDefaultConfig(args, false); err != nil {
+				fmt.Fprintf(os.Stderr, "Error writing config: %v\n", err)
+			} else {
+				sc.logger.Infof("Default config written to %s", args)
 			}
 
 		case "load":
 			if args == "" {
-				args = "shellcast_config.json"
+				args = options.ConfigPath
+			}
+			if args == "" {
+				args = DefaultConfigFile
 			}
 
-			config, err := LoadConfig(args)
+			// The profile in use stays applied
+			config, err := LoadConfigProfile(args, sc.cfg().Profile())
+			if config.streamBase() != "" && config.StreamKey == "" {
+				config.StreamKey = sc.cfg().StreamKey
+			}
+			if err == nil {
+				err = config.Validate()
+			}
 			if err != nil {
 				fmt.Fprintf(os.Stderr, "Error loading config: %v\n", err)
 			} else {
-				sc.config = config
-				fmt.Printf("Config loaded from %s\n", args)
+				if sc.ReloadConfig(config) {
+					sc.logger.Infof("Video settings changed; restart the stream (stop, stream) to apply them")
+				}
+				configPath = args
+				before, _ = json.Marshal(sc.cfg())
+				sc.logger.Infof("Config loaded from %s", args)
 			}
 
 		default:
-			if err := sc.ExecuteCommand(input); err != nil {
-				fmt.Fprintf(os.Stderr, "Command error: %v\n", err)
+			runCommand(sc, input)
+		}
+	}
+}
+
+// grepMaxResults is how many matches grep shows unless -m says otherwise
+const grepMaxResults = 100
+
+// grepOutput searches the buffered output for grep [-i] [-m N] PATTERN
+// and prints the matching lines with their line numbers
+func grepOutput(sc *ShellCast, args string) {
+	usage := "Usage: grep [-i] [-m N] PATTERN"
+	ignoreCase, max := false, grepMaxResults
+	fields := strings.Fields(args)
+	for len(fields) > 0 && strings.HasPrefix(fields[0], "-") {
+		switch fields[0] {
+		case "-i":
+			ignoreCase = true
+			fields = fields[1:]
+		case "-m":
+			if len(fields) < 2 {
+				fmt.Fprintln(os.Stderr, usage)
+				return
 			}
+			n, err := strconv.Atoi(fields[1])
+			if err != nil || n < 0 {
+				fmt.Fprintf(os.Stderr, "Invalid result limit %q\n", fields[1])
+				return
+			}
+			max = n
+			fields = fields[2:]
+		default:
+			fmt.Fprintln(os.Stderr, usage)
+			return
 		}
 	}
+	if len(fields) == 0 {
+		fmt.Fprintln(os.Stderr, usage)
+		return
+	}
+
+	// The pattern may contain spaces
+	pattern := strings.Join(fields, " ")
+	matches, total, err := sc.SearchOutput(pattern, ignoreCase, max)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return
+	}
+	for _, match := range matches {
+		fmt.Printf("%5d  %s\n", match.Line, match.Text)
+	}
+	if total == 0 {
+		fmt.Fprintln(os.Stderr, "No matches")
+	} else if total > len(matches) {
+		fmt.Fprintf(os.Stderr, "(%d more matches not shown, use -m to show more)\n", total-len(matches))
+	}
+}
+
+// runCommand runs a line or script that isn't a built-in command,
+// explaining a command that wasn't found
+func runCommand(sc *ShellCast, input string) {
+	err := sc.ExecuteCommand(input)
+	var notFound *CommandNotFoundError
+	if errors.As(err, &notFound) {
+		fmt.Fprintf(os.Stderr, "%s: command not found\n", notFound.Name)
+		fmt.Fprintf(os.Stderr, "Check that it is installed and in your PATH, or type 'help' for ShellCast commands.\n")
+	} else if err != nil {
+		fmt.Fprintf(os.Stderr, "Command error: %v\n", err)
+	}
+}
+
+// continuationPrompt is shown while a line or block is being continued
+const continuationPrompt = "...> "
+
+// readContinuation joins line with the lines that follow it as long as
+// each ends with a backslash, which is dropped. The parts are joined with
+// a space, so indentation of the continued lines doesn't matter.
+func readContinuation(reader *bufio.Reader, line string) (string, error) {
+	for strings.HasSuffix(line, "\\") {
+		fmt.Fprint(os.Stderr, continuationPrompt)
+		next, err := reader.ReadString('\n')
+		if err != nil && next == "" {
+			return "", err
+		}
+		line = strings.TrimSpace(strings.TrimSuffix(line, "\\")) + " " + strings.TrimSpace(next)
+	}
+	return strings.TrimSpace(line), nil
+}
+
+// readBlock reads lines up to a line with just "end" and returns them
+// as one script. Lines keep their indentation, and a trailing backslash
+// is left for the shell to handle.
+func readBlock(reader *bufio.Reader) (string, error) {
+	var lines []string
+	for {
+		fmt.Fprint(os.Stderr, continuationPrompt)
+		line, err := reader.ReadString('\n')
+		if err != nil && line == "" {
+			return "", err
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if strings.TrimSpace(line) == "end" {
+			return strings.TrimSpace(strings.Join(lines, "\n")), nil
+		}
+		lines = append(lines, line)
+	}
+}
+
+// parseSplitCommands splits the arguments of the split command into one
+// command per argument, so quoted arguments may contain spaces. Input
+// without any quotes is taken as a single command.
+func parseSplitCommands(args string) ([]string, error) {
+	if !strings.ContainsAny(args, "\"'") {
+		args = strings.TrimSpace(args)
+		if args == "" {
+			return nil, nil
+		}
+		return []string{args}, nil
+	}
+
+	return splitArgs(args)
+}
+
+// expandAlias replaces the first word of input with its alias, if any.
+// Expansion happens once, so an alias may refer to a built-in command.
+func expandAlias(aliases map[string]string, input string) string {
+	name, rest, _ := strings.Cut(input, " ")
+	command, exists := aliases[name]
+	if !exists {
+		return input
+	}
+
+	if rest != "" {
+		return command + " " + rest
+	}
+	return command
+}
+
+// expandHistory replaces a history reference at the start of input with
+// the line it refers to: !! is the last line, !n the nth (negative
+// counts back from the end) and !prefix the most recent line starting
+// with prefix. Anything after the reference is appended.
+func expandHistory(history []string, input string) (string, error) {
+	ref, rest, _ := strings.Cut(input, " ")
+	event := strings.TrimPrefix(ref, "!")
+
+	var line string
+	switch n, err := strconv.Atoi(event); {
+	case event == "":
+		return "", fmt.Errorf("%s: missing history reference, use !!, !n or !prefix", ref)
+	case len(history) == 0:
+		return "", fmt.Errorf("%s: history is empty", ref)
+	case event == "!":
+		line = history[len(history)-1]
+	case err == nil:
+		if n < 0 {
+			n += len(history) + 1
+		}
+		if n < 1 || n > len(history) {
+			return "", fmt.Errorf("%s: no such line in history (1-%d)", ref, len(history))
+		}
+		line = history[n-1]
+	default:
+		for i := len(history) - 1; i >= 0 && line == ""; i-- {
+			if strings.HasPrefix(history[i], event) {
+				line = history[i]
+			}
+		}
+		if line == "" {
+			return "", fmt.Errorf("%s: no line in history starts with %q", ref, event)
+		}
+	}
+
+	if rest != "" {
+		return line + " " + rest, nil
+	}
+	return line, nil
+}
+
+// setAlias returns a copy of aliases with name set to command, or
+// removed if command is empty. aliases itself is left as it is, since a
+// configuration is never changed in place.
+func setAlias(aliases map[string]string, name, command string) map[string]string {
+	changed := make(map[string]string, len(aliases)+1)
+	for alias, expansion := range aliases {
+		changed[alias] = expansion
+	}
+	if command == "" {
+		delete(changed, name)
+	} else {
+		changed[name] = command
+	}
+	return changed
+}
+
+// listAliases prints all defined aliases sorted by name
+func listAliases(aliases map[string]string) {
+	if len(aliases) == 0 {
+		fmt.Println("No aliases defined")
+		return
+	}
+
+	names := make([]string, 0, len(aliases))
+	for name := range aliases {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		fmt.Printf("%s=%s\n", name, aliases[name])
+	}
+}
+
+// pauseOrResume pauses or resumes streaming and/or recording. With no
+// target, whatever is active is paused or resumed.
+func pauseOrResume(sc *ShellCast, action, target string) {
+	status := sc.Status()
+	stream := target == "stream" || (target == "" && status.Streaming)
+	record := target == "record" || (target == "" && status.Recording)
+	if target != "" && target != "stream" && target != "record" {
+		fmt.Fprintf(os.Stderr, "Usage: %s [stream|record]\n", action)
+		return
+	}
+	if !stream && !record {
+		fmt.Fprintln(os.Stderr, "Not streaming or recording")
+		return
+	}
+
+	if stream {
+		var err error
+		if action == "pause" {
+			err = sc.PauseStreaming()
+		} else {
+			err = sc.ResumeStreaming()
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		} else {
+			sc.logger.Infof("Streaming %sd", action)
+		}
+	}
+
+	if record {
+		var err error
+		if action == "pause" {
+			err = sc.PauseRecording()
+		} else {
+			err = sc.ResumeRecording()
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		} else {
+			sc.logger.Infof("Recording %sd", action)
+		}
+	}
+}
+
+// orNone returns value, or "(none)" when it is empty
+func orNone(value string) string {
+	if value == "" {
+		return "(none)"
+	}
+	return value
+}
+
+// onOff formats a setting as on or off
+func onOff(on bool) string {
+	if on {
+		return "on"
+	}
+	return "off"
+}
+
+// showStatus displays a session status snapshot
+func showStatus(status SessionStatus) {
+	fmt.Printf("Session time: %s\n", status.Elapsed.Round(time.Second))
+	fmt.Printf("Streaming:    %s", onOff(status.Streaming))
+	if status.StreamPaused {
+		fmt.Print(", paused")
+	}
+	if status.RTMPUrl != "" {
+		fmt.Printf(" (%s)", status.RTMPUrl)
+	}
+	fmt.Println()
+	if status.Streaming && !status.Stream.Updated.IsZero() {
+		stats := status.Stream
+		fmt.Printf("Encoding:     %d frames, %.1f fps, %s, speed %s, %d dropped, %d duplicated\n",
+			stats.Frames, stats.FPS, stats.Bitrate, stats.Speed, stats.DroppedFrames, stats.DupFrames)
+	}
+	fmt.Printf("Recording:    %s", onOff(status.Recording))
+	if status.RecordPaused {
+		fmt.Print(", paused")
+	}
+	if status.Recording {
+		fmt.Printf(" (%s)", status.RecordPath)
+	}
+	fmt.Println()
+	fmt.Printf("Serving:      %s", onOff(status.Serving))
+	if status.Serving {
+		fmt.Printf(" (%s)", status.ServeAddr)
+	}
+	fmt.Println()
+	fmt.Printf("Buffer:       %d lines\n", status.BufferLines)
+	if status.DroppedLines > 0 {
+		fmt.Printf("Dropped:      %d lines (over the stream rate limit)\n", status.DroppedLines)
+	}
+	fmt.Printf("Running:      %d commands\n", status.RunningCount)
+	fmt.Printf("Theme:        %s\n", status.ThemeName)
+	fmt.Printf("Screen size:  %dx%d\n", status.ScreenWidth, status.ScreenHeight)
+	fmt.Printf("Font size:    %d\n", status.FontSize)
+	fmt.Printf("Timestamps:   %s\n", onOff(status.ShowTimestamp))
+}
+
+// configCommand lists, shows or changes settings by their config file
+// names
+func configCommand(sc *ShellCast, args string) {
+	action, rest, _ := strings.Cut(args, " ")
+	key, value, _ := strings.Cut(strings.TrimSpace(rest), " ")
+	value = strings.TrimSpace(value)
+
+	switch action {
+	case "", "list":
+		for _, key := range ConfigKeys() {
+			value, _ := GetConfigValue(*sc.cfg(), key)
+			fmt.Printf("%-24s %s\n", key, value)
+		}
+
+	case "get":
+		if key == "" {
+			fmt.Fprintln(os.Stderr, "Usage: config get KEY")
+			return
+		}
+		value, err := GetConfigValue(*sc.cfg(), key)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return
+		}
+		fmt.Println(value)
+
+	case "set":
+		if key == "" {
+			fmt.Fprintln(os.Stderr, "Usage: config set KEY VALUE")
+			return
+		}
+		config, err := SetConfigValue(*sc.cfg(), key, value)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return
+		}
+		sc.UseConfig(config)
+		sc.logger.Infof("Set %s to %s", key, value)
+
+	default:
+		fmt.Fprintln(os.Stderr, "Usage: config [get KEY|set KEY VALUE]")
+	}
 }
 
 // showHelp displays available commands
@@ -196,20 +890,58 @@ func showHelp() {
 Available Commands:
 ------------------
 help              Show this help message
+status            Show streaming, recording and session state
+run COMMAND       Run COMMAND as a shell command, even if it is a built-in name
+history           List the lines entered so far
+COMMAND \         A trailing backslash continues the line on the next
+begin ... end     Run the lines in between as one script (shell mode)
+!!, !N, !PREFIX   Run the last line, line N, or the latest line
+                  starting with PREFIX again
+alias [NAME=CMD]  List aliases or define one (saved with the config)
+unalias NAME      Remove an alias
 exit, quit        Exit ShellCast
 stream            Start streaming (prompts for RTMP URL if not set)
 stop              Stop streaming
+ffmpeg-args       Show the FFmpeg command used for streaming
+clear             Clear the screen and the buffered output
+dump [FILE]       Save the buffered output to a file
+grep [-i] [-m N] PATTERN
+                  Show buffered output lines matching PATTERN with
+                  their line numbers (run grep for the system grep)
+highlight [add REGEX|remove REGEX|clear]
+                  List, add or remove patterns marking matching lines
+filter [include REGEX|exclude REGEX|clear]
+                  Show or set the patterns deciding which lines are
+                  streamed and recorded (no REGEX removes the filter)
+pause [stream|record]
+                  Stop capturing output, e.g. before typing a secret
+resume [stream|record]
+                  Continue capturing output
 record            Start recording the session
 stoprecord        Stop recording the session
-theme [NAME]      List themes or apply a theme by name
-timestamp [on|off] Enable or disable timestamps
+rotate            Finish the recording file and continue in a new one
+theme [NAME]      List themes or apply a theme (restarts an active stream)
+timestamp [on|off|absolute|relative]
+                  Enable or disable timestamps, or pick wall-clock
+                  or time-since-start timestamps
 size [WxH]        Show or set screen size (e.g., 1280x720)
-split "cmd1" "cmd2" Run multiple commands in split screen mode
+split "cmd1" "cmd2" Run multiple commands in split screen mode, in the
+                  background
+jobs              List the running split commands
+kill INDEX        Stop a split command and its subprocesses
 fontsize [SIZE]   Show or set font size
+config [get KEY|set KEY VALUE]
+                  List all settings, or show or change one by its
+                  config file name (video settings apply to the next
+                  stream)
 save [FILE]       Save configuration to a file
+autosave [on|off] Show or set whether changes are saved to the config
+                  file in use (the loaded or last saved one)
+init [FILE]       Write a default configuration file as a template
 load [FILE]       Load configuration from a file
 
-Any other input will be executed as a shell command.
+Any other input will be executed as a shell command. Use 'run' to execute
+a program that has the same name as a built-in command.
 `
 	fmt.Println(help)
 }
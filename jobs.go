@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"fmt"
+)
+
+// SplitJob describes a command of the running split session
+type SplitJob struct {
+	Index   int // 1-based, as taken by KillSplitJob
+	Label   string
+	Command string
+	PID     int  // 0 until the process has started and once it exited
+	Running bool // false once the command finished or was killed
+	Killed  bool
+}
+
+// splitJob is the state behind a SplitJob. Guarded by ShellCast's mutex.
+type splitJob struct {
+	label   string
+	command string
+	config  *Config            // of the split session, taken when it started
+	ctx     context.Context    // the command's own context
+	cancel  context.CancelFunc // stops the command and its subprocesses
+	proc    Process            // set once the process started
+	done    bool
+	killed  bool
+}
+
+// startSplitJobs registers the commands of a split session, so they can
+// be listed and killed while running. Only one split session can run at
+// a time.
+func (s *ShellCast) startSplitJobs(jobs []*splitJob) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.splitJobs != nil {
+		return fmt.Errorf("split commands are already running")
+	}
+	s.splitJobs = jobs
+	return nil
+}
+
+// endSplitJobs forgets the commands of a finished split session
+func (s *ShellCast) endSplitJobs() {
+	s.mutex.Lock()
+	s.splitJobs = nil
+	s.mutex.Unlock()
+}
+
+// SplitJobs returns the commands of the running split session, if any
+func (s *ShellCast) SplitJobs() []SplitJob {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	jobs := make([]SplitJob, len(s.splitJobs))
+	for i, job := range s.splitJobs {
+		jobs[i] = SplitJob{
+			Index:   i + 1,
+			Label:   job.label,
+			Command: job.command,
+			Running: !job.done,
+			Killed:  job.killed,
+		}
+		// The process is tracked while it runs
+		if _, running := s.children[job.proc]; job.proc != nil && running {
+			jobs[i].PID = job.proc.Pid()
+		}
+	}
+	return jobs
+}
+
+// KillSplitJob stops the split command with the given 1-based index,
+// together with its subprocesses. The other commands keep running.
+func (s *ShellCast) KillSplitJob(index int) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if len(s.splitJobs) == 0 {
+		return fmt.Errorf("no split commands are running")
+	}
+	if index < 1 || index > len(s.splitJobs) {
+		return fmt.Errorf("no split command %d (1-%d)", index, len(s.splitJobs))
+	}
+	job := s.splitJobs[index-1]
+	if job.done {
+		return fmt.Errorf("split command %d (%s) already finished", index, job.label)
+	}
+	job.killed = true
+	job.cancel()
+	return nil
+}
+
+// setSplitJobProcess records the process of a split command. The caller
+// must not hold the mutex.
+func (s *ShellCast) setSplitJobProcess(job *splitJob, proc Process) {
+	s.mutex.Lock()
+	job.proc = proc
+	s.mutex.Unlock()
+}
+
+// finishSplitJob marks a split command as done and reports whether it
+// was killed
+func (s *ShellCast) finishSplitJob(job *splitJob) (killed bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	job.done = true
+	return job.killed
+}
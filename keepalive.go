@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// keepaliveText is the idle indicator shown at the bottom of the video
+func keepaliveText(idle time.Duration) string {
+	return fmt.Sprintf("[idle %s]", idle.Truncate(time.Second))
+}
+
+// keepalive shows an idle indicator in the video whenever the stream has
+// had no output for KeepaliveInterval, counting up the idle time so the
+// picture keeps changing. It runs until done is closed.
+func (s *ShellCast) keepalive(done <-chan struct{}) {
+	interval := time.Duration(s.cfg().KeepaliveInterval)
+	ticker := time.NewTicker(interval / 4)
+	defer ticker.Stop()
+
+	var shown string
+	for {
+		select {
+		case <-done:
+			return
+		case now := <-ticker.C:
+			s.mutex.Lock()
+			idle := now.Sub(s.streamActivity)
+			if s.keepaliveLen == 0 {
+				shown = ""
+			}
+			if text := keepaliveText(idle.Truncate(interval)); s.streamOut != nil && idle >= interval && text != shown {
+				s.showKeepalive(text)
+				shown = text
+			}
+			s.mutex.Unlock()
+		}
+	}
+}
+
+// showKeepalive replaces the idle indicator in the stream file with text.
+// It goes on a line of its own, below an unfinished line such as a
+// prompt. The caller must hold s.mutex.
+func (s *ShellCast) showKeepalive(text string) {
+	activity := s.streamActivity
+	s.clearKeepalive()
+	s.streamActivity = activity
+
+	if s.openStreamLen > 0 {
+		text = "\n" + text
+	}
+	if _, err := s.streamOut.WriteString(text); err != nil {
+		s.logger.Errorf("Error writing keepalive: %v", err)
+		return
+	}
+	s.keepaliveLen = len(text)
+}
+
+// clearKeepalive removes the idle indicator from the stream file before
+// other output is written to it, and marks the stream as active. The
+// caller must hold s.mutex.
+func (s *ShellCast) clearKeepalive() {
+	s.streamActivity = time.Now()
+	if s.keepaliveLen == 0 || s.streamOut == nil {
+		s.keepaliveLen = 0
+		return
+	}
+	if err := s.streamOut.Truncate(s.streamOut.Size() - int64(s.keepaliveLen)); err != nil {
+		s.logger.Errorf("Error writing output: %v", err)
+	}
+	s.keepaliveLen = 0
+}
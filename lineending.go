@@ -0,0 +1,43 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"strings"
+)
+
+// SupportedLineEndings lists how lines end in recordings and dumps. "lf"
+// is the Unix convention, "crlf" the Windows one. The stream text file
+// always uses LF, as FFmpeg's drawtext would take CRLF for two line
+// breaks.
+var SupportedLineEndings = []string{"lf", "crlf"}
+
+// crlfWriter turns every LF written to it into CRLF
+type crlfWriter struct {
+	w io.Writer
+}
+
+// Write writes p with CRLF line endings. It reports the bytes of p
+// written, not the ones added.
+func (c crlfWriter) Write(p []byte) (int, error) {
+	if _, err := c.w.Write(bytes.ReplaceAll(p, []byte("\n"), []byte("\r\n"))); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// lineEndingWriter returns w, writing the configured line endings
+func (c *Config) lineEndingWriter(w io.Writer) io.Writer {
+	if c.LineEnding == "crlf" {
+		return crlfWriter{w}
+	}
+	return w
+}
+
+// withLineEndings returns text with the configured line endings
+func (c *Config) withLineEndings(text string) string {
+	if c.LineEnding == "crlf" {
+		return strings.ReplaceAll(text, "\n", "\r\n")
+	}
+	return text
+}
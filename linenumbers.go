@@ -0,0 +1,53 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+)
+
+// SupportedLineNumberScopes lists when line numbers start over. "command"
+// numbers each command's output from 1; "session" keeps counting across
+// commands.
+var SupportedLineNumberScopes = []string{"command", "session"}
+
+// lineCounter hands out line numbers to the output streams sharing it
+type lineCounter struct {
+	mu sync.Mutex
+	n  int
+}
+
+// next returns the next line number
+func (c *lineCounter) next() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.n++
+	return c.n
+}
+
+// newLineCounter returns the counter for the output of a new command:
+// a fresh one, or the session's when numbering doesn't reset per command
+func (s *ShellCast) newLineCounter() *lineCounter {
+	if s.cfg().LineNumberScope == "session" {
+		return &s.sessionLines
+	}
+	return &lineCounter{}
+}
+
+// lineNumber returns the number of reader's current line, taking the next
+// one from its counter when the line doesn't have one yet. An unfinished
+// line keeps its number when it is completed. Output that isn't numbered
+// gets 0.
+func (s *ShellCast) lineNumber(reader *outputReader) int {
+	if !s.cfg().LineNumbers || reader.lines == nil {
+		return 0
+	}
+	if reader.number == 0 {
+		reader.number = reader.lines.next()
+	}
+	return reader.number
+}
+
+// formatLineNumber formats a line number for the start of a line
+func formatLineNumber(n int) string {
+	return fmt.Sprintf("%4d ", n)
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// LogLevel controls how much of ShellCast's own output is shown
+type LogLevel int
+
+const (
+	// LogQuiet shows only errors
+	LogQuiet LogLevel = iota
+	// LogInfo also shows status messages such as "Streaming started"
+	LogInfo
+	// LogDebug also shows FFmpeg's full output and internal state changes
+	LogDebug
+)
+
+// ParseLogLevel converts a level name from a flag or config file
+func ParseLogLevel(name string) (LogLevel, error) {
+	switch strings.ToLower(name) {
+	case "quiet":
+		return LogQuiet, nil
+	case "info", "":
+		return LogInfo, nil
+	case "debug":
+		return LogDebug, nil
+	default:
+		return LogInfo, fmt.Errorf("unknown log level '%s' (use quiet, info or debug)", name)
+	}
+}
+
+// Logger writes ShellCast's own messages. Everything goes to stderr so
+// stdout carries only the output of the commands being run.
+type Logger struct {
+	Level LogLevel
+	Out   io.Writer // status messages
+	Err   io.Writer // errors, warnings and debug messages
+}
+
+// NewLogger creates a logger writing all messages to w
+func NewLogger(level LogLevel, w io.Writer) *Logger {
+	return &Logger{
+		Level: level,
+		Out:   w,
+		Err:   w,
+	}
+}
+
+// Infof prints a status message unless running quietly
+func (l *Logger) Infof(format string, args ...interface{}) {
+	if l.Level >= LogInfo {
+		fmt.Fprintf(l.Out, format+"\n", args...)
+	}
+}
+
+// Debugf prints a message only at debug level
+func (l *Logger) Debugf(format string, args ...interface{}) {
+	if l.Level >= LogDebug {
+		fmt.Fprintf(l.Err, "[debug] "+format+"\n", args...)
+	}
+}
+
+// Errorf prints an error message at every level
+func (l *Logger) Errorf(format string, args ...interface{}) {
+	fmt.Fprintf(l.Err, format+"\n", args...)
+}
@@ -1,173 +1,1092 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
 	"os"
+	"os/exec"
 	"os/signal"
 	"strings"
-	"syscall"
 	"time"
 )
 
-func main() {
-	rtmpUrl := flag.String("rtmp", "", "RTMP URL to stream to")
-	ffmpegPath := flag.String("ffmpeg", "", "Path to FFmpeg executable")
-	fontSize := flag.Int("font-size", 24, "Font size for streaming")
-	fontColor := flag.String("font-color", "white", "Font color for streaming")
-	bgColor := flag.String("bg-color", "black", "Background color for streaming")
-	interactive := flag.Bool("interactive", false, "Run in interactive mode")
-	configFile := flag.String("config", "", "Path to configuration file")
-	showTimestamp := flag.Bool("timestamp", false, "Show timestamps in output")
-	timestampFormat := flag.String("timestamp-format", "2006-01-02 15:04:05", "Format for timestamps")
-	screenSize := flag.String("screen-size", "1280x720", "Screen size for streaming (WIDTHxHEIGHT)")
-	record := flag.Bool("record", false, "Record session to file")
-	recordPath := flag.String("record-path", "./recordings", "Directory to save recordings")
-	themeName := flag.String("theme", "default", "Theme preset to use")
-	splitMode := flag.Bool("split", false, "Run commands in split screen mode")
-	listThemes := flag.Bool("list-themes", false, "List available theme presets")
-
-
-	flagsSet := make(map[string]bool)
+// subcommand is a mode of operation with its own flag set
+type subcommand struct {
+	name    string
+	summary string
+	run     func(args []string)
+}
 
-	oldUsage := flag.CommandLine.Usage
-	flag.CommandLine.Usage = func() {
-		oldUsage()
+// subcommands returns the available subcommands in display order
+func subcommands() []subcommand {
+	return []subcommand{
+		{"stream", "Stream a command's output to an RTMP server", runStream},
+		{"record", "Record a command's output to a file", runRecord},
+		{"render", "Run a command, then encode its output to a video file", runRender},
+		{"split", "Run several commands in split screen mode", runSplit},
+		{"interactive", "Start the interactive shell", runInteractive},
+		{"play", "Replay a recorded session in a browser", runPlay},
+		{"themes", "List or export theme presets (themes list, themes export FILE)", runThemes},
 	}
+}
 
-	visitor := func(f *flag.Flag) {
-		flagsSet[f.Name] = true
+func main() {
+	if len(os.Args) > 1 {
+		for _, sub := range subcommands() {
+			if os.Args[1] == sub.name {
+				sub.run(os.Args[2:])
+				return
+			}
+		}
 	}
 
-	flag.Parse()
-	flag.Visit(visitor)
+	// Anything else uses the original flat flag interface
+	runLegacy(os.Args[1:])
+}
 
-	if *listThemes {
-		ListThemes()
-		return
-	}
+// configFlags holds the configuration flags shared by the subcommands
+type configFlags struct {
+	fs              *flag.FlagSet
+	configFile      *string
+	noConfig        *bool
+	profile         *string
+	rtmpUrl         *string
+	rtmpBase        *string
+	platform        *string
+	bitrate         *string
+	keyframeSeconds *int
+	adaptive        *bool
+	adaptiveMinFPS  *float64
+	adaptiveWindow  *time.Duration
+	adaptiveMinBitrate *string
+	streamKeyPrompt *bool
+	dryRun          *bool
+	encoder         *string
+	ffmpegArgs      *stringList
+	introText       *string
+	introDuration   *time.Duration
+	startupSeconds  *int
+	graceSeconds    *int
+	keepalive       *time.Duration
+	maxLineRate     *int
+	keepTemp        *bool
+	renderer        *string
+	capture         *string
+	captureDevice   *string
+	shellMode       *bool
+	shellProgram    *string
+	fontFile        *string
+	coalesce        *bool
+	outputs         *stringList
+	highlights      *stringList
+	redact          *stringList
+	noDefaultRedact *bool
+	include         *string
+	exclude         *string
+	ffmpegPath      *string
+	fontSize        *int
+	textX           *string
+	textY           *string
+	textPadding     *int
+	textBox         *bool
+	boxOpacity      *float64
+	fontColor       *string
+	bgColor         *string
+	showTimestamp   *bool
+	timestampFormat *string
+	timestampMode   *string
+	timestampLines  *string
+	timestampInterval *time.Duration
+	prefixTemplate  *string
+	lineNumbers     *bool
+	lineNumberScope *string
+	screenSize      *string
+	recordPath      *string
+	recordFile      *string
+	recordAppend    *bool
+	recordVideo     *bool
+	recordFlush     *time.Duration
+	recordFsync     *bool
+	recordFormat    *string
+	recordCompress  *bool
+	recordRaw       *bool
+	lineEnding      *string
+	recordKeep      *int
+	recordMaxAge    *time.Duration
+	themeName       *string
+	themesFile      *string
+	serveAddr       *string
+	timeout         *time.Duration
+	logLevel        *string
+	format          *string
+	mergeStreams    *bool
+	noColor         *bool
+	preHook         *string
+	postHook        *string
+	suppressHooks   *bool
+	typing          *bool
+	typingSpeed     *time.Duration
+	maxLineBytes    *int
+}
 
-	// Parse screen size
-	var width, height int
-	fmt.Sscanf(*screenSize, "%dx%d", &width, &height)
-	if width <= 0 || height <= 0 {
-		width, height = 1280, 720
+// addConfigFlags registers the shared configuration flags on fs
+func addConfigFlags(fs *flag.FlagSet) *configFlags {
+	f := &configFlags{
+		fs:              fs,
+		configFile:      fs.String("config", "", "Path to configuration file (default: the first found of $XDG_CONFIG_HOME/shellcast/config.json, ~/.shellcast.json and ./"+DefaultConfigFile+")"),
+		noConfig:        fs.Bool("no-config", false, "Don't look for a config file when -config isn't given, use the defaults"),
+		profile:         fs.String("profile", "", "Apply this profile from the config file's profiles section"),
+		ffmpegPath:      fs.String("ffmpeg", "", "Path to FFmpeg executable"),
+		fontSize:        fs.Int("font-size", 24, "Font size for streaming"),
+		textX:           fs.String("text-x", "", "Horizontal text position, a number or FFmpeg drawtext expression like (w-text_w)/2 (default: -text-padding)"),
+		textY:           fs.String("text-y", "", "Vertical text position, a number or FFmpeg drawtext expression (default: -text-padding)"),
+		textPadding:     fs.Int("text-padding", 20, "Distance of the text from the edges of the video, in pixels"),
+		textBox:         fs.Bool("text-box", false, "Draw a box in the background color behind the text"),
+		boxOpacity:      fs.Float64("box-opacity", 1, "Opacity of the -text-box, from 0 (transparent) to 1 (opaque)"),
+		fontColor:       fs.String("font-color", "white", "Font color for streaming"),
+		fontFile:        fs.String("font-file", "", "Font file for streaming, or a comma-separated list of which the first existing one is used (default: FFmpeg's choice, or a monospace font on Windows)"),
+		shellMode:       fs.Bool("shell", false, "Run commands through a shell, so pipes, redirection and variables work"),
+		shellProgram:    fs.String("shell-program", "", "Shell for -shell and hooks: sh, bash, cmd, powershell, ... (default sh, or cmd on Windows)"),
+		bgColor:         fs.String("bg-color", "black", "Background color for streaming"),
+		showTimestamp:   fs.Bool("timestamp", false, "Show timestamps in output"),
+		timestampFormat: fs.String("timestamp-format", "2006-01-02 15:04:05", "Format for timestamps"),
+		timestampMode:   fs.String("timestamp-mode", "absolute", "Timestamp mode: absolute (wall clock) or relative (since start)"),
+		timestampLines:  fs.String("timestamp-lines", "per-line", "Lines with a timestamp: per-line, per-burst (first line after a pause of -timestamp-interval) or interval (at most one per -timestamp-interval)"),
+		timestampInterval: fs.Duration("timestamp-interval", time.Second, "Pause that starts a burst, or time between timestamps, for -timestamp-lines"),
+		prefixTemplate:  fs.String("prefix", "", "Line prefix template with {time}, {cmd}, {pid}, {stream} and {source}, replacing timestamps and labels"),
+		lineNumbers:     fs.Bool("line-numbers", false, "Number output lines"),
+		lineNumberScope: fs.String("line-number-scope", "command", "When line numbers start over: command (each command) or session"),
+		screenSize:      fs.String("screen-size", "1280x720", "Screen size for streaming (WIDTHxHEIGHT)"),
+		recordPath:      fs.String("record-path", "./recordings", "Directory to save recordings"),
+		recordFile:      fs.String("record-file", "", "Record to this file instead of a new timestamped file in -record-path"),
+		recordAppend:    fs.Bool("record-append", false, "Append to an existing -record-file instead of overwriting it"),
+		recordVideo:     fs.Bool("record-video", false, "Save the rendered video as an MP4 file in -record-path"),
+		recordFlush:     fs.Duration("record-flush", time.Second, "How often to flush the recording to disk (0 = only when recording stops)"),
+		recordFsync:     fs.Bool("record-fsync", false, "Sync the recording to disk on every flush"),
+		recordFormat:    fs.String("record-format", "text", "Recording format: text, or srt or vtt for subtitles timed from the session start"),
+		recordKeep:      fs.Int("record-keep", 0, "Remove all but this many of the most recent recordings in -record-path when recording starts (0 = keep all)"),
+		recordMaxAge:    fs.Duration("record-max-age", 0, "Remove recordings in -record-path older than this when recording starts, e.g. 720h (0 = keep all)"),
+		lineEnding:      fs.String("line-ending", "lf", "Line endings of recordings and dumps: lf, or crlf for Windows tools"),
+		recordRaw:       fs.Bool("record-raw", false, "Record only the output lines, without header, footer, timestamps or other prefixes"),
+		recordCompress:  fs.Bool("record-compress", false, "Compress the recording with gzip, adding .gz to its name (also done for a -record-file ending in .gz)"),
+		themeName:       fs.String("theme", "default", "Theme preset to use"),
+		themesFile:      fs.String("themes", "", "Load more theme presets from this file, as written by -export-themes"),
+		serveAddr:       fs.String("serve", "", "Serve live output to browsers over WebSocket on this address (e.g. :8080)"),
+		highlights:      &stringList{},
+		redact:          &stringList{},
+		noDefaultRedact: fs.Bool("no-default-redact", false, "Don't hide common secrets such as passwords and API keys"),
+		include:         fs.String("include", "", "Only stream and record output lines matching this regular expression"),
+		exclude:         fs.String("exclude", "", "Don't stream or record output lines matching this regular expression"),
+		timeout:         fs.Duration("timeout", 0, "Maximum duration for each executed command (0 = no limit)"),
+		logLevel:        fs.String("log-level", "info", "Amount of ShellCast's own output: quiet, info or debug"),
+		format:          fs.String("format", "text", "Output line format: text or json (one JSON object per line)"),
+		mergeStreams:    fs.Bool("merge-streams", false, "Don't mark stderr lines with [stderr]"),
+		noColor:         fs.Bool("no-color", false, "Don't use ANSI colors on the terminal (also with $"+NoColorEnv+" set); the video is unaffected"),
+		preHook:         fs.String("pre-hook", "", "Shell command to run before each command"),
+		postHook:        fs.String("post-hook", "", "Shell command to run after each command"),
+		suppressHooks:   fs.Bool("suppress-hook-output", false, "Show hook output locally but don't stream or record it"),
+		typing:          fs.Bool("typing", false, "Type each command out character by character before running it"),
+		typingSpeed:     fs.Duration("typing-speed", 50*time.Millisecond, "Delay between typed characters with -typing"),
+		maxLineBytes:    fs.Int("max-line-bytes", 1024*1024, "Truncate output lines longer than this many bytes"),
 	}
+	fs.Var(f.highlights, "highlight", "Mark output lines matching this regular expression (repeatable)")
+	fs.Var(f.redact, "redact", "Replace text matching this regular expression with **** (repeatable)")
+	return f
+}
 
+// addStreamFlags registers the flags only meaningful when streaming
+func (f *configFlags) addStreamFlags() {
+	f.addEncodeFlags()
+	f.rtmpUrl = f.fs.String("rtmp", "", "RTMP URL to stream to")
+	f.rtmpBase = f.fs.String("rtmp-base", "", "RTMP URL without the stream key, which is read from $"+StreamKeyEnv+" or -stream-key-prompt")
+	f.streamKeyPrompt = f.fs.Bool("stream-key-prompt", false, "Ask for the stream key used with -rtmp-base or -platform")
+	f.platform = f.fs.String("platform", "", "Stream to a service with its recommended settings: "+strings.Join(SupportedPlatforms(), ", ")+" (needs a stream key)")
+	f.dryRun = f.fs.Bool("dry-run", false, "Print the FFmpeg command instead of streaming")
+	f.introText = f.fs.String("intro-text", "", "Text shown above the countdown before streaming starts")
+	f.introDuration = f.fs.Duration("intro-duration", 0, "Show a countdown on the stream for this long before running the command (0 = no intro)")
+	f.startupSeconds = f.fs.Int("startup", 2, "Seconds to wait after starting the stream before running the command")
+	f.adaptive = f.fs.Bool("adaptive", false, "Lower the bitrate, then the resolution, and restart FFmpeg when the stream can't keep up")
+	f.adaptiveMinFPS = f.fs.Float64("adaptive-min-fps", 25, "With -adaptive, frame rates below this count as falling behind")
+	f.adaptiveWindow = f.fs.Duration("adaptive-window", 30*time.Second, "With -adaptive, how long the stream must fall behind before the quality is lowered")
+	f.adaptiveMinBitrate = f.fs.String("adaptive-min-bitrate", "500k", "With -adaptive, the lowest bitrate; below it the resolution is lowered instead")
+	f.keepalive = f.fs.Duration("keepalive", 0, "Show an idle indicator on the stream after this long without output, so the picture keeps changing (0 = off)")
+	f.renderer = f.fs.String("renderer", "ffmpeg", "How the stream is rendered: ffmpeg, or none to only keep the stream text file up to date")
+	f.capture = f.fs.String("capture", "text", "Video source: text (render the output) or screen (capture the screen, macOS only)")
+	f.captureDevice = f.fs.String("capture-device", "Capture screen 0", "avfoundation video device name or index for -capture screen")
+	f.maxLineRate = f.fs.Int("max-lines-per-sec", 0, "Drop stream lines beyond this many per second and warn (0 = no limit)")
+	f.coalesce = f.fs.Bool("coalesce", false, "After dropping lines, redraw the stream with the latest output")
+	f.outputs = &stringList{}
+	f.fs.Var(f.outputs, "output", "Additional video output URL or file, format guessed from the extension; .gif makes an animated GIF (repeatable)")
+}
+
+// addEncodeFlags adds the flags for how video is encoded, shared by
+// streaming and offline renders
+func (f *configFlags) addEncodeFlags() {
+	f.bitrate = f.fs.String("bitrate", "", "Video bitrate, e.g. 2500k (default: the platform's recommendation or the encoder's default)")
+	f.keyframeSeconds = f.fs.Int("keyframe-interval", 0, "Seconds between keyframes (0 = the platform's recommendation or the encoder's default)")
+	f.encoder = f.fs.String("encoder", "libx264", "Video encoder: libx264, h264_nvenc, h264_vaapi, h264_videotoolbox or auto")
+	f.ffmpegArgs = &stringList{}
+	f.fs.Var(f.ffmpegArgs, "ffmpeg-arg", "Extra FFmpeg arguments inserted before the output (repeatable)")
+	f.graceSeconds = f.fs.Int("grace", 5, "Seconds to keep streaming, or to hold the last screen of a render, after the command completes")
+	f.keepTemp = f.fs.Bool("keep-temp", false, "Keep the stream's or render's temp files and don't remove stale ones, for debugging")
+}
+
+// buildConfig loads the configuration file, if any, and overrides it with
+// the flags that were set on the command line
+func (f *configFlags) buildConfig() Config {
 	// Create or load config
 	var config Config
 	var err error
 
-	if *configFile != "" {
-		config, err = LoadConfig(*configFile)
+	// Without -config, use the first config file found in the usual
+	// places. It is then reloaded and saved like one given with -config.
+	if *f.configFile == "" && !*f.noConfig {
+		*f.configFile = FindConfigFile()
+	}
+
+	if *f.configFile != "" {
+		config, err = LoadConfigProfile(*f.configFile, *f.profile)
 		if err != nil {
+			// A profile can't be applied to the defaults
+			if *f.profile != "" {
+				log.Fatalf("Error loading config: %v", err)
+			}
 			log.Printf("Error loading config, using defaults: %v", err)
 			config = GetDefaultConfig()
 		}
+	} else if *f.profile != "" {
+		log.Fatalf("-profile needs a config file (-config, or one found in the usual places)")
 	} else {
 		config = GetDefaultConfig()
 	}
 
-	// Override config with command-line flags if provided
-	if *rtmpUrl != "" {
-		config.RTMPUrl = *rtmpUrl
+	f.applyFlags(&config)
+	if config.streamBase() != "" && config.StreamKey == "" {
+		config.StreamKey = os.Getenv(StreamKeyEnv)
+	}
+	if f.streamKeyPrompt != nil && *f.streamKeyPrompt {
+		key, err := readStreamKey(os.Stdin, os.Stderr)
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+		config.StreamKey = key
+	}
+
+	if err := config.Validate(); err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
+
+	return config
+}
+
+// applyFlags overrides config with the flags that were set on the
+// command line
+func (f *configFlags) applyFlags(config *Config) {
+	flagsSet := make(map[string]bool)
+	f.fs.Visit(func(fl *flag.Flag) {
+		flagsSet[fl.Name] = true
+	})
+
+	if f.rtmpUrl != nil && *f.rtmpUrl != "" {
+		config.RTMPUrl = *f.rtmpUrl
+	}
+	if f.rtmpBase != nil && *f.rtmpBase != "" {
+		config.RTMPBase = *f.rtmpBase
+	}
+	if flagsSet["platform"] {
+		config.Platform = *f.platform
+	}
+	if flagsSet["bitrate"] {
+		config.VideoBitrate = *f.bitrate
+	}
+	if flagsSet["adaptive"] {
+		config.Adaptive = *f.adaptive
+	}
+	if flagsSet["adaptive-min-fps"] {
+		config.AdaptiveMinFPS = *f.adaptiveMinFPS
 	}
-	if *ffmpegPath != "" {
-		config.FFmpegPath = *ffmpegPath
+	if flagsSet["adaptive-window"] {
+		config.AdaptiveWindow = Duration(*f.adaptiveWindow)
+	}
+	if flagsSet["adaptive-min-bitrate"] {
+		config.AdaptiveMinBitrate = *f.adaptiveMinBitrate
+	}
+	if flagsSet["keyframe-interval"] {
+		config.KeyframeSeconds = *f.keyframeSeconds
+	}
+	if flagsSet["encoder"] {
+		config.Encoder = *f.encoder
+	}
+	if f.dryRun != nil && *f.dryRun {
+		config.DryRun = true
+	}
+	if *f.ffmpegPath != "" {
+		config.FFmpegPath = *f.ffmpegPath
+	}
+	if f.ffmpegArgs != nil {
+		for _, value := range *f.ffmpegArgs {
+			args, err := splitArgs(value)
+			if err != nil {
+				log.Fatalf("Invalid -ffmpeg-arg %q: %v", value, err)
+			}
+			config.ExtraFFmpegArgs = append(config.ExtraFFmpegArgs, args...)
+		}
+	}
+	if f.outputs != nil {
+		for _, url := range *f.outputs {
+			config.Outputs = append(config.Outputs, OutputSpec{URL: url})
+		}
+	}
+	if flagsSet["intro-text"] {
+		config.IntroText = *f.introText
+	}
+	if flagsSet["intro-duration"] {
+		config.IntroDuration = Duration(*f.introDuration)
+	}
+	if flagsSet["startup"] {
+		config.StreamStartupSeconds = *f.startupSeconds
+	}
+	if flagsSet["grace"] {
+		config.StreamGraceSeconds = *f.graceSeconds
+	}
+	if flagsSet["keepalive"] {
+		config.KeepaliveInterval = Duration(*f.keepalive)
+	}
+	if flagsSet["renderer"] {
+		config.Renderer = *f.renderer
+	}
+	if flagsSet["capture"] {
+		config.CaptureMode = *f.capture
+	}
+	if flagsSet["capture-device"] {
+		config.CaptureDevice = *f.captureDevice
+	}
+	if flagsSet["keep-temp"] {
+		config.KeepTemp = *f.keepTemp
+	}
+	if flagsSet["max-lines-per-sec"] {
+		config.MaxLinesPerSecond = *f.maxLineRate
+	}
+	if flagsSet["coalesce"] {
+		config.CoalesceLines = *f.coalesce
 	}
 	if flagsSet["font-size"] {
-		config.FontSize = *fontSize
+		config.FontSize = *f.fontSize
 	}
 	if flagsSet["font-color"] {
-		config.FontColor = *fontColor
+		config.FontColor = *f.fontColor
+	}
+	if flagsSet["text-x"] {
+		config.TextX = *f.textX
+	}
+	if flagsSet["text-y"] {
+		config.TextY = *f.textY
+	}
+	if flagsSet["text-padding"] {
+		config.TextPadding = *f.textPadding
+	}
+	if flagsSet["text-box"] {
+		config.TextBox = *f.textBox
+	}
+	if flagsSet["box-opacity"] {
+		config.BoxOpacity = *f.boxOpacity
+	}
+	if flagsSet["font-file"] {
+		config.FontFile = *f.fontFile
+	}
+	if flagsSet["shell"] {
+		config.ShellMode = *f.shellMode
+	}
+	if flagsSet["shell-program"] {
+		config.ShellProgram = *f.shellProgram
 	}
 	if flagsSet["bg-color"] {
-		config.BackgroundColor = *bgColor
+		config.BackgroundColor = *f.bgColor
 	}
 	if flagsSet["timestamp"] {
-		config.ShowTimestamp = *showTimestamp
+		config.ShowTimestamp = *f.showTimestamp
 	}
 	if flagsSet["timestamp-format"] {
-		config.TimestampFormat = *timestampFormat
+		config.TimestampFormat = *f.timestampFormat
+	}
+	if flagsSet["timestamp-mode"] {
+		config.TimestampMode = *f.timestampMode
+	}
+	if flagsSet["timestamp-lines"] {
+		config.TimestampLines = *f.timestampLines
+	}
+	if flagsSet["timestamp-interval"] {
+		config.TimestampInterval = Duration(*f.timestampInterval)
+	}
+	if flagsSet["prefix"] {
+		config.PrefixTemplate = *f.prefixTemplate
+	}
+	if flagsSet["line-numbers"] {
+		config.LineNumbers = *f.lineNumbers
+	}
+	if flagsSet["line-number-scope"] {
+		config.LineNumberScope = *f.lineNumberScope
+	}
+	if flagsSet["screen-size"] {
+		// Parse screen size
+		var width, height int
+		fmt.Sscanf(*f.screenSize, "%dx%d", &width, &height)
+		if width <= 0 || height <= 0 {
+			width, height = 1280, 720
+		}
+		config.ScreenWidth = width
+		config.ScreenHeight = height
 	}
-	config.ScreenWidth = width
-	config.ScreenHeight = height
 	if flagsSet["record-path"] {
-		config.RecordPath = *recordPath
+		config.RecordPath = *f.recordPath
+	}
+	if flagsSet["record-file"] {
+		config.RecordFile = *f.recordFile
+	}
+	if flagsSet["record-append"] {
+		config.RecordAppend = *f.recordAppend
+	}
+	if flagsSet["record-video"] {
+		config.RecordVideo = *f.recordVideo
+	}
+	if flagsSet["record-flush"] {
+		config.RecordFlushInterval = Duration(*f.recordFlush)
+	}
+	if flagsSet["record-fsync"] {
+		config.RecordFsync = *f.recordFsync
+	}
+	if flagsSet["record-format"] {
+		config.RecordFormat = *f.recordFormat
+	}
+	if flagsSet["record-compress"] {
+		config.CompressRecording = *f.recordCompress
+	}
+	if flagsSet["line-ending"] {
+		config.LineEnding = *f.lineEnding
+	}
+	if flagsSet["record-raw"] {
+		config.RawRecording = *f.recordRaw
+	}
+	if flagsSet["record-keep"] {
+		config.RecordKeep = *f.recordKeep
+	}
+	if flagsSet["record-max-age"] {
+		config.RecordMaxAge = Duration(*f.recordMaxAge)
+	}
+	if flagsSet["themes"] {
+		config.ThemesFile = *f.themesFile
+	}
+	// Custom themes must be loaded before -theme can pick one
+	if config.ThemesFile != "" {
+		if err := LoadThemes(config.ThemesFile); err != nil {
+			log.Printf("Error loading themes: %v", err)
+		}
 	}
 	if flagsSet["theme"] {
-		config.ThemeName = *themeName
-		config.ApplyTheme(*themeName)
+		config.ThemeName = *f.themeName
+		config.ApplyTheme(*f.themeName)
+	}
+	if flagsSet["timeout"] {
+		config.CommandTimeout = Duration(*f.timeout)
+	}
+	if flagsSet["pre-hook"] {
+		config.PreCommandHook = *f.preHook
+	}
+	if flagsSet["post-hook"] {
+		config.PostCommandHook = *f.postHook
+	}
+	if flagsSet["suppress-hook-output"] {
+		config.SuppressHookOutput = *f.suppressHooks
+	}
+	if flagsSet["typing"] {
+		config.TypingEffect = *f.typing
+	}
+	if flagsSet["typing-speed"] {
+		config.TypingSpeed = Duration(*f.typingSpeed)
+	}
+	if flagsSet["max-line-bytes"] {
+		config.MaxLineBytes = *f.maxLineBytes
+	}
+	if *f.serveAddr != "" {
+		config.ServeAddr = *f.serveAddr
+	}
+	if flagsSet["log-level"] {
+		config.LogLevel = *f.logLevel
+	}
+	config.Highlight = append(config.Highlight, *f.highlights...)
+	config.Redact = append(config.Redact, *f.redact...)
+	if flagsSet["no-default-redact"] {
+		config.NoDefaultRedact = *f.noDefaultRedact
+	}
+	if flagsSet["include"] {
+		config.IncludeRegex = *f.include
+	}
+	if flagsSet["exclude"] {
+		config.ExcludeRegex = *f.exclude
+	}
+	if flagsSet["format"] {
+		config.OutputFormat = *f.format
+	}
+	if flagsSet["merge-streams"] {
+		config.MergeStreams = *f.mergeStreams
+	}
+	if flagsSet["no-color"] {
+		config.NoColor = *f.noColor
+	}
+}
+
+// stringList is a flag that can be given more than once
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, " ")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+// newFlagSet creates the flag set for a subcommand with a usage line
+func newFlagSet(name, arguments string) *flag.FlagSet {
+	fs := flag.NewFlagSet("shellcast "+name, flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: shellcast %s %s\n\nFlags:\n", name, arguments)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// runStream streams a single command to an RTMP server
+func runStream(args []string) {
+	fs := newFlagSet("stream", "[flags] COMMAND [ARGS...]")
+	flags := addConfigFlags(fs)
+	flags.addStreamFlags()
+	record := fs.Bool("record", false, "Also record session to file")
+	script := fs.String("script", "", "Run the commands in this file instead of COMMAND")
+	duration := fs.Duration("duration", 0, "Stop streaming and exit after this long, even if the command is still running (0 = no limit)")
+	recording := fs.String("stream-recording", "", "Stream this recording (.txt or .cast) instead of COMMAND")
+	tail := fs.String("tail", "", "Follow this file like tail -f and stream what is appended to it, instead of COMMAND")
+	replaySpeed := fs.Float64("replay-speed", 0, "Lines per second when streaming text recordings (0 = instant)")
+	fs.Parse(args)
+
+	config := flags.buildConfig()
+	if !config.RendersVideo() || (fs.NArg() == 0 && *script == "" && *recording == "" && *tail == "") {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	options := sessionOptions{
+		Record:      *record,
+		Script:      *script,
+		ConfigPath:  *flags.configFile,
+		Flags:       flags,
+		Duration:    *duration,
+		Recording:   *recording,
+		ReplaySpeed: *replaySpeed,
+		Tail:        *tail,
+	}
+	runSession(config, options, fs.Args())
+}
+
+// runRecord records a single command to a file
+func runRecord(args []string) {
+	fs := newFlagSet("record", "[flags] COMMAND [ARGS...]")
+	flags := addConfigFlags(fs)
+	script := fs.String("script", "", "Run the commands in this file instead of COMMAND")
+	fs.Parse(args)
+
+	if fs.NArg() == 0 && *script == "" {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	config := flags.buildConfig()
+	runSession(config, sessionOptions{Record: true, Script: *script, ConfigPath: *flags.configFile, Flags: flags}, fs.Args())
+}
+
+// runRender runs a command to completion and then encodes its output
+// to a video file in one go, as fast as FFmpeg can
+func runRender(args []string) {
+	fs := newFlagSet("render", "[flags] VIDEO COMMAND [ARGS...]")
+	flags := addConfigFlags(fs)
+	flags.addEncodeFlags()
+	script := fs.String("script", "", "Run the commands in this file instead of COMMAND")
+	fs.Parse(args)
+
+	if fs.NArg() < 1 || (fs.NArg() == 1 && *script == "") {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	config := flags.buildConfig()
+	options := sessionOptions{
+		Render:     fs.Arg(0),
+		Script:     *script,
+		ConfigPath: *flags.configFile,
+		Flags:      flags,
+	}
+	runSession(config, options, fs.Args()[1:])
+}
+
+// runSplit runs several commands in split screen mode
+func runSplit(args []string) {
+	fs := newFlagSet("split", "[flags] [\"COMMAND1\" \"COMMAND2\" ...]")
+	flags := addConfigFlags(fs)
+	record := fs.Bool("record", false, "Record session to file")
+	fs.Parse(args)
+
+	config := flags.buildConfig()
+	if fs.NArg() == 0 && len(config.SplitCommands) == 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	runSession(config, sessionOptions{Split: true, Record: *record, ConfigPath: *flags.configFile, Flags: flags}, fs.Args())
+}
+
+// runInteractive starts the interactive shell
+func runInteractive(args []string) {
+	fs := newFlagSet("interactive", "[flags]")
+	flags := addConfigFlags(fs)
+	flags.addStreamFlags()
+	record := fs.Bool("record", false, "Record session to file")
+	fs.Parse(args)
+
+	config := flags.buildConfig()
+	options := sessionOptions{
+		Interactive: true,
+		Record:      *record,
+		ConfigPath:  *flags.configFile,
+		Flags:       flags,
+	}
+	runSession(config, options, nil)
+}
+
+// runPlay serves a recording for playback in a browser
+func runPlay(args []string) {
+	fs := newFlagSet("play", "[flags] FILE")
+	flags := addConfigFlags(fs)
+	replaySpeed := fs.Float64("replay-speed", 0, "Lines per second when playing back text recordings (0 = instant)")
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	servePlayback(flags.buildConfig(), fs.Arg(0), *replaySpeed)
+}
+
+// runThemes handles the theme management subcommands. Themes loaded
+// with -themes or the config's themes_file are included.
+func runThemes(args []string) {
+	if len(args) == 0 || (args[0] != "list" && args[0] != "export") {
+		fmt.Fprintln(os.Stderr, "Usage: shellcast themes list [flags]")
+		fmt.Fprintln(os.Stderr, "       shellcast themes export [flags] FILE")
+		os.Exit(2)
+	}
+
+	action, arguments := args[0], "[flags]"
+	if action == "export" {
+		arguments = "[flags] FILE"
+	}
+	fs := newFlagSet("themes "+action, arguments)
+	flags := addConfigFlags(fs)
+	fs.Parse(args[1:])
+	flags.buildConfig()
+
+	if action == "list" {
+		ListThemes()
+		return
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	if err := ExportThemes(fs.Arg(0)); err != nil {
+		log.Fatalf("Error exporting themes: %v", err)
+	}
+	fmt.Printf("Themes written to %s\n", fs.Arg(0))
+}
+
+// runLegacy handles the original flat flag interface, e.g.
+// shellcast -rtmp rtmp://server/app ls -la
+func runLegacy(args []string) {
+	fs := flag.CommandLine
+	flags := addConfigFlags(fs)
+	flags.addStreamFlags()
+	interactive := fs.Bool("interactive", false, "Run in interactive mode")
+	record := fs.Bool("record", false, "Record session to file")
+	splitMode := fs.Bool("split", false, "Run commands in split screen mode")
+	listThemes := fs.Bool("list-themes", false, "List available theme presets")
+	exportThemes := fs.String("export-themes", "", "Write all theme presets, built-in and loaded with -themes, to this file and exit")
+	version := fs.Bool("version", false, "Print version and build information and exit")
+	playFile := fs.String("play", "", "Serve a recorded session (.txt or .cast) for playback in a browser")
+	replaySpeed := fs.Float64("replay-speed", 0, "Lines per second when playing back or streaming text recordings (0 = instant)")
+	streamRecording := fs.String("stream-recording", "", "Stream a recorded session (.txt or .cast) as if it were live, instead of COMMAND")
+	tailFile := fs.String("tail", "", "Follow this file like tail -f and stream what is appended to it, instead of COMMAND")
+	script := fs.String("script", "", "Run the commands in this file, one per line, instead of COMMAND")
+	selfTest := fs.Bool("selftest", false, "Check the output, streaming and recording pipelines and report throughput")
+	duration := fs.Duration("duration", 0, "Stop streaming and exit after this long, even if the command is still running (0 = no limit)")
+	initConfig := fs.Bool("init-config", false, "Write a default config file to PATH (default "+DefaultConfigFile+") and exit")
+	force := fs.Bool("force", false, "Let -init-config overwrite an existing file")
+	fs.Usage = printUsage
+	fs.Parse(args)
+
+	if *version {
+		PrintVersion(os.Stdout)
+		return
+	}
+
+	if *initConfig {
+		path := DefaultConfigFile
+		if fs.NArg() > 0 {
+			path = fs.Arg(0)
+		}
+		if err := WriteDefaultConfig(path, *force); err != nil {
+			log.Fatalf("Error writing config: %v", err)
+		}
+		fmt.Printf("Default config written to %s\n", path)
+		return
+	}
+
+	config := flags.buildConfig()
+
+	// Themes are listed and exported once -themes is loaded
+	if *listThemes {
+		ListThemes()
+		return
+	}
+	if *exportThemes != "" {
+		if err := ExportThemes(*exportThemes); err != nil {
+			log.Fatalf("Error exporting themes: %v", err)
+		}
+		fmt.Printf("Themes written to %s\n", *exportThemes)
+		return
+	}
+
+	if *selfTest {
+		report := RunSelfTest(config)
+		PrintSelfTestReport(os.Stdout, report, config.OutputFormat)
+		if !report.OK {
+			os.Exit(1)
+		}
+		return
+	}
+
+	// Play back a recording instead of running commands
+	if *playFile != "" {
+		servePlayback(config, *playFile, *replaySpeed)
+		return
+	}
+
+	splitFromConfig := *splitMode && len(config.SplitCommands) > 0
+	if !*interactive && *script == "" && *streamRecording == "" && *tailFile == "" && fs.NArg() == 0 && !splitFromConfig {
+		fs.Usage()
+		return
+	}
+
+	options := sessionOptions{
+		Interactive: *interactive,
+		Split:       *splitMode,
+		Record:      *record,
+		Script:      *script,
+		ConfigPath:  *flags.configFile,
+		Flags:       flags,
+		Duration:    *duration,
+		Recording:   *streamRecording,
+		ReplaySpeed: *replaySpeed,
+		Tail:        *tailFile,
 	}
+	runSession(config, options, fs.Args())
+}
+
+// printUsage describes both the subcommands and the flat flag interface
+func printUsage() {
+	out := flag.CommandLine.Output()
+	fmt.Fprintln(out, "Usage: shellcast [flags] COMMAND [ARGS...]")
+	fmt.Fprintln(out, "       shellcast SUBCOMMAND [flags] [ARGS...]")
+	fmt.Fprintln(out, "\nSubcommands:")
+	for _, sub := range subcommands() {
+		fmt.Fprintf(out, "  %-12s %s\n", sub.name, sub.summary)
+	}
+	fmt.Fprintln(out, "\nRun 'shellcast SUBCOMMAND -h' for subcommand flags.")
+	fmt.Fprintln(out, "\nFlags:")
+	flag.CommandLine.PrintDefaults()
+	fmt.Fprintln(out, "\nExamples:")
+	fmt.Fprintln(out, "  shellcast interactive")
+	fmt.Fprintln(out, "  shellcast stream -rtmp rtmp://server/app ls -la")
+	fmt.Fprintln(out, "  shellcast record -theme hacker -timestamp top")
+	fmt.Fprintln(out, "  shellcast render demo.mp4 make test")
+	fmt.Fprintln(out, "  shellcast split \"ls -la\" \"top -n 1\"")
+	fmt.Fprintln(out, "  shellcast -rtmp rtmp://server/app ls -la")
+	fmt.Fprintln(out, "  shellcast record -script demo.txt")
+	fmt.Fprintln(out, "  shellcast -selftest -format json")
+	fmt.Fprintln(out, "  shellcast -init-config my_config.json")
+}
+
+// sessionOptions selects what a session does once its config is built
+type sessionOptions struct {
+	Interactive bool
+	Split       bool
+	Record      bool
+	Script      string
+	ConfigPath  string
+	Flags       *configFlags  // applied again when ConfigPath is reloaded
+	Duration    time.Duration // stop after this long, 0 = when the command ends
+	Recording   string        // recording to stream instead of a command
+	ReplaySpeed float64       // lines per second for text recordings
+	Render      string        // video file to render the output to afterwards
+	Tail        string        // file to follow instead of running a command
+}
+
+// reloadConfig loads the config file at path again, applies the
+// command-line flags on top as at startup, and hands the result to the
+// running session. An invalid file leaves the settings as they are.
+func reloadConfig(shellcast *ShellCast, flags *configFlags, path string) {
+	config, err := LoadConfigProfile(path, *flags.profile)
+	if err == nil {
+		flags.applyFlags(&config)
+		if config.StreamKey == "" {
+			config.StreamKey = shellcast.cfg().StreamKey
+		}
+		err = config.Validate()
+	}
+	if err != nil {
+		shellcast.logger.Errorf("Error reloading config, keeping the current settings: %v", err)
+		return
+	}
+
+	if shellcast.ReloadConfig(config) {
+		shellcast.logger.Infof("Config reloaded from %s; video settings such as colors and font only change after restarting the stream", path)
+		return
+	}
+	shellcast.logger.Infof("Config reloaded from %s", path)
+}
 
+// servePlayback serves a recording, defaulting to port 8080
+func servePlayback(config Config, file string, replaySpeed float64) {
+	addr := config.ServeAddr
+	if addr == "" {
+		addr = ":8080"
+	}
+
+	shellcast := NewShellCast(config)
+	if err := shellcast.ServePlayback(addr, file, replaySpeed); err != nil {
+		log.Fatalf("Error serving playback: %v", err)
+	}
+}
+
+// runSession runs the given commands, or the interactive shell, with
+// streaming, recording and serving set up from config and options
+func runSession(config Config, options sessionOptions, args []string) {
 	// Create ShellCast instance
 	shellcast := NewShellCast(config)
+	exitCode := 0
+
+	// Load the script or recording up front so a broken file fails
+	// before streaming
+	var steps []ScriptStep
+	if options.Script != "" && !options.Interactive && !options.Split {
+		var err error
+		if steps, err = LoadScript(options.Script); err != nil {
+			log.Fatalf("Error loading script: %v", err)
+		}
+	}
+	var events []playbackEvent
+	if options.Recording != "" && !options.Interactive && !options.Split {
+		var err error
+		if events, err = loadRecording(options.Recording, options.ReplaySpeed); err != nil {
+			log.Fatalf("Error loading recording: %v", err)
+		}
+	}
+	if options.Tail != "" && !options.Interactive && !options.Split {
+		if _, err := os.Stat(options.Tail); err != nil {
+			log.Fatalf("Error opening file to tail: %v", err)
+		}
+	}
 
 	// Set up signal handling for cleanup
 	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+	signal.Notify(sigChan, terminationSignals...)
 	go func() {
 		<-sigChan
-		fmt.Println("\nReceived termination signal. Cleaning up...")
+		shellcast.logger.Infof("\nReceived termination signal. Cleaning up...")
 		shellcast.Cleanup()
 		os.Exit(0)
 	}()
 
-	// Check if a command was provided (non-flag arguments)
-	args := flag.Args()
-	hasCommand := len(args) > 0
+	// On SIGUSR1, start a new recording file, like logrotate has daemons
+	// reopen their logs. Only the recording is swapped; the settings stay
+	// as they are.
+	if len(rotateSignals) > 0 {
+		rotateChan := make(chan os.Signal, 1)
+		signal.Notify(rotateChan, rotateSignals...)
+		go func() {
+			for range rotateChan {
+				if !shellcast.isRecording() {
+					shellcast.logger.Infof("Not recording, nothing to rotate")
+					continue
+				}
+				if err := shellcast.RotateRecording(); err != nil {
+					shellcast.logger.Errorf("Error rotating recording: %v", err)
+				}
+			}
+		}()
+	}
+
+	// On SIGHUP, reload the config file, like daemons do. It leaves the
+	// recording alone.
+	if len(reloadSignals) > 0 && options.ConfigPath != "" {
+		reloadChan := make(chan os.Signal, 1)
+		signal.Notify(reloadChan, reloadSignals...)
+		go func() {
+			for range reloadChan {
+				reloadConfig(shellcast, options.Flags, options.ConfigPath)
+			}
+		}()
+	}
+
+	// Remove temp files left behind by crashed sessions
+	if !config.KeepTemp {
+		if removed, err := SweepTempFiles(os.TempDir(), staleTempAge); err == nil && removed > 0 {
+			shellcast.logger.Debugf("Removed %d stale temp files", removed)
+		}
+	}
+
+	// Make sure FFmpeg works before running anything
+	if (options.Render != "" || (config.RendersVideo() && config.usesFFmpeg())) && !config.DryRun {
+		if err := shellcast.CheckFFmpeg(); err != nil {
+			log.Fatalf("FFmpeg check failed: %v", err)
+		}
+	}
+
+	// Start the live output server if requested
+	if config.ServeAddr != "" {
+		if err := shellcast.StartServer(config.ServeAddr); err != nil {
+			log.Fatalf("Error starting server: %v", err)
+		}
+	}
 
 	// Start recording if requested
-	if *record {
+	if options.Record {
 		if err := shellcast.StartRecording(); err != nil {
 			log.Printf("Warning: Failed to start recording: %v", err)
 		}
 	}
 
+	// Only show what would be streamed
+	if config.DryRun && !options.Interactive {
+		fmt.Fprintln(shellcast.Stdout, shellcast.FFmpegCommandLine())
+		shellcast.Cleanup()
+		return
+	}
+
 	// Run in appropriate mode
-	if *interactive {
-		options := InteractiveOptions{
-			ConfigPath: *configFile,
+	if options.Interactive {
+		RunInteractiveMode(shellcast, InteractiveOptions{
+			ConfigPath: options.ConfigPath,
+		})
+	} else if options.Split {
+		// Split mode with multiple commands, from the command line or
+		// else from split_commands in the config
+		specs := SplitSpecs(args)
+		if len(specs) == 0 {
+			specs = config.SplitCommands
 		}
-		RunInteractiveMode(shellcast, options)
-	} else if *splitMode && hasCommand {
-		// Split mode with multiple commands
-		if err := shellcast.ExecuteSplitCommands(args); err != nil {
-			log.Fatalf("Error executing split commands: %v", err)
+		if err := shellcast.ExecuteSplitSpecs(specs); err != nil {
+			log.Printf("Error executing split commands: %v", err)
+			exitCode = 1
 		}
-	} else if hasCommand {
+	} else {
 		command := strings.Join(args, " ")
 
-		// Start streaming if RTMP URL is provided
-		if config.RTMPUrl != "" {
-			if err := shellcast.StartStreaming(); err != nil {
+		// With a duration, the stream and command are stopped when the
+		// time is up
+		ctx := context.Background()
+		if options.Duration > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, options.Duration)
+			defer cancel()
+		}
+
+		// Capture the output for rendering, or start streaming if an RTMP
+		// URL or other output is provided
+		if options.Render != "" {
+			shellcast.StartCapture()
+		} else if config.RendersVideo() {
+			if err := shellcast.StartStreamingContext(ctx); err != nil {
 				log.Fatalf("Error starting stream: %v", err)
 			}
 			// Add delay to ensure streaming starts
-			time.Sleep(2 * time.Second)
+			time.Sleep(time.Duration(config.StreamStartupSeconds) * time.Second)
 		}
 
-		// Execute the command
-		if err := shellcast.ExecuteCommand(command); err != nil {
+		// Execute the script or command, replay the recording or follow
+		// the file
+		if options.Recording != "" {
+			shellcast.replayRecording(ctx, options.Recording, events)
+		} else if options.Tail != "" {
+			if err := shellcast.TailFile(ctx, options.Tail); err != nil {
+				log.Printf("Error following %s: %v", options.Tail, err)
+				exitCode = 1
+			}
+		} else if options.Script != "" {
+			if err := shellcast.RunScriptContext(ctx, steps); err != nil && ctx.Err() == nil {
+				log.Printf("Error running script: %v", err)
+				exitCode = 1
+			}
+		} else if err := shellcast.ExecuteCommandContext(ctx, command); err != nil && ctx.Err() == nil {
 			log.Printf("Command error: %v", err)
+			exitCode = commandExitCode(err)
+		}
+
+		if ctx.Err() != nil {
+			shellcast.logger.Infof("Duration of %s reached", options.Duration)
+		}
+
+		if options.Render != "" {
+			if err := shellcast.RenderCapture(options.Render); err != nil {
+				log.Printf("Error rendering %s: %v", options.Render, err)
+				exitCode = 1
+			}
 		}
 
 		// If streaming, keep it running for a few seconds after command completes
-		if shellcast.streaming {
-			fmt.Println("Command completed. Streaming for 5 more seconds...")
-			time.Sleep(5 * time.Second)
+		if shellcast.isStreaming() {
+			if config.StreamGraceSeconds > 0 {
+				shellcast.logger.Infof("Command completed. Streaming for %d more seconds...", config.StreamGraceSeconds)
+				time.Sleep(time.Duration(config.StreamGraceSeconds) * time.Second)
+			}
 			shellcast.StopStreaming()
 		}
-	} else {
-		flag.Usage()
-		fmt.Println("\nExamples:")
-		fmt.Println("  shellcast -interactive")
-		fmt.Println("  shellcast -rtmp rtmp://server/app ls -la")
-		fmt.Println("  shellcast -theme hacker -timestamp on -record top")
-		fmt.Println("  shellcast -split \"ls -la\" \"top -n 1\"")
 	}
 
 	// Clean up before exit
 	shellcast.Cleanup()
+	if exitCode != 0 {
+		os.Exit(exitCode)
+	}
+}
+
+// commandExitCode returns the exit status for a command that failed with
+// err: 127 if it wasn't found and 124 if it timed out, as a shell and
+// timeout(1) would, the command's own status if it exited with one, else 1
+func commandExitCode(err error) int {
+	var notFound *CommandNotFoundError
+	var timeout *CommandTimeoutError
+	var exitErr *exec.ExitError
+	switch {
+	case errors.As(err, &notFound):
+		return 127
+	case errors.As(err, &timeout):
+		return 124
+	case errors.As(err, &exitErr) && exitErr.ExitCode() > 0:
+		return exitErr.ExitCode()
+	}
+	return 1
 }
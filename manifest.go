@@ -0,0 +1,106 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// manifestSuffix is appended to a recording's path to name its manifest
+const manifestSuffix = ".meta.json"
+
+// RecordingManifest describes a finished recording for tools that index
+// recordings. It is written next to the recording as
+// <recording>.meta.json when the recording stops or is rotated.
+type RecordingManifest struct {
+	Version         string            `json:"version"` // of ShellCast
+	Recording       string            `json:"recording"`
+	Command         string            `json:"command"`   // ShellCast's command line
+	Commands        []ManifestCommand `json:"commands"`  // finished while recording
+	ExitCode        *int              `json:"exit_code"` // of the last command, null if none finished
+	StartTime       time.Time         `json:"start_time"`
+	EndTime         time.Time         `json:"end_time"`
+	DurationSeconds float64           `json:"duration_seconds"`
+	Theme           string            `json:"theme"`
+	ScreenWidth     int               `json:"screen_width"`
+	ScreenHeight    int               `json:"screen_height"`
+	Config          json.RawMessage   `json:"config"` // stream keys masked
+}
+
+// ManifestCommand is a command that finished during a recording
+type ManifestCommand struct {
+	Command  string `json:"command"`
+	ExitCode int    `json:"exit_code"` // -1 if killed by a signal
+}
+
+// manifestPath returns the path of the manifest for a recording
+func manifestPath(recording string) string {
+	return recording + manifestSuffix
+}
+
+// maskedConfig returns a copy of config with the stream keys in its
+// RTMP URLs masked, for writing it out
+func maskedConfig(config Config) Config {
+	config.RTMPUrl = maskStreamKeys(config.RTMPUrl, config.StreamKey)
+	outputs := make([]OutputSpec, len(config.Outputs))
+	for i, output := range config.Outputs {
+		output.URL = maskStreamKeys(output.URL, config.StreamKey)
+		outputs[i] = output
+	}
+	config.Outputs = outputs
+	return config
+}
+
+// commandExited notes the exit code of a finished command for the
+// recording manifest and passes it on to the event handler
+func (s *ShellCast) commandExited(command string, code int) {
+	s.mutex.Lock()
+	if s.recording {
+		s.recordCommands = append(s.recordCommands, ManifestCommand{
+			Command:  maskStreamKeys(command, s.cfg().StreamKey),
+			ExitCode: code,
+		})
+	}
+	s.mutex.Unlock()
+	s.events.OnCommandExit(command, code)
+}
+
+// writeManifest writes the manifest of the recording at path, which
+// started at start. The caller must hold the mutex.
+func (s *ShellCast) writeManifest(path string, start time.Time) error {
+	config, err := json.Marshal(maskedConfig(*s.cfg()))
+	if err != nil {
+		return fmt.Errorf("error encoding config: %v", err)
+	}
+	end := time.Now()
+	manifest := RecordingManifest{
+		Version:         GetBuildInfo().Version,
+		Recording:       path,
+		Command:         maskStreamKeys(strings.Join(os.Args, " "), s.cfg().StreamKey),
+		Commands:        s.recordCommands,
+		StartTime:       start,
+		EndTime:         end,
+		DurationSeconds: end.Sub(start).Seconds(),
+		Theme:           s.cfg().ThemeName,
+		ScreenWidth:     s.cfg().ScreenWidth,
+		ScreenHeight:    s.cfg().ScreenHeight,
+		Config:          config,
+	}
+	if manifest.Commands == nil {
+		manifest.Commands = []ManifestCommand{}
+	}
+	if n := len(s.recordCommands); n > 0 {
+		manifest.ExitCode = &s.recordCommands[n-1].ExitCode
+	}
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error encoding manifest: %v", err)
+	}
+	if err := os.WriteFile(manifestPath(path), append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("error writing manifest: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// partialLineDelay is how long an unfinished line, such as a prompt,
+// waits for the rest of the line before it is shown
+const partialLineDelay = 100 * time.Millisecond
+
+// outputReader is one output stream of a command, as read by readOutput
+type outputReader struct {
+	stream  string
+	source  string
+	command string // program name, for the prefix template
+	pid     int
+	lines   *lineCounter // nil = not numbered
+	number  int          // number of the current line, 0 = none yet
+	stamp   lineStamp    // whether the current line shows its timestamp
+	w       io.Writer
+}
+
+// readChunks reads r in the background and sends whatever is available,
+// so output without a newline isn't held back. The channel is closed at
+// EOF or on a read error.
+func readChunks(r io.Reader) <-chan []byte {
+	chunks := make(chan []byte)
+	go func() {
+		defer close(chunks)
+		buf := make([]byte, 32*1024)
+		for {
+			n, err := r.Read(buf)
+			if n > 0 {
+				chunks <- append([]byte(nil), buf[:n]...)
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+	return chunks
+}
+
+// redrawLine returns a client's terminal to the start of the line and
+// clears it
+const redrawLine = "\r\x1b[K"
+
+// currentLine returns a line redrawn with carriage returns as a terminal
+// would show it: after each carriage return the following text overwrites
+// the line from its start, and whatever is longer than the new text stays
+func currentLine(text string) string {
+	if strings.IndexByte(text, '\r') < 0 {
+		return text
+	}
+
+	var line []rune
+	col := 0
+	for _, r := range text {
+		if r == '\r' {
+			col = 0
+			continue
+		}
+		if col < len(line) {
+			line[col] = r
+		} else {
+			line = append(line, r)
+		}
+		col++
+	}
+	return string(line)
+}
+
+// truncatedMarker is appended to lines cut off at MaxLineBytes
+const truncatedMarker = " [truncated]"
+
+// truncateLine cuts line to MaxLineBytes, without splitting a UTF-8
+// character
+func (s *ShellCast) truncateLine(line []byte) string {
+	max := s.cfg().MaxLineBytes
+	if len(line) <= max {
+		return string(line)
+	}
+	for max > 0 && !utf8.RuneStart(line[max]) {
+		max--
+	}
+	s.logger.Debugf("Truncated an output line of more than %d bytes", s.cfg().MaxLineBytes)
+	return string(line[:max]) + truncatedMarker
+}
+
+// showPartial shows the unfinished line of reader at the end of the
+// terminal, buffer, stream and connected clients, replacing what was
+// shown for it before. The recording only gets complete lines.
+func (s *ShellCast) showPartial(reader *outputReader, text string) {
+	text = currentLine(text)
+
+	// Half a JSON object would be unreadable, so those wait for the newline
+	if s.cfg().OutputFormat == "json" {
+		return
+	}
+
+	formatted := s.formatOutput(reader, text)
+	forward := s.passesFilters(text)
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.replaceOpenLine(reader)
+	fmt.Fprint(reader.w, formatted)
+	s.openLine = reader
+
+	if !forward || s.streamPaused {
+		return
+	}
+	s.outputBuffer.WriteString(formatted)
+	s.broadcast(formatted)
+	s.openBufLen = len(formatted)
+	if s.streamOut != nil {
+		if _, err := s.streamOut.WriteString(formatted); err != nil {
+			s.logger.Errorf("Error writing output: %v", err)
+			return
+		}
+		s.openStreamLen = len(formatted)
+	}
+}
+
+// ownsOpenLine reports whether reader's unfinished line is being shown
+func (s *ShellCast) ownsOpenLine(reader *outputReader) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.openLine != nil && s.openLine == reader
+}
+
+// replaceOpenLine prepares for output from reader, which may be nil for
+// output not read by readOutput. If reader's own unfinished line is shown
+// it is removed so it can be written again; an unfinished line of another
+// reader is ended where it is. The caller must hold s.mutex.
+func (s *ShellCast) replaceOpenLine(reader *outputReader) {
+	s.clearKeepalive()
+	if s.openLine == nil {
+		return
+	}
+	if reader == nil || s.openLine != reader {
+		s.closeOpenLine()
+		return
+	}
+
+	// The terminal line is redrawn from its start
+	fmt.Fprint(reader.w, "\r")
+
+	if s.openBufLen > 0 {
+		s.outputBuffer.Truncate(s.outputBuffer.Len() - s.openBufLen)
+		s.broadcast(redrawLine)
+	}
+	if s.streamOut != nil && s.openStreamLen > 0 {
+		if err := s.streamOut.Truncate(s.streamOut.Size() - int64(s.openStreamLen)); err != nil {
+			s.logger.Errorf("Error writing output: %v", err)
+		}
+	}
+	s.forgetOpenLine()
+}
+
+// closeOpenLine ends the shown unfinished line as it is, so other output
+// can follow it. The caller must hold s.mutex.
+func (s *ShellCast) closeOpenLine() {
+	if s.openLine == nil {
+		return
+	}
+
+	fmt.Fprintln(s.openLine.w)
+	if s.openBufLen > 0 {
+		s.outputBuffer.WriteString("\n")
+		s.broadcast("\n")
+	}
+	if s.streamOut != nil && s.openStreamLen > 0 {
+		if _, err := s.streamOut.WriteString("\n"); err != nil {
+			s.logger.Errorf("Error writing output: %v", err)
+		}
+	}
+	s.forgetOpenLine()
+}
+
+// forgetOpenLine clears the unfinished line state. The caller must hold
+// s.mutex.
+func (s *ShellCast) forgetOpenLine() {
+	s.openLine = nil
+	s.openBufLen = 0
+	s.openStreamLen = 0
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// PlatformPreset holds the ingest URL and recommended encoder settings
+// of a streaming service. Only the stream key is left to the user.
+type PlatformPreset struct {
+	Name            string
+	RTMPBase        string
+	VideoBitrate    string // for the default 720p at 30 fps
+	KeyframeSeconds int
+}
+
+// platformPresets are the supported streaming services
+var platformPresets = map[string]PlatformPreset{
+	"twitch": {
+		Name:            "twitch",
+		RTMPBase:        "rtmp://live.twitch.tv/app",
+		VideoBitrate:    "3000k",
+		KeyframeSeconds: 2,
+	},
+	"youtube": {
+		Name:            "youtube",
+		RTMPBase:        "rtmp://a.rtmp.youtube.com/live2",
+		VideoBitrate:    "4000k",
+		KeyframeSeconds: 2,
+	},
+}
+
+// SupportedPlatforms lists the names accepted by -platform
+func SupportedPlatforms() []string {
+	names := make([]string, 0, len(platformPresets))
+	for name := range platformPresets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// bitratePattern matches FFmpeg bitrates such as 2500k or 4.5M
+var bitratePattern = regexp.MustCompile(`^[0-9]+(\.[0-9]+)?[kKmM]?$`)
+
+// checkPlatform returns an error for an unknown platform name
+func checkPlatform(name string) error {
+	if _, ok := platformPresets[name]; name != "" && !ok {
+		return fmt.Errorf("unknown platform '%s' (supported: %s)",
+			name, strings.Join(SupportedPlatforms(), ", "))
+	}
+	return nil
+}
+
+// streamBase returns the RTMP URL the stream key is joined to: RTMPBase,
+// or else the ingest URL of the platform
+func (c *Config) streamBase() string {
+	if c.RTMPBase != "" {
+		return c.RTMPBase
+	}
+	return platformPresets[c.Platform].RTMPBase
+}
+
+// videoBitrate returns VideoBitrate, or else the platform's recommendation
+func (c *Config) videoBitrate() string {
+	if c.VideoBitrate != "" {
+		return c.VideoBitrate
+	}
+	return platformPresets[c.Platform].VideoBitrate
+}
+
+// keyframeSeconds returns KeyframeSeconds, or else the platform's
+// recommendation
+func (c *Config) keyframeSeconds() int {
+	if c.KeyframeSeconds > 0 {
+		return c.KeyframeSeconds
+	}
+	return platformPresets[c.Platform].KeyframeSeconds
+}
+
+// rateControlArgs returns the FFmpeg bitrate and keyframe arguments for
+// the configured or platform settings. Frames are rendered at 30 fps.
+func (c *Config) rateControlArgs() []string {
+	var args []string
+	if bitrate := c.videoBitrate(); bitrate != "" {
+		args = append(args, "-b:v", bitrate, "-maxrate", bitrate, "-bufsize", bitrate)
+	}
+	if seconds := c.keyframeSeconds(); seconds > 0 {
+		gop := fmt.Sprint(seconds * 30)
+		args = append(args, "-g", gop, "-keyint_min", gop)
+	}
+	return args
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// playbackEvent is a chunk of recorded output and the delay before it
+type playbackEvent struct {
+	Delay time.Duration
+	Text  string
+}
+
+// loadRecording reads a recording for playback. Asciicast (.cast) files
+// keep their original timing; plain text recordings are emitted at
+// linesPerSecond, or all at once when it is zero. Either may be gzip
+// compressed, with a .gz extension.
+func loadRecording(path string, linesPerSecond float64) ([]playbackEvent, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("error opening recording: %v", err)
+	}
+	defer file.Close()
+
+	var r io.Reader = file
+	if strings.HasSuffix(path, ".gz") {
+		gz, err := gzip.NewReader(file)
+		if err != nil {
+			return nil, fmt.Errorf("error reading compressed recording: %v", err)
+		}
+		defer gz.Close()
+		r = gz
+		path = strings.TrimSuffix(path, ".gz")
+	}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+
+	if strings.HasSuffix(path, ".cast") {
+		return parseAsciicast(scanner)
+	}
+
+	var delay time.Duration
+	if linesPerSecond > 0 {
+		delay = time.Duration(float64(time.Second) / linesPerSecond)
+	}
+
+	var events []playbackEvent
+	for scanner.Scan() {
+		events = append(events, playbackEvent{Delay: delay, Text: scanner.Text() + "\n"})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading recording: %v", err)
+	}
+	return events, nil
+}
+
+// parseAsciicast reads asciicast v2 output events. The first line is a
+// JSON header, followed by one [time, type, data] array per event.
+func parseAsciicast(scanner *bufio.Scanner) ([]playbackEvent, error) {
+	if !scanner.Scan() {
+		return nil, fmt.Errorf("empty asciicast file")
+	}
+
+	var header struct {
+		Version int `json:"version"`
+	}
+	if err := json.Unmarshal(scanner.Bytes(), &header); err != nil {
+		return nil, fmt.Errorf("error parsing asciicast header: %v", err)
+	}
+	if header.Version != 2 {
+		return nil, fmt.Errorf("unsupported asciicast version %d", header.Version)
+	}
+
+	var events []playbackEvent
+	var last float64
+	for lineNum := 2; scanner.Scan(); lineNum++ {
+		var event []interface{}
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil || len(event) != 3 {
+			return nil, fmt.Errorf("invalid asciicast event on line %d", lineNum)
+		}
+
+		at, ok1 := event[0].(float64)
+		kind, ok2 := event[1].(string)
+		data, ok3 := event[2].(string)
+		if !ok1 || !ok2 || !ok3 {
+			return nil, fmt.Errorf("invalid asciicast event on line %d", lineNum)
+		}
+		if kind != "o" {
+			continue
+		}
+
+		delay := time.Duration((at - last) * float64(time.Second))
+		if delay < 0 {
+			delay = 0
+		}
+		last = at
+		events = append(events, playbackEvent{Delay: delay, Text: data})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading recording: %v", err)
+	}
+	return events, nil
+}
+
+// StreamRecording feeds a recording through the output pipeline as if a
+// command were producing it, so it reaches the stream, recording and
+// connected clients like live output. Timing is handled as in
+// ServePlayback.
+func (s *ShellCast) StreamRecording(path string, linesPerSecond float64) error {
+	return s.StreamRecordingContext(context.Background(), path, linesPerSecond)
+}
+
+// StreamRecordingContext is like StreamRecording, but stops when ctx is
+// cancelled
+func (s *ShellCast) StreamRecordingContext(ctx context.Context, path string, linesPerSecond float64) error {
+	events, err := loadRecording(path, linesPerSecond)
+	if err != nil {
+		return err
+	}
+	return s.replayRecording(ctx, path, events)
+}
+
+// replayRecording writes the events of the recording at path to the
+// output pipeline with their delays
+func (s *ShellCast) replayRecording(ctx context.Context, path string, events []playbackEvent) error {
+	s.logger.Infof("Replaying %s", path)
+
+	pr, pw := io.Pipe()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		s.readOutput(pr, &outputReader{stream: "stdout", command: filepath.Base(path), lines: s.newLineCounter(), w: s.Stdout}, nil)
+	}()
+
+	for _, event := range events {
+		if event.Delay > 0 {
+			select {
+			case <-time.After(event.Delay):
+			case <-ctx.Done():
+			}
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		if _, err := io.WriteString(pw, event.Text); err != nil {
+			break
+		}
+	}
+	pw.Close()
+	<-done
+	return ctx.Err()
+}
+
+// ServePlayback serves a web page replaying a recording. Every client
+// that connects gets its own replay from the beginning.
+func (s *ShellCast) ServePlayback(addr, path string, linesPerSecond float64) error {
+	events, err := loadRecording(path, linesPerSecond)
+	if err != nil {
+		return err
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", s.handleViewer)
+	mux.HandleFunc("/ws", func(w http.ResponseWriter, r *http.Request) {
+		conn, rw, err := upgradeWebSocket(w, r)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		closed := watchClose(rw)
+		for _, event := range events {
+			if event.Delay > 0 {
+				select {
+				case <-time.After(event.Delay):
+				case <-closed:
+					return
+				}
+			}
+			if err := writeTextFrame(rw.Writer, event.Text); err != nil {
+				return
+			}
+		}
+	})
+
+	s.logger.Infof("Serving playback of %s on http://%s", path, addr)
+	return http.ListenAndServe(addr, mux)
+}
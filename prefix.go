@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// prefixPlaceholders are the placeholders a PrefixTemplate may use
+var prefixPlaceholders = []string{"{time}", "{cmd}", "{pid}", "{stream}", "{source}"}
+
+// placeholderPattern finds placeholders in a prefix template
+var placeholderPattern = regexp.MustCompile(`\{[a-z_]*\}`)
+
+// checkPrefixTemplate returns an error for placeholders a prefix template
+// can't fill in
+func checkPrefixTemplate(template string) error {
+	for _, placeholder := range placeholderPattern.FindAllString(template, -1) {
+		if !containsString(prefixPlaceholders, placeholder) {
+			return fmt.Errorf("unknown placeholder %s (supported: %s)",
+				placeholder, strings.Join(prefixPlaceholders, ", "))
+		}
+	}
+	return nil
+}
+
+// renderPrefix fills in the prefix template for a line of reader. Values
+// that don't apply, like the PID of a typed command, are shown as "-".
+func (s *ShellCast) renderPrefix(reader *outputReader, now time.Time) string {
+	timestamp := now.Format(s.cfg().TimestampFormat)
+	if s.cfg().TimestampMode == "relative" {
+		timestamp = formatElapsed(now.Sub(s.startTime))
+	}
+
+	pid := "-"
+	if reader.pid > 0 {
+		pid = strconv.Itoa(reader.pid)
+	}
+
+	return strings.NewReplacer(
+		"{time}", timestamp,
+		"{cmd}", orDash(reader.command),
+		"{pid}", pid,
+		"{stream}", orDash(reader.stream),
+		"{source}", orDash(reader.source),
+	).Replace(s.cfg().PrefixTemplate)
+}
+
+// commandName returns the program name of a command line, for {cmd}.
+// In shell mode this is the first program of the script, not the shell.
+func commandName(command string) string {
+	fields := strings.Fields(command)
+	if len(fields) == 0 {
+		return ""
+	}
+	return filepath.Base(strings.Trim(fields[0], `"'`))
+}
+
+// orDash returns value, or "-" if it is empty
+func orDash(value string) string {
+	if value == "" {
+		return "-"
+	}
+	return value
+}
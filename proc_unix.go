@@ -0,0 +1,78 @@
+//go:build !windows
+
+package main
+
+import (
+	"os"
+	"os/exec"
+	"os/signal"
+	"syscall"
+	"unsafe"
+)
+
+// setProcessGroup starts the command in its own process group so that
+// it and any subprocesses it spawns can be signalled together. A command
+// reading the terminal ShellCast runs in the foreground of gets the
+// foreground, as a shell does, since a background process group reading
+// or configuring the terminal is stopped.
+func setProcessGroup(cmd *exec.Cmd) {
+	attr := &syscall.SysProcAttr{Setpgid: true}
+	if f, ok := cmd.Stdin.(*os.File); ok && inForeground(f) {
+		attr.Foreground = true
+		attr.Ctty = int(f.Fd())
+	}
+	cmd.SysProcAttr = attr
+}
+
+// inForeground reports whether f is a terminal in whose foreground
+// process group ShellCast runs
+func inForeground(f *os.File) bool {
+	var pgrp int32
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), syscall.TIOCGPGRP, uintptr(unsafe.Pointer(&pgrp)))
+	return errno == 0 && int(pgrp) == syscall.Getpgrp()
+}
+
+// restoreForeground gives the terminal back to ShellCast's process group
+// once a command that had the foreground exited
+func restoreForeground(cmd *exec.Cmd) {
+	if cmd.SysProcAttr == nil || !cmd.SysProcAttr.Foreground {
+		return
+	}
+
+	// Taking the terminal from the background raises SIGTTOU
+	signal.Ignore(syscall.SIGTTOU)
+	defer signal.Reset(syscall.SIGTTOU)
+	pgrp := int32(syscall.Getpgrp())
+	syscall.Syscall(syscall.SYS_IOCTL, uintptr(cmd.SysProcAttr.Ctty), syscall.TIOCSPGRP, uintptr(unsafe.Pointer(&pgrp)))
+}
+
+// defaultShell runs commands in shell mode and hooks
+const defaultShell = "sh"
+
+// defaultFontFile is the font drawtext uses when FontFile is empty.
+// Empty lets FFmpeg pick one through fontconfig.
+func defaultFontFile() string {
+	return ""
+}
+
+// terminationSignals stop the session and clean up
+var terminationSignals = []os.Signal{os.Interrupt, syscall.SIGTERM}
+
+// rotateSignals rotate the recording when received
+var rotateSignals = []os.Signal{syscall.SIGUSR1}
+
+// reloadSignals reload the config file when received
+var reloadSignals = []os.Signal{syscall.SIGHUP}
+
+// killProcessGroup kills every process in the command's process group
+func killProcessGroup(cmd *exec.Cmd) error {
+	if cmd.Process == nil {
+		return nil
+	}
+
+	// A negative pid signals the whole group led by the child
+	if err := syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL); err != nil {
+		return cmd.Process.Kill()
+	}
+	return nil
+}
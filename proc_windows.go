@@ -0,0 +1,74 @@
+//go:build windows
+
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"syscall"
+)
+
+// setProcessGroup starts the command in a new process group so console
+// control events aimed at ShellCast are not delivered to it directly
+func setProcessGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		CreationFlags: syscall.CREATE_NEW_PROCESS_GROUP,
+	}
+}
+
+// restoreForeground does nothing, as console process groups share the
+// console
+func restoreForeground(cmd *exec.Cmd) {}
+
+// defaultShell runs commands in shell mode and hooks
+const defaultShell = "cmd"
+
+// windowsFonts are monospace fonts shipped with Windows, in order of
+// preference
+var windowsFonts = []string{"consola.ttf", "lucon.ttf", "cour.ttf"}
+
+// defaultFontFile is the font drawtext uses when FontFile is empty.
+// FFmpeg builds for Windows usually lack fontconfig, so a font from the
+// Windows font directory is picked.
+func defaultFontFile() string {
+	windir := os.Getenv("WINDIR")
+	if windir == "" {
+		windir = `C:\Windows`
+	}
+	for _, name := range windowsFonts {
+		path := filepath.Join(windir, "Fonts", name)
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+	}
+	return ""
+}
+
+// terminationSignals stop the session and clean up. Go delivers closing
+// the console window, logging off and shutting down as SIGTERM.
+var terminationSignals = []os.Signal{os.Interrupt, syscall.SIGTERM}
+
+// rotateSignals rotate the recording when received. Windows has no
+// SIGUSR1, so use the interactive rotate command there.
+var rotateSignals []os.Signal
+
+// reloadSignals reload the config file when received. Windows has no
+// SIGHUP, so use the interactive load command there.
+var reloadSignals []os.Signal
+
+// killProcessGroup kills the command and its descendants. Windows has no
+// process group signals, so the tree is terminated with taskkill; if that
+// is unavailable only the direct child is killed.
+func killProcessGroup(cmd *exec.Cmd) error {
+	if cmd.Process == nil {
+		return nil
+	}
+
+	kill := exec.Command("taskkill", "/T", "/F", "/PID", strconv.Itoa(cmd.Process.Pid))
+	if err := kill.Run(); err != nil {
+		return cmd.Process.Kill()
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"strings"
+	"time"
+)
+
+// streamRateAllows counts a line appended to the stream file and reports
+// whether it is within MaxLinesPerSecond. Lines over the limit are dropped
+// from the stream, since FFmpeg would fall further and further behind.
+// The caller must hold s.mutex.
+func (s *ShellCast) streamRateAllows(now time.Time) bool {
+	limit := s.cfg().MaxLinesPerSecond
+	if limit <= 0 {
+		return true
+	}
+
+	if now.Sub(s.rateWindow) >= time.Second {
+		// Warn again for the next flood once the output has calmed down
+		if s.rateDropped == 0 {
+			s.rateWarned = false
+		}
+		s.rateWindow = now
+		s.rateLines = 0
+		s.rateDropped = 0
+	}
+
+	s.rateLines++
+	if s.rateLines <= limit {
+		return true
+	}
+
+	if !s.rateWarned {
+		s.logger.Errorf("Warning: output exceeds %d lines per second, dropping lines from the stream", limit)
+		s.rateWarned = true
+	}
+	if s.rateDropped == 0 && s.cfg().CoalesceLines {
+		time.AfterFunc(time.Second-now.Sub(s.rateWindow), s.coalesceStream)
+	}
+	s.rateDropped++
+	s.droppedLines++
+	return false
+}
+
+// coalesceStream replaces the stream text with the latest
+// MaxLinesPerSecond lines, so it catches up with the output after lines
+// were dropped
+func (s *ShellCast) coalesceStream() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.streamPaused {
+		return
+	}
+	latest := lastLines(s.outputBuffer.String(), s.cfg().MaxLinesPerSecond)
+	if err := s.rewriteStreamFile(latest); err != nil {
+		s.logger.Errorf("Error writing output: %v", err)
+	}
+	s.openStreamLen = s.openBufLen
+}
+
+// lastLines returns the last n newline-terminated lines of text
+func lastLines(text string, n int) string {
+	end := len(text)
+	if strings.HasSuffix(text, "\n") {
+		end--
+	}
+	start := end
+	for i := 0; i < n && start > 0; i++ {
+		start = strings.LastIndexByte(text[:start], '\n')
+		if start < 0 {
+			return text
+		}
+	}
+	return text[start+1:]
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// capturedLine is an output line kept for an offline render, with the
+// time it was written relative to StartCapture. An open line, such as a
+// command being typed, is replaced by the line captured after it.
+type capturedLine struct {
+	at   time.Duration
+	text string
+	open bool
+}
+
+// renderFrame is the text on screen from at until the next frame
+type renderFrame struct {
+	at   time.Duration
+	text string
+}
+
+// StartCapture starts keeping output lines with their timing for
+// RenderCapture, instead of streaming them as they are written
+func (s *ShellCast) StartCapture() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.capturing = true
+	s.captured = nil
+	s.captureStart = time.Now()
+}
+
+// captureLine keeps line for the offline render, if capturing. The
+// caller must hold the mutex.
+func (s *ShellCast) captureLine(line string) {
+	if s.capturing {
+		s.captured = append(s.captured, capturedLine{time.Since(s.captureStart), line, false})
+	}
+}
+
+// captureOpenLine keeps the unfinished line for the offline render, if
+// capturing, until the next captured line replaces it. The caller must
+// hold the mutex.
+func (s *ShellCast) captureOpenLine(line string) {
+	if s.capturing {
+		s.captured = append(s.captured, capturedLine{time.Since(s.captureStart), line, true})
+	}
+}
+
+// RenderCapture ends the capture started by StartCapture and encodes it
+// to the video at path in a single FFmpeg pass. Without live pacing the
+// encode runs as fast as FFmpeg can go, while each line still appears
+// at the time it was written. The last screen is held for
+// StreamGraceSeconds.
+func (s *ShellCast) RenderCapture(path string) error {
+	return s.RenderCaptureContext(context.Background(), path)
+}
+
+// RenderCaptureContext is RenderCapture, stopping FFmpeg when ctx is
+// cancelled
+func (s *ShellCast) RenderCaptureContext(ctx context.Context, path string) error {
+	s.mutex.Lock()
+	lines, end := s.captured, time.Since(s.captureStart)
+	s.capturing = false
+	s.captured = nil
+	s.mutex.Unlock()
+
+	dir, err := os.MkdirTemp("", "shellcast_render_*")
+	if err != nil {
+		return fmt.Errorf("error creating render directory: %v", err)
+	}
+	if s.cfg().KeepTemp {
+		s.logger.Infof("Keeping render files: %s", dir)
+	} else {
+		defer os.RemoveAll(dir)
+	}
+
+	// Each frame's text goes to its own file, and sendcmd points drawtext
+	// at the next file when its time comes
+	frames := s.renderFrames(lines)
+	var commands strings.Builder
+	for i, frame := range frames {
+		textFile := filepath.Join(dir, fmt.Sprintf("frame%06d.txt", i))
+		if err := os.WriteFile(textFile, []byte(frame.text), 0644); err != nil {
+			return fmt.Errorf("error writing render frame: %v", err)
+		}
+		if i > 0 {
+			fmt.Fprintf(&commands, "%.3f drawtext reinit 'textfile=%s';\n",
+				frame.at.Seconds(), escapeFilterPath(textFile))
+		}
+	}
+	commandFile := filepath.Join(dir, "commands.txt")
+	if err := os.WriteFile(commandFile, []byte(commands.String()), 0644); err != nil {
+		return fmt.Errorf("error writing render commands: %v", err)
+	}
+
+	// A GIF is made from an MP4, as when streaming, see makeGIF
+	output := OutputSpec{URL: path}
+	if outputFormat(output) == "gif" {
+		output = OutputSpec{URL: gifSourcePath(path), Format: "mp4"}
+	}
+	if dir := filepath.Dir(output.URL); dir != "." {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return fmt.Errorf("error creating video directory: %v", err)
+		}
+	}
+
+	duration := end + time.Duration(s.cfg().StreamGraceSeconds)*time.Second
+	ffmpegPath, err := s.resolveFFmpeg()
+	if err != nil {
+		return err
+	}
+	args := s.renderCommand(filepath.Join(dir, "frame000000.txt"), commandFile, duration, output)
+	s.logger.Infof("Rendering %d lines (%s) to %s", len(lines), duration.Round(time.Millisecond), path)
+	s.logger.Debugf("Running FFmpeg: %s %s", ffmpegPath, strings.Join(args, " "))
+
+	stderrLog := s.newFFmpegLog()
+	proc, err := s.startCommand(ctx, CommandSpec{
+		Name:   ffmpegPath,
+		Args:   args,
+		Stdout: stderrLog,
+		Stderr: stderrLog,
+	})
+	if err == nil {
+		err = s.waitCommand(proc)
+	}
+	stderrLog.Flush()
+	if err != nil {
+		return fmt.Errorf("error rendering video: %v", err)
+	}
+
+	if output.URL != path {
+		return s.makeGIF(path)
+	}
+	return nil
+}
+
+// renderCommand returns the FFmpeg arguments for an offline render: the
+// live stream's input, filter and encoder settings, without -re and
+// with a fixed duration
+func (s *ShellCast) renderCommand(firstFrame, commandFile string, duration time.Duration, output OutputSpec) []string {
+	encoder := s.cfg().Encoder
+	if encoder == "auto" {
+		encoder = s.selectEncoder()
+	}
+	globalArgs, hwFilter, codecArgs := s.encoderSettings(encoder)
+
+	var args []string
+	if s.logger.Level < LogDebug {
+		args = append(args, "-hide_banner", "-loglevel", "error")
+	}
+	// FFmpeg would otherwise read commands from the terminal, and put it
+	// in raw mode
+	args = append(args, "-nostdin", "-y")
+	args = append(args, globalArgs...)
+	args = append(args,
+		"-f", "lavfi",
+		"-i", fmt.Sprintf("color=size=%dx%d:rate=30:color=%s:duration=%.3f",
+			s.cfg().ScreenWidth,
+			s.cfg().ScreenHeight,
+			strings.ReplaceAll(s.cfg().BackgroundColor, "#", "0x"),
+			duration.Seconds()),
+		"-vf", fmt.Sprintf("sendcmd=f=%s,%s", escapeFilterPath(commandFile), s.createVideoFilter(firstFrame, hwFilter)),
+		"-c:v", encoder,
+	)
+	args = append(args, codecArgs...)
+	args = append(args, s.cfg().rateControlArgs()...)
+	args = append(args, s.cfg().ExtraFFmpegArgs...)
+	return append(args, "-f", outputFormat(output), output.URL)
+}
+
+// renderFrames turns captured lines into screens. Lines written within
+// the same frame interval share a frame, and each screen shows the last
+// rows that fit, so long output scrolls like a terminal instead of
+// running off the bottom. Lines wider than the screen wrap by display
+// width, so wide CJK characters and emoji take two columns. An open line
+// is redrawn in place by the next line. The first frame is the empty
+// screen at 0.
+func (s *ShellCast) renderFrames(lines []capturedLine) []renderFrame {
+	frames := []renderFrame{{}}
+	config := s.cfg()
+	visible, columns := config.visibleLines(), config.visibleColumns()
+
+	var screen []string
+	for i, line := range lines {
+		if i > 0 && lines[i-1].open {
+			screen[len(screen)-1] = line.text
+		} else {
+			screen = append(screen, line.text)
+		}
+		if len(screen) > visible {
+			screen = screen[len(screen)-visible:]
+		}
+		frame := line.at / streamFrameInterval
+		if i+1 < len(lines) && lines[i+1].at/streamFrameInterval == frame {
+			continue
+		}
+		frames = append(frames, renderFrame{
+			at:   frame * streamFrameInterval,
+			text: strings.Join(screenRows(screen, visible, columns), "\n") + "\n",
+		})
+	}
+	return frames
+}
+
+// screenRows wraps the lines on screen to columns and returns the last
+// visible rows
+func screenRows(screen []string, visible, columns int) []string {
+	var rows []string
+	for i := len(screen) - 1; i >= 0 && len(rows) < visible; i-- {
+		rows = append(wrapLine(screen[i], columns), rows...)
+	}
+	if len(rows) > visible {
+		rows = rows[len(rows)-visible:]
+	}
+	return rows
+}
+
+// visibleLines estimates how many lines of text fit on the screen,
+// taking drawtext's line height as 1.2 times the font size
+func (c *Config) visibleLines() int {
+	lineHeight := (c.FontSize*6 + 4) / 5
+	if lineHeight < 1 {
+		lineHeight = 1
+	}
+	n := (c.ScreenHeight - 2*c.TextPadding) / lineHeight
+	if n < 1 {
+		n = 1
+	}
+	return n
+}
+
+// visibleColumns estimates how many columns fit on a line, taking the
+// width of a monospace character as 0.6 times the font size. Columns are
+// display cells, see displayWidth: a wide character takes two.
+func (c *Config) visibleColumns() int {
+	charWidth := (c.FontSize*3 + 4) / 5
+	if charWidth < 1 {
+		charWidth = 1
+	}
+	n := (c.ScreenWidth - 2*c.TextPadding) / charWidth
+	if n < 1 {
+		n = 1
+	}
+	return n
+}
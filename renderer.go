@@ -0,0 +1,212 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Renderer turns the session's output into video. StartStreaming picks
+// one by Config.Renderer. Output reaches a renderer two ways: the stream
+// text file always holds what the screen shows, including unfinished
+// lines, the intro and the paused banner, and WriteLine is called with
+// each complete line as it is appended.
+type Renderer interface {
+	// Start begins rendering textFile
+	Start(textFile string) error
+	// WriteLine receives a line sent to the stream, without the newline.
+	// It is called with ShellCast's lock held and must not block.
+	WriteLine(line string) error
+	// Stop ends rendering
+	Stop() error
+}
+
+// SupportedRenderers lists the renderers Config.Renderer can name.
+// "ffmpeg" draws the text file with FFmpeg and encodes it; "none" only
+// keeps the text file up to date, for testing or an outside program
+// following output_file.
+var SupportedRenderers = []string{"ffmpeg", "none"}
+
+// newRenderer returns the renderer configured for a new stream
+func (s *ShellCast) newRenderer() Renderer {
+	if s.cfg().Renderer == "none" {
+		return nopRenderer{}
+	}
+	return &ffmpegRenderer{s: s}
+}
+
+// ffmpegRenderer runs FFmpeg with drawtext on the text file, which FFmpeg
+// reloads every frame, so lines need no further handling
+type ffmpegRenderer struct {
+	s      *ShellCast
+	cmd    *exec.Cmd
+	exited chan error // receives cmd.Wait's result
+}
+
+// fontCheckTimeout is how long Start waits for FFmpeg's first progress
+// report before assuming drawtext loaded the font
+const fontCheckTimeout = 3 * time.Second
+
+// fontLoadErrors are the messages drawtext prints when it can't use the
+// font file
+var fontLoadErrors = []string{"Could not load font", "Cannot find a valid font", "Could not set font size"}
+
+// Start runs FFmpeg and follows its progress reports. If drawtext can't
+// load the font, FFmpeg exits before the first frame; Start then tries
+// once more with the embedded font, so some text is shown rather than
+// the stream failing.
+func (r *ffmpegRenderer) Start(textFile string) error {
+	s := r.s
+	font := s.fontFile()
+	stderr, started, err := r.start(textFile)
+	if err != nil || !r.fontFailed(stderr, started) {
+		return err
+	}
+
+	s.mutex.Lock()
+	embedded, embeddedErr := s.embeddedFontFile()
+	if embeddedErr == nil && font != embedded {
+		s.failedFont, s.fontFailed = font, true
+	}
+	s.mutex.Unlock()
+
+	// FFmpeg's exit is reported through Stop, as for other failures
+	switch {
+	case embeddedErr != nil:
+		s.logger.Errorf("FFmpeg could not load %s, and the embedded font is unavailable: %v", fontName(font), embeddedErr)
+		return nil
+	case font == embedded:
+		s.logger.Errorf("FFmpeg could not load the embedded font %s either", embeddedFontName)
+		return nil
+	}
+	s.logger.Errorf("FFmpeg could not load %s, retrying with the embedded font %s", fontName(font), embeddedFontName)
+	_, _, err = r.start(textFile)
+	return err
+}
+
+// fontFailed waits until FFmpeg reports progress, exits or
+// fontCheckTimeout passes, and reports whether it exited over the font
+func (r *ffmpegRenderer) fontFailed(stderr *fontErrorWriter, started <-chan struct{}) bool {
+	select {
+	case <-started:
+		return false
+	case <-time.After(fontCheckTimeout):
+		return false
+	case err := <-r.exited:
+		// Keep the result for Stop in case the font wasn't the problem
+		r.exited <- err
+		return stderr.Failed()
+	}
+}
+
+// start runs FFmpeg once, returning its stderr and a channel closed on
+// its first progress report
+func (r *ffmpegRenderer) start(textFile string) (*fontErrorWriter, <-chan struct{}, error) {
+	s := r.s
+
+	// FFmpeg won't create the directory for a video recording
+	if s.cfg().RecordVideo {
+		if err := os.MkdirAll(filepath.Dir(s.videoRecordPath()), 0755); err != nil {
+			return nil, nil, fmt.Errorf("error creating recordings directory: %v", err)
+		}
+	}
+
+	// Fail early with a clear message rather than FFmpeg's start error
+	ffmpegPath, err := s.resolveFFmpeg()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Prepare FFmpeg command
+	_, args := s.ffmpegCommand(textFile)
+	s.logger.Debugf("Running FFmpeg: %s", s.FFmpegCommandLine())
+
+	stderrLog := s.newFFmpegLog()
+	stderr := &fontErrorWriter{w: stderrLog}
+	cmd := exec.Command(ffmpegPath, args...)
+	cmd.Stderr = stderr
+	progress, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, nil, fmt.Errorf("error creating FFmpeg progress pipe: %v", err)
+	}
+
+	if err := cmd.Start(); err != nil {
+		return nil, nil, fmt.Errorf("error starting FFmpeg: %v", err)
+	}
+	r.cmd = cmd
+	r.exited = make(chan error, 1)
+	started := make(chan struct{})
+	go func() {
+		s.readProgress(progress, started)
+		err := cmd.Wait()
+		stderrLog.Flush()
+		r.exited <- err
+	}()
+	return stderr, started, nil
+}
+
+// WriteLine does nothing, since FFmpeg picks lines up from the text file
+func (r *ffmpegRenderer) WriteLine(line string) error {
+	return nil
+}
+
+// Stop kills FFmpeg and makes the GIF outputs from what it rendered
+func (r *ffmpegRenderer) Stop() error {
+	s := r.s
+	s.logger.Debugf("Stopping FFmpeg process %d", r.cmd.Process.Pid)
+	// FFmpeg may have exited on its own, e.g. when the network went away;
+	// what it rendered until then still makes the GIFs
+	if err := r.cmd.Process.Kill(); err != nil && !errors.Is(err, os.ErrProcessDone) {
+		return fmt.Errorf("error killing FFmpeg process: %v", err)
+	}
+	// Killed on purpose, so only the exit matters
+	<-r.exited
+
+	for _, path := range s.gifOutputs() {
+		s.logger.Infof("Making GIF %s", path)
+		if err := s.makeGIF(path); err != nil {
+			return err
+		}
+		s.logger.Infof("GIF saved: %s", path)
+	}
+	return nil
+}
+
+// fontErrorWriter passes FFmpeg's stderr on, noting whether drawtext
+// complained about the font
+type fontErrorWriter struct {
+	w      io.Writer
+	mu     sync.Mutex
+	failed bool
+}
+
+func (f *fontErrorWriter) Write(p []byte) (int, error) {
+	for _, message := range fontLoadErrors {
+		if strings.Contains(string(p), message) {
+			f.mu.Lock()
+			f.failed = true
+			f.mu.Unlock()
+		}
+	}
+	return f.w.Write(p)
+}
+
+// Failed reports whether a font load error was seen
+func (f *fontErrorWriter) Failed() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.failed
+}
+
+// nopRenderer renders nothing
+type nopRenderer struct{}
+
+func (nopRenderer) Start(textFile string) error { return nil }
+func (nopRenderer) WriteLine(line string) error { return nil }
+func (nopRenderer) Stop() error                 { return nil }
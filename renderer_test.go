@@ -0,0 +1,25 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"testing"
+)
+
+// TestFFmpegRendererStopAfterExit checks that stopping a renderer whose
+// FFmpeg already exited isn't an error
+func TestFFmpegRendererStopAfterExit(t *testing.T) {
+	s, _, _ := newTestShellCast(t, GetDefaultConfig(), nil)
+
+	// The test binary running no tests stands in for FFmpeg
+	cmd := exec.Command(os.Args[0], "-test.run=^$")
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	r := &ffmpegRenderer{s: s, cmd: cmd, exited: make(chan error, 1)}
+	r.exited <- cmd.Wait()
+
+	if err := r.Stop(); err != nil {
+		t.Fatalf("Stop after FFmpeg exited: %v", err)
+	}
+}
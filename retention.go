@@ -0,0 +1,78 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// recordingPattern matches the files ShellCast writes to RecordPath:
+// recordings, rotated recordings, their manifests and videos. Retention
+// never touches anything else in the directory.
+const recordingPattern = "shellcast_*"
+
+// pruneRecordings applies the retention policy to RecordPath: files
+// older than RecordMaxAge are removed, then all but the RecordKeep most
+// recently modified ones. The open recording and video are always kept.
+// Zero disables either limit.
+func (s *ShellCast) pruneRecordings(keep ...string) {
+	if s.cfg().RecordKeep <= 0 && s.cfg().RecordMaxAge <= 0 {
+		return
+	}
+
+	matches, err := filepath.Glob(filepath.Join(s.cfg().RecordPath, recordingPattern))
+	if err != nil {
+		return
+	}
+
+	type recording struct {
+		path    string
+		modTime time.Time
+	}
+	var recordings []recording
+	for _, path := range matches {
+		info, err := os.Stat(path)
+		// Manifests go with their recording
+		if err != nil || !info.Mode().IsRegular() || strings.HasSuffix(path, manifestSuffix) {
+			continue
+		}
+		recordings = append(recordings, recording{path, info.ModTime()})
+	}
+	sort.Slice(recordings, func(i, j int) bool {
+		return recordings[i].modTime.After(recordings[j].modTime)
+	})
+
+	// The open files count towards RecordKeep
+	kept := 0
+	for _, rec := range recordings {
+		if containsPath(keep, rec.path) {
+			kept++
+			continue
+		}
+		expired := s.cfg().RecordMaxAge > 0 && time.Since(rec.modTime) > time.Duration(s.cfg().RecordMaxAge)
+		if !expired && (s.cfg().RecordKeep <= 0 || kept < s.cfg().RecordKeep) {
+			kept++
+			continue
+		}
+		if err := os.Remove(rec.path); err != nil {
+			s.logger.Errorf("Error removing old recording: %v", err)
+			continue
+		}
+		if err := os.Remove(manifestPath(rec.path)); err != nil && !os.IsNotExist(err) {
+			s.logger.Errorf("Error removing old recording manifest: %v", err)
+		}
+		s.logger.Infof("Removed old recording: %s", rec.path)
+	}
+}
+
+// containsPath reports whether paths holds path, comparing cleaned paths
+func containsPath(paths []string, path string) bool {
+	for _, p := range paths {
+		if p != "" && filepath.Clean(p) == filepath.Clean(path) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestPruneRecordings(t *testing.T) {
+	// Files in RecordPath and how long ago they were last written. The
+	// open recording and video are the ones ShellCast is writing.
+	const (
+		open  = "shellcast_open.txt"
+		video = "shellcast_open.mp4"
+	)
+	files := map[string]time.Duration{
+		open:                           0,
+		video:                          0,
+		"shellcast_1.txt":              time.Hour,
+		"shellcast_1.txt.meta.json":    time.Hour,
+		"shellcast_2.txt":              2 * time.Hour,
+		"shellcast_2.txt.meta.json":    2 * time.Hour,
+		"shellcast_3.txt.gz":           3 * time.Hour,
+		"shellcast_3.txt.gz.meta.json": 3 * time.Hour,
+		"shellcast_4.mp4":              4 * time.Hour,
+		"notes.txt":                    5 * time.Hour,
+		"old_shellcast_5.txt":          5 * time.Hour,
+	}
+
+	tests := []struct {
+		name    string
+		setup   func(*Config)
+		openAge time.Duration
+		want    []string
+	}{
+		{
+			name:  "no limits",
+			setup: func(c *Config) {},
+			want: []string{
+				"notes.txt", "old_shellcast_5.txt", video, open,
+				"shellcast_1.txt", "shellcast_1.txt.meta.json",
+				"shellcast_2.txt", "shellcast_2.txt.meta.json",
+				"shellcast_3.txt.gz", "shellcast_3.txt.gz.meta.json",
+				"shellcast_4.mp4",
+			},
+		},
+		{
+			// The open recording and video are two of the three
+			name:  "keep counts the open files",
+			setup: func(c *Config) { c.RecordKeep = 3 },
+			want: []string{
+				"notes.txt", "old_shellcast_5.txt", video, open,
+				"shellcast_1.txt", "shellcast_1.txt.meta.json",
+			},
+		},
+		{
+			name:  "keep fewer than the open files",
+			setup: func(c *Config) { c.RecordKeep = 1 },
+			want:  []string{"notes.txt", "old_shellcast_5.txt", video, open},
+		},
+		{
+			name:  "max age",
+			setup: func(c *Config) { c.RecordMaxAge = Duration(150 * time.Minute) },
+			want: []string{
+				"notes.txt", "old_shellcast_5.txt", video, open,
+				"shellcast_1.txt", "shellcast_1.txt.meta.json",
+				"shellcast_2.txt", "shellcast_2.txt.meta.json",
+			},
+		},
+		{
+			// A recording open for longer than the maximum age stays
+			name:    "max age spares an old open recording",
+			setup:   func(c *Config) { c.RecordMaxAge = Duration(90 * time.Minute) },
+			openAge: 10 * time.Hour,
+			want: []string{
+				"notes.txt", "old_shellcast_5.txt", video, open,
+				"shellcast_1.txt", "shellcast_1.txt.meta.json",
+			},
+		},
+		{
+			name: "keep and max age",
+			setup: func(c *Config) {
+				c.RecordKeep = 4
+				c.RecordMaxAge = Duration(90 * time.Minute)
+			},
+			want: []string{
+				"notes.txt", "old_shellcast_5.txt", video, open,
+				"shellcast_1.txt", "shellcast_1.txt.meta.json",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			now := time.Now()
+			for name, age := range files {
+				if name == open {
+					age = tt.openAge
+				}
+				path := filepath.Join(dir, name)
+				if err := os.WriteFile(path, []byte(name), 0644); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.Chtimes(path, now.Add(-age), now.Add(-age)); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			config := GetDefaultConfig()
+			config.RecordPath = dir
+			tt.setup(&config)
+			s, _, _ := newTestShellCast(t, config, nil)
+			s.pruneRecordings(filepath.Join(dir, open), filepath.Join(dir, video))
+
+			entries, err := os.ReadDir(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, entry := range entries {
+				got = append(got, entry.Name())
+			}
+			want := append([]string(nil), tt.want...)
+			sort.Strings(want)
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("files left = %q, want %q", got, want)
+			}
+		})
+	}
+}
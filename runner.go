@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"io"
+	"os"
+	"os/exec"
+)
+
+// CommandSpec describes a process for a CommandRunner to start: a
+// command, a hook, or FFmpeg rendering a capture
+type CommandSpec struct {
+	Name   string
+	Args   []string
+	Env    []string  // nil for ShellCast's own environment
+	Stdin  *os.File  // nil for no input; a terminal is handed to the process while it runs
+	Stdout io.Writer // nil discards the output
+	Stderr io.Writer
+}
+
+// Process is a process started by a CommandRunner
+type Process interface {
+	// Pid identifies the process in messages and job lists
+	Pid() int
+	// Wait waits until the process exited and its output is written. It
+	// returns an error if the process failed or was killed.
+	Wait() error
+	// ExitCode returns the exit code once Wait returned, -1 if the
+	// process was killed by a signal
+	ExitCode() int
+	// Kill kills the process together with any subprocesses
+	Kill() error
+}
+
+// CommandRunner starts the process described by spec and kills it, with
+// its subprocesses, once ctx is done. An error for a missing program
+// should wrap exec.ErrNotFound or fs.ErrNotExist, as ExecRunner's do.
+type CommandRunner func(ctx context.Context, spec CommandSpec) (Process, error)
+
+// ExecRunner is the CommandRunner ShellCast uses by default. It runs
+// each process in its own process group, so killing it kills any
+// subprocesses too.
+func ExecRunner(ctx context.Context, spec CommandSpec) (Process, error) {
+	cmd := exec.CommandContext(ctx, spec.Name, spec.Args...)
+	cmd.Env = spec.Env
+	if spec.Stdin != nil {
+		cmd.Stdin = spec.Stdin
+	}
+	cmd.Stdout = spec.Stdout
+	cmd.Stderr = spec.Stderr
+	setProcessGroup(cmd)
+	cmd.Cancel = func() error {
+		return killProcessGroup(cmd)
+	}
+
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	return execProcess{cmd}, nil
+}
+
+// execProcess is a Process started by ExecRunner
+type execProcess struct {
+	cmd *exec.Cmd
+}
+
+func (p execProcess) Pid() int {
+	return p.cmd.Process.Pid
+}
+
+// Wait waits for the process and takes back the terminal it had
+func (p execProcess) Wait() error {
+	defer restoreForeground(p.cmd)
+	return p.cmd.Wait()
+}
+
+func (p execProcess) ExitCode() int {
+	return p.cmd.ProcessState.ExitCode()
+}
+
+func (p execProcess) Kill() error {
+	return killProcessGroup(p.cmd)
+}
+
+// SetCommandRunner replaces how commands, hooks and renders are turned
+// into processes, e.g. to run them in a container or to script their
+// output in tests. A nil runner restores ExecRunner. Call it before
+// running commands.
+func (s *ShellCast) SetCommandRunner(runner CommandRunner) {
+	if runner == nil {
+		runner = ExecRunner
+	}
+	s.runner = runner
+}
+
+// startCommand starts the process described by spec through the command
+// runner and registers it so Cleanup can kill it. Wait for it with
+// waitCommand.
+func (s *ShellCast) startCommand(ctx context.Context, spec CommandSpec) (Process, error) {
+	proc, err := s.runner(ctx, spec)
+	if err != nil {
+		return nil, startError(spec.Name, err)
+	}
+
+	s.mutex.Lock()
+	s.children[proc] = struct{}{}
+	s.mutex.Unlock()
+
+	s.logger.Debugf("Started process %d: %s", proc.Pid(), commandLine(spec))
+	return proc, nil
+}
+
+// waitCommand waits for a process from startCommand to exit and removes
+// it from the running set
+func (s *ShellCast) waitCommand(proc Process) error {
+	err := proc.Wait()
+
+	s.mutex.Lock()
+	delete(s.children, proc)
+	s.mutex.Unlock()
+
+	s.logger.Debugf("Process %d finished", proc.Pid())
+	return err
+}
+
+// commandLine returns the program and arguments of spec for messages
+func commandLine(spec CommandSpec) string {
+	line := spec.Name
+	for _, arg := range spec.Args {
+		line += " " + arg
+	}
+	return line
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ScriptStep is one line of a script: a command to run or a pause
+type ScriptStep struct {
+	Line    int
+	Command string
+	Sleep   time.Duration
+}
+
+// LoadScript reads a script file with one command per line. Empty lines
+// and lines starting with # are skipped, and "sleep N" pauses for N
+// seconds (or a duration such as 500ms).
+func LoadScript(path string) ([]ScriptStep, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("error opening script: %v", err)
+	}
+	defer file.Close()
+
+	var steps []ScriptStep
+	scanner := bufio.NewScanner(file)
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		// A bare "sleep" is a mistake, not a command to run
+		if name, arg, _ := strings.Cut(line, " "); name == "sleep" {
+			delay, err := parseSleep(strings.TrimSpace(arg))
+			if err != nil {
+				return nil, fmt.Errorf("%s:%d: %v", path, lineNum, err)
+			}
+			steps = append(steps, ScriptStep{Line: lineNum, Sleep: delay})
+			continue
+		}
+
+		steps = append(steps, ScriptStep{Line: lineNum, Command: line})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading script: %v", err)
+	}
+	return steps, nil
+}
+
+// parseSleep accepts seconds ("2", "0.5") or a Go duration ("500ms")
+func parseSleep(arg string) (time.Duration, error) {
+	if arg == "" {
+		return 0, fmt.Errorf("sleep needs a duration")
+	}
+	if seconds, err := strconv.ParseFloat(arg, 64); err == nil && seconds >= 0 {
+		return time.Duration(seconds * float64(time.Second)), nil
+	}
+	if delay, err := time.ParseDuration(arg); err == nil && delay >= 0 {
+		return delay, nil
+	}
+	return 0, fmt.Errorf("invalid sleep duration '%s'", arg)
+}
+
+// RunScript executes the steps in order. A failing command doesn't stop
+// the script; the returned error lists every failure.
+func (s *ShellCast) RunScript(steps []ScriptStep) error {
+	return s.RunScriptContext(context.Background(), steps)
+}
+
+// RunScriptContext is like RunScript, but stops when ctx is cancelled
+func (s *ShellCast) RunScriptContext(ctx context.Context, steps []ScriptStep) error {
+	var failures []string
+	commands := 0
+
+	for _, step := range steps {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		if step.Command == "" {
+			s.logger.Debugf("Script line %d: sleeping %s", step.Line, step.Sleep)
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(step.Sleep):
+			}
+			continue
+		}
+
+		commands++
+		s.logger.Debugf("Script line %d: %s", step.Line, step.Command)
+		if err := s.ExecuteCommandContext(ctx, step.Command); err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			s.logger.Errorf("Command error on line %d: %v", step.Line, err)
+			failures = append(failures, fmt.Sprintf("line %d (%s): %v", step.Line, step.Command, err))
+		}
+	}
+
+	if len(failures) > 0 {
+		return fmt.Errorf("%d of %d script commands failed: %s",
+			len(failures), commands, strings.Join(failures, "; "))
+	}
+	return nil
+}
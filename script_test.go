@@ -0,0 +1,84 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseSleep(t *testing.T) {
+	tests := []struct {
+		arg     string
+		want    time.Duration
+		wantErr bool
+	}{
+		{"2", 2 * time.Second, false},
+		{"0.5", 500 * time.Millisecond, false},
+		{"500ms", 500 * time.Millisecond, false},
+		{"1m", time.Minute, false},
+		{"0", 0, false},
+		{"", 0, true},
+		{"-1", 0, true},
+		{"-1s", 0, true},
+		{"soon", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := parseSleep(tt.arg)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("parseSleep(%q) = %v, %v; want %v, error %v", tt.arg, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestLoadScript(t *testing.T) {
+	tests := []struct {
+		name    string
+		script  string
+		want    []ScriptStep
+		wantErr string
+	}{
+		{
+			name:   "commands, comments and sleeps",
+			script: "# demo\n\necho one\nsleep 0.5\n  sleep 500ms  \necho two\n",
+			want: []ScriptStep{
+				{Line: 3, Command: "echo one"},
+				{Line: 4, Sleep: 500 * time.Millisecond},
+				{Line: 5, Sleep: 500 * time.Millisecond},
+				{Line: 6, Command: "echo two"},
+			},
+		},
+		{
+			name:   "sleepy is a command",
+			script: "sleepy 2\n",
+			want:   []ScriptStep{{Line: 1, Command: "sleepy 2"}},
+		},
+		{name: "sleep without a duration", script: "echo one\nsleep\n", wantErr: ":2: sleep needs a duration"},
+		{name: "negative sleep", script: "sleep -1\n", wantErr: ":1: invalid sleep duration '-1'"},
+		{name: "unparsable sleep", script: "# wait\nsleep a while\n", wantErr: ":2: invalid sleep duration 'a while'"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "demo.txt")
+			if err := os.WriteFile(path, []byte(tt.script), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			steps, err := LoadScript(path)
+			if tt.wantErr != "" {
+				if err == nil || !strings.HasSuffix(err.Error(), path+tt.wantErr) {
+					t.Fatalf("LoadScript = %v, want error %q", err, path+tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(steps, tt.want) {
+				t.Errorf("LoadScript = %+v, want %+v", steps, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// selfTestLines is the number of synthetic output lines the self-test
+// pushes through the output pipeline
+const selfTestLines = 10000
+
+// SelfTestCheck is the result of one self-test step
+type SelfTestCheck struct {
+	Name  string `json:"name"`
+	OK    bool   `json:"ok"`
+	Error string `json:"error,omitempty"`
+}
+
+// SelfTestReport summarizes a self-test run
+type SelfTestReport struct {
+	OK             bool            `json:"ok"`
+	Lines          int             `json:"lines"`
+	DurationMillis int64           `json:"duration_ms"`
+	LinesPerSecond float64         `json:"lines_per_second"`
+	Checks         []SelfTestCheck `json:"checks"`
+}
+
+// RunSelfTest pushes known output through the buffer, a dry-run stream
+// and a recording in a temporary directory, and reports the throughput
+// and any errors. No FFmpeg or RTMP server is needed.
+func RunSelfTest(config Config) SelfTestReport {
+	report := SelfTestReport{OK: true, Lines: selfTestLines}
+	check := func(name string, err error) {
+		result := SelfTestCheck{Name: name, OK: err == nil}
+		if err != nil {
+			result.Error = err.Error()
+			report.OK = false
+		}
+		report.Checks = append(report.Checks, result)
+	}
+
+	dir, err := os.MkdirTemp("", "shellcast_selftest_*")
+	if err != nil {
+		check("setup", fmt.Errorf("error creating temp directory: %v", err))
+		return report
+	}
+	defer os.RemoveAll(dir)
+
+	// Plain text lines with nothing added, so they can be compared
+	config.RTMPUrl = "rtmp://localhost/selftest"
+	config.DryRun = true
+	config.RecordFile = filepath.Join(dir, "selftest.txt")
+	config.RecordAppend = false
+	config.OutputFormat = "text"
+	config.ShowTimestamp = false
+	config.LogLevel = "quiet"
+
+	sc := NewShellCastWithOutput(config, io.Discard, io.Discard)
+
+	// Streaming in dry-run mode only builds the FFmpeg command
+	commandLine := sc.FFmpegCommandLine()
+	if !strings.Contains(commandLine, config.RTMPUrl) {
+		check("stream", fmt.Errorf("FFmpeg command does not contain the RTMP URL: %s", commandLine))
+	} else {
+		check("stream", sc.StartStreaming())
+	}
+
+	check("record start", sc.StartRecording())
+
+	// Feed the synthetic output through the same path as command output
+	reader, writer := io.Pipe()
+	go func() {
+		for i := 1; i <= selfTestLines; i++ {
+			fmt.Fprintf(writer, "selftest line %d\n", i)
+		}
+		writer.Close()
+	}()
+
+	start := time.Now()
+	sc.readOutput(reader, &outputReader{stream: "stdout", w: sc.Stdout}, nil)
+	elapsed := time.Since(start)
+
+	report.DurationMillis = elapsed.Milliseconds()
+	if elapsed > 0 {
+		report.LinesPerSecond = float64(selfTestLines) / elapsed.Seconds()
+	}
+
+	check("buffer", verifySelfTestOutput(sc.Snapshot()))
+
+	if err := sc.StopRecording(); err != nil {
+		check("record stop", err)
+	} else {
+		data, err := os.ReadFile(config.RecordFile)
+		if err != nil {
+			check("recording", fmt.Errorf("error reading recording: %v", err))
+		} else {
+			check("recording", verifySelfTestOutput(string(data)))
+		}
+	}
+
+	sc.Cleanup()
+	return report
+}
+
+// verifySelfTestOutput checks that text contains every synthetic line
+// exactly once and in order
+func verifySelfTestOutput(text string) error {
+	next := 1
+	for _, line := range strings.Split(text, "\n") {
+		if !strings.HasPrefix(line, "selftest line ") {
+			continue
+		}
+		if line != fmt.Sprintf("selftest line %d", next) {
+			return fmt.Errorf("expected line %d, got %q", next, line)
+		}
+		next++
+	}
+	if next-1 != selfTestLines {
+		return fmt.Errorf("expected %d lines, got %d", selfTestLines, next-1)
+	}
+	return nil
+}
+
+// PrintSelfTestReport writes the report as text, or as JSON for the json
+// output format
+func PrintSelfTestReport(w io.Writer, report SelfTestReport, format string) {
+	if format == "json" {
+		data, _ := json.MarshalIndent(report, "", "  ")
+		fmt.Fprintln(w, string(data))
+		return
+	}
+
+	for _, check := range report.Checks {
+		if check.OK {
+			fmt.Fprintf(w, "%-13s ok\n", check.Name)
+		} else {
+			fmt.Fprintf(w, "%-13s FAILED: %s\n", check.Name, check.Error)
+		}
+	}
+	fmt.Fprintf(w, "Throughput:   %d lines in %dms (%.0f lines/sec)\n",
+		report.Lines, report.DurationMillis, report.LinesPerSecond)
+	if report.OK {
+		fmt.Fprintln(w, "Self-test passed")
+	} else {
+		fmt.Fprintln(w, "Self-test failed")
+	}
+}
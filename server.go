@@ -0,0 +1,271 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// websocketGUID is the fixed key suffix defined by RFC 6455
+const websocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// viewerPage renders the live output with xterm.js
+const viewerPage = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>ShellCast</title>
+<link rel="stylesheet" href="https://cdn.jsdelivr.net/npm/xterm@5.3.0/css/xterm.css">
+<script src="https://cdn.jsdelivr.net/npm/xterm@5.3.0/lib/xterm.js"></script>
+<style>html, body { margin: 0; height: 100%%; background: %s; }</style>
+</head>
+<body>
+<div id="terminal"></div>
+<script>
+const term = new Terminal({
+  convertEol: true,
+  fontSize: %d,
+  theme: { foreground: "%s", background: "%s" }
+});
+term.open(document.getElementById("terminal"));
+const proto = location.protocol === "https:" ? "wss://" : "ws://";
+const ws = new WebSocket(proto + location.host + "/ws");
+ws.onmessage = (e) => term.write(e.data);
+ws.onclose = () => term.writeln("\r\n[connection closed]");
+</script>
+</body>
+</html>
+`
+
+// StartServer serves the live output over HTTP. Browsers can open the
+// root page, and WebSocket clients can connect to /ws directly.
+func (s *ShellCast) StartServer(addr string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.server != nil {
+		return fmt.Errorf("already serving")
+	}
+
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("error listening on %s: %v", addr, err)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", s.handleViewer)
+	mux.HandleFunc("/ws", s.handleWebSocket)
+
+	server := &http.Server{Handler: mux}
+	s.server = server
+	go func() {
+		if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			s.logger.Errorf("Server error: %v", err)
+		}
+	}()
+
+	s.logger.Infof("Serving live output on http://%s", listener.Addr())
+	return nil
+}
+
+// StopServer shuts down the HTTP server and disconnects all clients
+func (s *ShellCast) StopServer() error {
+	s.mutex.Lock()
+	server := s.server
+	s.server = nil
+	s.mutex.Unlock()
+
+	if server == nil {
+		return fmt.Errorf("not serving")
+	}
+	return server.Close()
+}
+
+// isServing reports whether the HTTP server is running
+func (s *ShellCast) isServing() bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.server != nil
+}
+
+// handleViewer serves the browser terminal page
+func (s *ShellCast) handleViewer(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+
+	config := s.cfg()
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	fmt.Fprintf(w, viewerPage,
+		config.BackgroundColor,
+		config.FontSize,
+		config.FontColor,
+		config.BackgroundColor)
+}
+
+// sameOrigin reports whether r comes from a page served by this server,
+// or from a client that sends no Origin, like command-line tools.
+// Browsers send the Origin of the page opening a WebSocket, and without
+// this check any website a viewer visits could read the session.
+func sameOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	return strings.EqualFold(u.Host, r.Host)
+}
+
+// handleWebSocket upgrades the connection and sends the buffered backlog
+// followed by all new output as text messages
+func (s *ShellCast) handleWebSocket(w http.ResponseWriter, r *http.Request) {
+	conn, rw, err := upgradeWebSocket(w, r)
+	if err != nil {
+		s.logger.Debugf("Refused viewer from %s: %v", r.RemoteAddr, err)
+		return
+	}
+	defer conn.Close()
+
+	backlog, output := s.subscribe()
+	defer s.unsubscribe(output)
+
+	s.logger.Debugf("Viewer connected from %s", r.RemoteAddr)
+	defer s.logger.Debugf("Viewer disconnected from %s", r.RemoteAddr)
+
+	closed := watchClose(rw)
+
+	if backlog != "" {
+		if err := writeTextFrame(rw.Writer, backlog); err != nil {
+			return
+		}
+	}
+
+	for {
+		select {
+		case text := <-output:
+			if err := writeTextFrame(rw.Writer, text); err != nil {
+				return
+			}
+		case <-closed:
+			return
+		}
+	}
+}
+
+// upgradeWebSocket performs the server side of the WebSocket handshake
+// and returns the hijacked connection. Requests from other origins are
+// refused, see sameOrigin.
+func upgradeWebSocket(w http.ResponseWriter, r *http.Request) (net.Conn, *bufio.ReadWriter, error) {
+	if !sameOrigin(r) {
+		http.Error(w, "cross-origin websocket refused", http.StatusForbidden)
+		return nil, nil, fmt.Errorf("origin %s is not this server", r.Header.Get("Origin"))
+	}
+
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if !strings.EqualFold(r.Header.Get("Upgrade"), "websocket") || key == "" {
+		http.Error(w, "expected websocket upgrade", http.StatusBadRequest)
+		return nil, nil, fmt.Errorf("not a websocket request")
+	}
+
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "websocket not supported", http.StatusInternalServerError)
+		return nil, nil, fmt.Errorf("connection cannot be hijacked")
+	}
+
+	conn, rw, err := hijacker.Hijack()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	sum := sha1.Sum([]byte(key + websocketGUID))
+	rw.WriteString("HTTP/1.1 101 Switching Protocols\r\n")
+	rw.WriteString("Upgrade: websocket\r\n")
+	rw.WriteString("Connection: Upgrade\r\n")
+	rw.WriteString("Sec-WebSocket-Accept: " + base64.StdEncoding.EncodeToString(sum[:]) + "\r\n\r\n")
+	if err := rw.Flush(); err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+
+	return conn, rw, nil
+}
+
+// watchClose returns a channel that is closed when the client goes away.
+// Client messages are ignored; reading only detects disconnects.
+func watchClose(rw *bufio.ReadWriter) <-chan struct{} {
+	closed := make(chan struct{})
+	go func() {
+		io.Copy(io.Discard, rw)
+		close(closed)
+	}()
+	return closed
+}
+
+// subscribe returns the current buffer and registers a channel receiving
+// all subsequent output. Both happen under the mutex so no line is
+// missed or duplicated between the backlog and the live feed.
+func (s *ShellCast) subscribe() (string, chan string) {
+	ch := make(chan string, 256)
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.subscribers[ch] = struct{}{}
+	return s.outputBuffer.String(), ch
+}
+
+// unsubscribe stops delivering output to ch
+func (s *ShellCast) unsubscribe(ch chan string) {
+	s.mutex.Lock()
+	delete(s.subscribers, ch)
+	s.mutex.Unlock()
+}
+
+// broadcast hands output to connected clients as it is to be written to
+// their terminal: complete lines end with a newline, and unfinished lines
+// are redrawn in place. Slow clients drop output rather than stalling the
+// command. The caller must hold s.mutex.
+func (s *ShellCast) broadcast(text string) {
+	for ch := range s.subscribers {
+		select {
+		case ch <- text:
+		default:
+		}
+	}
+}
+
+// writeTextFrame writes a single unmasked WebSocket text frame
+func writeTextFrame(w *bufio.Writer, text string) error {
+	payload := []byte(text)
+
+	header := []byte{0x81} // FIN + text opcode
+	switch {
+	case len(payload) < 126:
+		header = append(header, byte(len(payload)))
+	case len(payload) <= 0xFFFF:
+		header = append(header, 126, 0, 0)
+		binary.BigEndian.PutUint16(header[2:], uint16(len(payload)))
+	default:
+		header = append(header, 127, 0, 0, 0, 0, 0, 0, 0, 0)
+		binary.BigEndian.PutUint64(header[2:], uint64(len(payload)))
+	}
+
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+	if _, err := w.Write(payload); err != nil {
+		return err
+	}
+	return w.Flush()
+}
@@ -2,460 +2,2133 @@ package main
 
 import (
 	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"regexp"
+	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
+	"unicode"
 )
 
 // ShellCast is the main application structure
 type ShellCast struct {
-	config       Config
-	outputBuffer string
+	config       atomic.Pointer[Config] // see cfg and updateConfig
+	configMutex  sync.Mutex             // serializes updateConfig
+	outputBuffer bytes.Buffer
 	mutex        sync.Mutex
-	streaming    bool
-	streamProc   *os.Process
-	recording    bool
-	recordPath   string
+	streaming    bool            // guarded by mutex
+	renderer     Renderer        // guarded by mutex
+	streamOut    *streamFile     // textfile rendered by FFmpeg, guarded by mutex
+	streamDone   chan struct{}   // closed when the stream stops, guarded by mutex
+	streamCtx    context.Context // guarded by mutex
+	videoPath    string          // MP4 file written by FFmpeg when RecordVideo is set, guarded by mutex
+	recording    bool            // guarded by mutex
+	recordPath   string          // guarded by mutex
+	recordOut    *os.File      // open recording, guarded by mutex
+	recordGzip   *gzip.Writer  // compresses for recordOut, nil for plain recordings
+	recordWriter *bufio.Writer // buffers writes to recordOut or recordGzip
+	recordDone   chan struct{} // closed when recording stops
+	recordStart  time.Time         // when the open recording started, guarded by mutex
+	recordCommands []ManifestCommand // finished during the open recording, guarded by mutex
+	cue          *subtitleCue  // subtitle cue being collected, guarded by mutex
+	cueCount     int           // subtitle cues written, guarded by mutex
+	streamPaused bool          // guarded by mutex
+	recordPaused bool          // guarded by mutex
 	startTime    time.Time
+	children     map[Process]struct{}
+	splitJobs    []*splitJob // commands of the running split session, guarded by mutex
+	server       *http.Server
+	subscribers  map[chan string]struct{} // receive terminal output, see broadcast
+	logger       *Logger
+	events       EventHandler
+	runner       CommandRunner
+	highlights   []*regexp.Regexp // compiled config.Highlight, guarded by mutex
+	include      *regexp.Regexp   // compiled config.IncludeRegex, guarded by mutex
+	exclude      *regexp.Regexp   // compiled config.ExcludeRegex, guarded by mutex
+	redactions   []*regexp.Regexp // compiled config.RedactPatterns(), guarded by mutex
+
+	// Stream rate tracking for MaxLinesPerSecond, guarded by mutex
+	rateWindow   time.Time
+	rateLines    int
+	rateDropped  int
+	rateWarned   bool
+	droppedLines int
+
+	// Unfinished line at the end of the buffer and stream file, see
+	// showPartial. Guarded by mutex.
+	openLine      *outputReader
+	openBufLen    int
+	openStreamLen int
+
+	// FFmpeg's latest progress report, guarded by mutex
+	streamStats StreamStats
+	adaptiveStep int // quality reductions by adaptive mode, guarded by mutex
+
+	// Font file FFmpeg couldn't load, "" for FFmpeg's default font, which
+	// the embedded font written to embeddedFontPath replaces while the
+	// font setting stays the same. Guarded by mutex.
+	failedFont       string
+	fontFailed       bool
+	embeddedFontPath string
+
+	// FFmpeg's encoder list and the executable it came from, see
+	// ffmpegEncoders. FFmpeg command lines are built with and without
+	// mutex held, so encoderMutex guards them.
+	encoderMutex  sync.Mutex
+	encoderFFmpeg string
+	encoderList   string
+
+	// Font file picked from the FontFile list and the setting it was
+	// picked from, to report changes. Guarded by mutex.
+	chosenFont        string
+	chosenFontSetting string
+
+	// Idle indicator at the end of the stream file, see keepalive.
+	// Guarded by mutex.
+	streamActivity time.Time
+	keepaliveLen   int
+
+	// Output kept with its timing for RenderCapture, guarded by mutex
+	capturing    bool
+	captured     []capturedLine
+	captureStart time.Time
+
+	// Line numbers shared by all commands with a "session" scope
+	sessionLines lineCounter
+	timestamps   timestampClock
+
+	// Stdout and Stderr receive the output of executed commands, and
+	// Stderr also ShellCast's own messages and FFmpeg's output
+	Stdout io.Writer
+	Stderr io.Writer
 }
 
+// NewShellCast creates a ShellCast writing to the standard streams
 func NewShellCast(config Config) *ShellCast {
-	return &ShellCast{
-		config:     config,
-		streaming:  false,
-		recording:  false,
-		streamProc: nil,
-		startTime:  time.Now(),
+	return NewShellCastWithOutput(config, os.Stdout, os.Stderr)
+}
+
+// NewShellCastWithOutput creates a ShellCast writing command output and
+// messages to the given writers, e.g. buffers when embedding or testing
+func NewShellCastWithOutput(config Config, stdout, stderr io.Writer) *ShellCast {
+	level, _ := ParseLogLevel(config.LogLevel)
+
+	s := &ShellCast{
+		streaming:   false,
+		recording:   false,
+		startTime:   time.Now(),
+		children:    make(map[Process]struct{}),
+		subscribers: make(map[chan string]struct{}),
+		logger:      NewLogger(level, stderr),
+		events:      NopEventHandler{},
+		runner:      ExecRunner,
+		Stdout:      stdout,
+		Stderr:      stderr,
 	}
+	s.config.Store(&config)
+	s.compileConfigPatterns()
+	return s
+}
+
+// UseConfig replaces the configuration, e.g. after loading a config file
+func (s *ShellCast) UseConfig(config Config) {
+	s.updateConfig(func(c *Config) { *c = config })
+	s.compileConfigPatterns()
 }
 
+// cfg returns the current configuration, which may be read from any
+// goroutine. It is never changed in place, so it must not be modified;
+// changes go through updateConfig.
+func (s *ShellCast) cfg() *Config {
+	return s.config.Load()
+}
+
+// updateConfig changes a copy of the configuration with change and makes
+// it the current one. Slices and maps of the copy are shared with the
+// old configuration, so change replaces them instead of modifying them.
+// Changes are serialized by configMutex; the caller may hold mutex.
+func (s *ShellCast) updateConfig(change func(*Config)) {
+	s.configMutex.Lock()
+	defer s.configMutex.Unlock()
+
+	config := *s.config.Load()
+	change(&config)
+	s.config.Store(&config)
+}
+
+// ReloadConfig replaces the configuration of a running session, keeping
+// the stream key and the text file of an active stream. New settings
+// apply to the next output line, but the video keeps the ones FFmpeg was
+// started with; restartNeeded reports whether any of those changed.
+func (s *ShellCast) ReloadConfig(config Config) (restartNeeded bool) {
+	streaming := s.isStreaming()
+	var before string
+	if streaming {
+		before = s.FFmpegCommandLine()
+	}
+
+	s.mutex.Lock()
+	s.updateConfig(func(c *Config) {
+		if config.StreamKey == "" {
+			config.StreamKey = c.StreamKey
+		}
+		if s.streamOut != nil {
+			config.OutputFile = c.OutputFile
+		}
+		*c = config
+	})
+	s.mutex.Unlock()
+	s.compileConfigPatterns()
+
+	return streaming && s.FFmpegCommandLine() != before
+}
+
+// ExecuteCommand runs a command and blocks until it finishes
 func (s *ShellCast) ExecuteCommand(command string) error {
-	parts := strings.Split(command, " ")
+	return s.ExecuteCommandContext(context.Background(), command)
+}
+
+// ExecuteCommandContext is like ExecuteCommand, but kills the command
+// when ctx is cancelled
+func (s *ShellCast) ExecuteCommandContext(ctx context.Context, command string) error {
+	s.runHook(ctx, "pre-command", s.cfg().PreCommandHook, command)
+	defer s.runHook(ctx, "post-command", s.cfg().PostCommandHook, command)
+
+	if s.cfg().TypingEffect {
+		s.typeCommand(ctx, command)
+	}
+
+	lines, errc := s.ExecuteCommandStreamContext(ctx, command)
+	for range lines {
+		// Output is already echoed, buffered and recorded by the pipeline
+	}
+	return <-errc
+}
+
+// runHook runs a command hook through the system shell, with the command
+// it belongs to in $SHELLCAST_COMMAND. A failing hook is only reported so
+// it never stops the command itself.
+func (s *ShellCast) runHook(ctx context.Context, name, hook, command string) {
+	if hook == "" {
+		return
+	}
+
+	config := s.cfg()
+	shell, args := config.shellCommand(hook)
+	spec := CommandSpec{
+		Name:  shell,
+		Args:  args,
+		Env:   append(append(os.Environ(), config.terminalEnv()...), "SHELLCAST_COMMAND="+command),
+		Stdin: os.Stdin,
+	}
+
+	var err error
+	if s.cfg().SuppressHookOutput {
+		// Only shown locally, never buffered, streamed or recorded
+		spec.Stdout = s.Stdout
+		spec.Stderr = s.Stderr
+		var proc Process
+		proc, err = s.startCommand(ctx, spec)
+		if err == nil {
+			err = s.waitCommand(proc)
+		}
+	} else {
+		_, err = s.runPiped(ctx, spec, command, "", "", nil)
+	}
+
+	if err != nil {
+		s.logger.Errorf("Warning: %s hook failed: %v", name, err)
+	}
+}
+
+// CommandTimeoutError is returned when a command was killed after
+// running for CommandTimeout
+type CommandTimeoutError struct {
+	Timeout time.Duration
+}
+
+func (e *CommandTimeoutError) Error() string {
+	return fmt.Sprintf("command timed out after %s", e.Timeout)
+}
+
+// CommandNotFoundError is returned when the program of a command doesn't
+// exist
+type CommandNotFoundError struct {
+	Name string
+}
+
+func (e *CommandNotFoundError) Error() string {
+	if strings.ContainsAny(e.Name, `/\`) {
+		return fmt.Sprintf("command not found: %s (no such file)", e.Name)
+	}
+	return fmt.Sprintf("command not found: %s (is it installed and in your PATH?)", e.Name)
+}
+
+// startError describes why the program name failed to start,
+// recognizing a missing program
+func startError(name string, err error) error {
+	if errors.Is(err, exec.ErrNotFound) || errors.Is(err, fs.ErrNotExist) {
+		return &CommandNotFoundError{Name: name}
+	}
+	return fmt.Errorf("error starting command: %v", err)
+}
+
+// runPiped starts the process in spec, run for command, and passes its
+// output through the output pipeline, labelled with source, until it
+// exits. A color tints the output on the terminal. started, if not nil,
+// receives the process once it runs. The process is returned if it
+// started, with the error it exited with.
+func (s *ShellCast) runPiped(ctx context.Context, spec CommandSpec, command, source, color string, started func(Process)) (Process, error) {
+	stdout, stdoutW := io.Pipe()
+	stderr, stderrW := io.Pipe()
+	spec.Stdout, spec.Stderr = stdoutW, stderrW
+
+	proc, err := s.startCommand(ctx, spec)
+	if err != nil {
+		return nil, err
+	}
+	if started != nil {
+		started(proc)
+	}
+
+	// JSON output is left alone so it stays parseable, and piped output
+	// free of escape sequences
+	var outW, errW io.Writer = s.Stdout, s.Stderr
+	if escape := ansiColor(color); escape != "" && s.cfg().OutputFormat != "json" {
+		if s.useColor(outW) {
+			outW = &colorWriter{w: outW, color: escape}
+		}
+		if s.useColor(errW) {
+			errW = &colorWriter{w: errW, color: escape}
+		}
+	}
+
+	name, pid := commandName(command), proc.Pid()
+	counter := s.newLineCounter()
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		s.readOutput(stdout, &outputReader{stream: "stdout", source: source, command: name, pid: pid, lines: counter, w: outW}, nil)
+	}()
+	go func() {
+		defer wg.Done()
+		s.readOutput(stderr, &outputReader{stream: "stderr", source: source, command: name, pid: pid, lines: counter, w: errW}, nil)
+	}()
+
+	err = s.waitCommand(proc)
+	stdoutW.Close()
+	stderrW.Close()
+	wg.Wait()
+	s.flushStreamFile()
+	return proc, err
+}
+
+// ExecuteCommandStream starts a command and returns immediately. Each
+// formatted output line is delivered on the first channel, which is closed
+// once the command's output ends; the command's result is then sent on the
+// second channel. Callers must drain the line channel.
+func (s *ShellCast) ExecuteCommandStream(command string) (<-chan string, <-chan error) {
+	return s.ExecuteCommandStreamContext(context.Background(), command)
+}
+
+// ExecuteCommandStreamContext is like ExecuteCommandStream, but kills the
+// command when parent is cancelled
+func (s *ShellCast) ExecuteCommandStreamContext(parent context.Context, command string) (<-chan string, <-chan error) {
+	lines := make(chan string, 100)
+	errc := make(chan error, 1)
+
+	fail := func(err error) (<-chan string, <-chan error) {
+		close(lines)
+		errc <- err
+		close(errc)
+		return lines, errc
+	}
+
+	config := s.cfg()
+	parts, err := config.commandArgs(command)
+	if err != nil {
+		return fail(err)
+	}
 	if len(parts) == 0 {
-		return fmt.Errorf("empty command")
+		return fail(fmt.Errorf("empty command"))
+	}
+
+	ctx, cancel := config.commandContext(parent)
+
+	stdout, stdoutW := io.Pipe()
+	stderr, stderrW := io.Pipe()
+	proc, err := s.startCommand(ctx, CommandSpec{
+		Name:   parts[0],
+		Args:   parts[1:],
+		Env:    append(os.Environ(), config.terminalEnv()...),
+		Stdin:  os.Stdin,
+		Stdout: stdoutW,
+		Stderr: stderrW,
+	})
+	if err != nil {
+		cancel()
+		return fail(err)
+	}
+
+	go func() {
+		defer cancel()
+
+		// Handle output in goroutines
+		name, pid := commandName(command), proc.Pid()
+		counter := s.newLineCounter()
+		var wg sync.WaitGroup
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			s.readOutput(stdout, &outputReader{stream: "stdout", command: name, pid: pid, lines: counter, w: s.Stdout}, lines)
+		}()
+		go func() {
+			defer wg.Done()
+			s.readOutput(stderr, &outputReader{stream: "stderr", command: name, pid: pid, lines: counter, w: s.Stderr}, lines)
+		}()
+
+		// Wait for command to finish
+		err := s.waitCommand(proc)
+		stdoutW.Close()
+		stderrW.Close()
+		wg.Wait()
+		s.flushStreamFile()
+		close(lines)
+
+		s.commandExited(command, proc.ExitCode())
+		if parent.Err() != nil {
+			err = parent.Err()
+		} else if ctx.Err() == context.DeadlineExceeded {
+			err = &CommandTimeoutError{Timeout: time.Duration(config.CommandTimeout)}
+		}
+		errc <- err
+		close(errc)
+	}()
+
+	return lines, errc
+}
+
+// readOutput reads lines of reader's stream from r, echoes them to its
+// writer and passes them through the output pipeline. Formatted lines are
+// also sent to lines if not nil.
+//
+// A line without a newline yet, such as a prompt or a progress bar, is
+// shown after partialLineDelay, or right away when a carriage return
+// redraws it, and replaced once it is complete.
+func (s *ShellCast) readOutput(r io.Reader, reader *outputReader, lines chan<- string) {
+	chunks := readChunks(r)
+
+	var pending []byte
+	var flush <-chan time.Time
+	discarding := false // rest of a truncated line
+	for {
+		select {
+		case chunk, ok := <-chunks:
+			if !ok {
+				if len(pending) > 0 || s.ownsOpenLine(reader) {
+					s.completeLine(reader, string(pending), lines)
+				}
+				return
+			}
+
+			pending = append(pending, chunk...)
+			for {
+				i := bytes.IndexByte(pending, '\n')
+				if i < 0 {
+					break
+				}
+				line := pending[:i]
+				pending = pending[i+1:]
+				if discarding {
+					discarding = false
+					continue
+				}
+				s.completeLine(reader, currentLine(s.truncateLine(line)), lines)
+			}
+
+			// Don't let a line without newlines grow without bound
+			if discarding {
+				pending = nil
+			} else if len(pending) > s.cfg().MaxLineBytes {
+				s.completeLine(reader, currentLine(s.truncateLine(pending)), lines)
+				pending = nil
+				discarding = true
+			}
+
+			flush = nil
+			if bytes.IndexByte(pending, '\r') >= 0 {
+				s.showPartial(reader, string(pending))
+			} else if len(pending) > 0 {
+				flush = time.After(partialLineDelay)
+			}
+
+		case <-flush:
+			flush = nil
+			s.showPartial(reader, string(pending))
+		}
+	}
+}
+
+// completeLine passes a finished line through the output pipeline,
+// replacing the reader's unfinished line if it is shown
+func (s *ShellCast) completeLine(reader *outputReader, text string, lines chan<- string) {
+	formattedLine := s.formatOutput(reader, text)
+	reader.number = 0
+	reader.stamp = stampUndecided
+
+	// Filtered lines are only shown locally
+	if !s.passesFilters(text) {
+		s.mutex.Lock()
+		s.replaceOpenLine(reader)
+		fmt.Fprintln(reader.w, formattedLine)
+		s.mutex.Unlock()
+		return
+	}
+
+	// Raw recordings and event handlers get the line without formatting,
+	// but redacted
+	redacted := s.redact(text)
+	raw := ""
+	if s.cfg().RawRecording {
+		raw = redacted
+	}
+	s.writeLine(reader, reader.w, raw, formattedLine)
+	s.events.OnLine(reader.stream, redacted)
+
+	if lines != nil {
+		lines <- formattedLine
+	}
+}
+
+// writeOutput echoes a formatted line to w, stores it in the buffer and
+// appends it to the streaming and recording files when they are active.
+// Everything happens under the mutex so the files see the same line order
+// as the buffer, StartStreaming's initial dump can't overlap with an
+// append, and w is never written to concurrently.
+func (s *ShellCast) writeOutput(w io.Writer, formattedLine string) {
+	s.writeLine(nil, w, "", formattedLine)
+}
+
+// writeLine is writeOutput for a line completing the unfinished line of
+// reader, which is replaced. Any other unfinished line is kept as it is.
+// text is the redacted line before formatting, for raw recordings.
+func (s *ShellCast) writeLine(reader *outputReader, w io.Writer, text, formattedLine string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.replaceOpenLine(reader)
+	fmt.Fprintln(w, formattedLine)
+
+	// If recording, save to record file. Raw recordings only hold
+	// command output, not typed commands.
+	if !s.cfg().RawRecording {
+		s.recordLine(formattedLine)
+	} else if reader != nil && reader.stream != "command" {
+		s.recordLine(text)
+	}
+
+	// While the stream is paused, output is kept out of the buffer too,
+	// so it can't reach viewers later through the backlog
+	if s.streamPaused {
+		return
+	}
+
+	// Store in buffer and hand to connected clients
+	s.outputBuffer.WriteString(formattedLine + "\n")
+	s.broadcast(formattedLine + "\n")
+	s.captureLine(formattedLine)
+
+	// If streaming, append to output file. It is written unbuffered so
+	// FFmpeg sees each line right away.
+	if s.streamOut != nil && s.streamRateAllows(time.Now()) {
+		if _, err := s.streamOut.WriteString(formattedLine + "\n"); err != nil {
+			s.logger.Errorf("Error writing output: %v", err)
+		}
+		if s.renderer != nil {
+			if err := s.renderer.WriteLine(formattedLine); err != nil {
+				s.logger.Errorf("Error rendering output: %v", err)
+			}
+		}
+	}
+}
+
+// Snapshot returns a copy of the buffered output
+func (s *ShellCast) Snapshot() string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.outputBuffer.String()
+}
+
+// Lines returns the buffered output split into lines
+func (s *ShellCast) Lines() []string {
+	snapshot := strings.TrimSuffix(s.Snapshot(), "\n")
+	if snapshot == "" {
+		return nil
+	}
+	return strings.Split(snapshot, "\n")
+}
+
+// OutputMatch is a buffered output line found by SearchOutput
+type OutputMatch struct {
+	Line int // 1-based line number in the buffer
+	Text string
+}
+
+// SearchOutput returns the buffered output lines matching the regular
+// expression pattern, at most max of them (0 = all), and how many lines
+// matched in total
+func (s *ShellCast) SearchOutput(pattern string, ignoreCase bool, max int) ([]OutputMatch, int, error) {
+	if ignoreCase {
+		pattern = "(?i)" + pattern
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, 0, fmt.Errorf("invalid pattern: %v", err)
+	}
+
+	var matches []OutputMatch
+	total := 0
+	for i, line := range s.Lines() {
+		if !re.MatchString(line) {
+			continue
+		}
+		total++
+		if max <= 0 || len(matches) < max {
+			matches = append(matches, OutputMatch{Line: i + 1, Text: line})
+		}
+	}
+	return matches, total, nil
+}
+
+// ClearBuffer discards the buffered output. An active stream is cleared
+// as well so viewers see the same clean slate.
+func (s *ShellCast) ClearBuffer() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.outputBuffer.Reset()
+	s.openBufLen = 0
+	s.openStreamLen = 0
+	s.keepaliveLen = 0
+	if s.streamOut != nil {
+		if err := s.streamOut.Truncate(0); err != nil {
+			return fmt.Errorf("error clearing output file: %v", err)
+		}
+	}
+	return nil
+}
+
+// commandContext returns the context used to run a single command,
+// derived from parent and bounded by CommandTimeout when one is configured
+func (c *Config) commandContext(parent context.Context) (context.Context, context.CancelFunc) {
+	if c.CommandTimeout > 0 {
+		return context.WithTimeout(parent, time.Duration(c.CommandTimeout))
+	}
+	return context.WithCancel(parent)
+}
+
+// terminalEnv returns COLUMNS and LINES for the text area of the video,
+// so programs that format their output for the terminal size fit it to
+// the screen instead of assuming 80x24
+func (c *Config) terminalEnv() []string {
+	return []string{
+		fmt.Sprintf("COLUMNS=%d", c.visibleColumns()),
+		fmt.Sprintf("LINES=%d", c.visibleLines()),
+	}
+}
+
+// killCommands kills the process groups of all running commands
+func (s *ShellCast) killCommands() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for proc := range s.children {
+		if err := proc.Kill(); err != nil {
+			s.logger.Errorf("Error killing process %d: %v", proc.Pid(), err)
+		}
+	}
+}
+
+// SessionStatus is a snapshot of the session state
+type SessionStatus struct {
+	Streaming     bool
+	StreamPaused  bool
+	RTMPUrl       string
+	Recording     bool
+	RecordPaused  bool
+	RecordPath    string
+	Serving       bool
+	ServeAddr     string
+	Elapsed       time.Duration
+	BufferLines   int
+	DroppedLines  int // lines left out of the stream by MaxLinesPerSecond
+	Stream        StreamStats // FFmpeg's latest progress, zero until the first report
+	RunningCount  int
+	ThemeName     string
+	ScreenWidth   int
+	ScreenHeight  int
+	FontSize      int
+	ShowTimestamp bool
+}
+
+// Status returns the current session state, read under the mutex
+func (s *ShellCast) Status() SessionStatus {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return SessionStatus{
+		Streaming:     s.streaming,
+		StreamPaused:  s.streamPaused,
+		RTMPUrl:       s.rtmpTarget(),
+		Recording:     s.recording,
+		RecordPaused:  s.recordPaused,
+		RecordPath:    s.recordPath,
+		Serving:       s.server != nil,
+		ServeAddr:     s.cfg().ServeAddr,
+		Elapsed:       time.Since(s.startTime),
+		BufferLines:   strings.Count(s.outputBuffer.String(), "\n"),
+		DroppedLines:  s.droppedLines,
+		Stream:        s.streamStats,
+		RunningCount:  len(s.children),
+		ThemeName:     s.cfg().ThemeName,
+		ScreenWidth:   s.cfg().ScreenWidth,
+		ScreenHeight:  s.cfg().ScreenHeight,
+		FontSize:      s.cfg().FontSize,
+		ShowTimestamp: s.cfg().ShowTimestamp,
+	}
+}
+
+// outputLine is a line of output in the json output format
+type outputLine struct {
+	Time      string `json:"ts"`
+	Stream    string `json:"stream"`
+	Source    string `json:"source,omitempty"`
+	Line      string `json:"line"`
+	Highlight bool   `json:"highlight,omitempty"`
+	Number    int    `json:"line_number,omitempty"`
+}
+
+// formatOutput adds timestamp and other formatting to a line of reader's
+// output. Its stream is "stdout" or "stderr"; its source names the split
+// command, if any. Secrets are redacted first, then lines matching a
+// highlight pattern are marked. A prefix template replaces the timestamp
+// and labels.
+func (s *ShellCast) formatOutput(reader *outputReader, line string) string {
+	stream, source := reader.stream, reader.source
+	line = s.redact(line)
+	highlight := s.isHighlighted(line)
+	number := s.lineNumber(reader)
+
+	if s.cfg().OutputFormat == "json" {
+		data, err := json.Marshal(outputLine{
+			Time:      time.Now().Format(time.RFC3339Nano),
+			Stream:    stream,
+			Source:    source,
+			Line:      line,
+			Highlight: highlight,
+			Number:    number,
+		})
+		if err != nil {
+			return line
+		}
+		return string(data)
+	}
+
+	if s.cfg().PrefixTemplate != "" {
+		line = s.renderPrefix(reader, time.Now()) + line
+		if number > 0 {
+			line = formatLineNumber(number) + line
+		}
+		if highlight {
+			line = highlightMarker + line
+		}
+		return line
+	}
+
+	if stream == "stderr" && !s.cfg().MergeStreams {
+		line = "[stderr] " + line
+	}
+	if source != "" {
+		line = fmt.Sprintf("[%s] %s", source, line)
+	}
+	if s.cfg().ShowTimestamp {
+		line = s.timestampPrefix(reader, time.Now()) + line
+	}
+	if number > 0 {
+		line = formatLineNumber(number) + line
+	}
+	if highlight {
+		line = highlightMarker + line
+	}
+	return line
+}
+
+// formatElapsed formats d as +HH:MM:SS.mmm
+func formatElapsed(d time.Duration) string {
+	ms := d.Milliseconds()
+	return fmt.Sprintf("+%02d:%02d:%02d.%03d",
+		ms/3600000, ms/60000%60, ms/1000%60, ms%1000)
+}
+
+// selectEncoder picks the first encoder in EncoderPriority that FFmpeg
+// supports, or libx264 if it supports none of them or can't be run,
+// which starting FFmpeg then reports
+func (s *ShellCast) selectEncoder() string {
+	encoders, err := s.ffmpegEncoders()
+	if err != nil {
+		return "libx264"
+	}
+	for _, enc := range s.cfg().EncoderPriority {
+		if hasEncoder(encoders, enc) {
+			return enc
+		}
 	}
+	return "libx264"
+}
 
-	cmd := exec.Command(parts[0], parts[1:]...)
-	cmd.Stdin = os.Stdin
+// ffmpegEncoders returns what ffmpeg -encoders lists. The list is kept
+// for the FFmpeg executable it came from, so FFmpeg runs once rather than
+// for every command line built.
+func (s *ShellCast) ffmpegEncoders() (string, error) {
+	resolved, err := s.resolveFFmpeg()
+	if err != nil {
+		return "", err
+	}
 
-	stdout, err := cmd.StdoutPipe()
+	s.encoderMutex.Lock()
+	defer s.encoderMutex.Unlock()
+	if s.encoderFFmpeg == resolved {
+		return s.encoderList, nil
+	}
+	output, err := exec.Command(resolved, "-hide_banner", "-encoders").Output()
 	if err != nil {
-		return fmt.Errorf("error creating stdout pipe: %v", err)
+		return "", fmt.Errorf("error listing FFmpeg encoders: %v", err)
+	}
+	s.encoderFFmpeg, s.encoderList = resolved, string(output)
+	return s.encoderList, nil
+}
+
+// hasEncoder reports whether encoders, as listed by ffmpeg -encoders,
+// has the encoder named name. Names must match exactly, so libx264rgb
+// doesn't count as libx264.
+func hasEncoder(encoders, name string) bool {
+	for _, line := range strings.Split(encoders, "\n") {
+		// Lines are capability flags, name and description
+		fields := strings.Fields(line)
+		if len(fields) >= 2 && fields[1] == name {
+			return true
+		}
+	}
+	return false
+}
+
+// resolveFFmpeg returns the FFmpeg executable to run: FFmpegPath, or
+// "ffmpeg" when it is empty. A bare name is looked up in PATH; a path
+// must exist.
+func (s *ShellCast) resolveFFmpeg() (string, error) {
+	ffmpegPath := s.cfg().FFmpegPath
+	if ffmpegPath == "" {
+		ffmpegPath = "ffmpeg"
+	}
+
+	if !strings.ContainsAny(ffmpegPath, `/\`) {
+		resolved, err := exec.LookPath(ffmpegPath)
+		if err != nil {
+			return "", fmt.Errorf("ffmpeg not found in PATH (%s): install FFmpeg or set its location with -ffmpeg or ffmpeg_path", ffmpegPath)
+		}
+		return resolved, nil
+	}
+
+	info, err := os.Stat(ffmpegPath)
+	if err != nil {
+		return "", fmt.Errorf("ffmpeg not found at %s: check -ffmpeg or ffmpeg_path", ffmpegPath)
+	}
+	if info.IsDir() {
+		return "", fmt.Errorf("ffmpeg path %s is a directory, not the FFmpeg executable", ffmpegPath)
+	}
+	return ffmpegPath, nil
+}
+
+// CheckFFmpeg verifies that FFmpeg can be found and run and that it
+// supports the configured encoder, returning an actionable error otherwise
+func (s *ShellCast) CheckFFmpeg() error {
+	resolved, err := s.resolveFFmpeg()
+	if err != nil {
+		return err
+	}
+
+	version, err := exec.Command(resolved, "-version").Output()
+	if err != nil {
+		return fmt.Errorf("error running %s -version: %v", resolved, err)
+	}
+	if !strings.HasPrefix(string(version), "ffmpeg version") {
+		return fmt.Errorf("%s does not appear to be FFmpeg", resolved)
+	}
+
+	encoders, err := s.ffmpegEncoders()
+	if err != nil {
+		return err
+	}
+	// With automatic selection any encoder in the priority list will do
+	if s.cfg().Encoder != "auto" && !hasEncoder(encoders, s.cfg().Encoder) {
+		return fmt.Errorf("%s was built without the %s encoder; choose another one with -encoder or install an FFmpeg build that supports it",
+			resolved, s.cfg().Encoder)
+	}
+
+	return nil
+}
+
+// encoderSettings returns what an encoder needs beyond -c:v: global
+// options placed before the input, a suffix for the video filter chain
+// and codec options placed after -c:v
+func (s *ShellCast) encoderSettings(encoder string) ([]string, string, []string) {
+	switch encoder {
+	case "h264_nvenc":
+		return nil, "", []string{"-preset", "fast"}
+	case "h264_vaapi":
+		// Frames are rendered in system memory and uploaded to the GPU
+		return []string{"-vaapi_device", s.cfg().VAAPIDevice}, ",format=nv12,hwupload", nil
+	case "h264_videotoolbox":
+		return nil, "", []string{"-realtime", "1"}
+	default:
+		return nil, "", []string{"-preset", "ultrafast", "-strict", "-1"}
+	}
+}
+
+// ffmpegCommand returns the FFmpeg executable and the arguments used to
+// render the output file and send it to the configured destination
+func (s *ShellCast) ffmpegCommand(textFile string) (string, []string) {
+	ffmpegPath := s.cfg().FFmpegPath
+	if ffmpegPath == "" {
+		ffmpegPath = "ffmpeg" // Use from PATH
+	}
+
+	encoder := s.cfg().Encoder
+	if encoder == "auto" {
+		encoder = s.selectEncoder()
+	}
+	globalArgs, hwFilter, codecArgs := s.encoderSettings(encoder)
+
+	// FFmpeg's own chatter is only wanted when debugging
+	var args []string
+	if s.logger.Level < LogDebug {
+		args = append(args, "-hide_banner", "-loglevel", "error")
+	}
+	// Progress reports on stdout feed StreamStats
+	args = append(args, "-progress", "pipe:1")
+	args = append(args, globalArgs...)
+
+	if s.cfg().CaptureMode == "screen" {
+		args = append(args, s.screenCaptureInput(hwFilter)...)
+	} else {
+		args = append(args,
+			"-f", "lavfi",
+			"-re",
+			"-i", fmt.Sprintf("color=size=%dx%d:rate=30:color=%s",
+				s.cfg().ScreenWidth,
+				s.cfg().ScreenHeight,
+				strings.ReplaceAll(s.cfg().BackgroundColor, "#", "0x")),
+			"-vf", s.createVideoFilter(textFile, hwFilter),
+		)
+	}
+	args = append(args, "-c:v", encoder)
+	args = append(args, codecArgs...)
+	args = append(args, s.rateControlArgs()...)
+
+	// User supplied arguments go after the encoder settings and right
+	// before the output, so they can override options set above
+	args = append(args, s.cfg().ExtraFFmpegArgs...)
+
+	outputs := s.liveOutputs()
+	if s.cfg().RecordVideo {
+		outputs = append(outputs, OutputSpec{URL: s.videoRecordPath(), Format: "mp4"})
+	}
+	switch len(outputs) {
+	case 0:
+		args = append(args, "-f", "flv", s.cfg().RTMPUrl)
+	case 1:
+		format := outputFormat(outputs[0])
+		args = append(args, "-f", format)
+		if format == "mp4" {
+			args = append(args, "-movflags", mp4Flags)
+		}
+		args = append(args, outputs[0].URL)
+	default:
+		// The tee muxer encodes once and writes to every output
+		slaves := make([]string, len(outputs))
+		for i, output := range outputs {
+			options := "f=" + outputFormat(output)
+			if outputFormat(output) == "mp4" {
+				options += ":movflags=" + mp4Flags
+			}
+			slaves[i] = fmt.Sprintf("[%s]%s", options, output.URL)
+		}
+		args = append(args, "-map", "0:v", "-f", "tee", strings.Join(slaves, "|"))
+	}
+
+	return ffmpegPath, args
+}
+
+// screenCaptureInput returns the FFmpeg input and filter arguments that
+// capture CaptureDevice with avfoundation, scaled and padded to the
+// screen size
+func (s *ShellCast) screenCaptureInput(hwFilter string) []string {
+	width, height := s.cfg().ScreenWidth, s.cfg().ScreenHeight
+	filter := fmt.Sprintf("scale=%d:%d:force_original_aspect_ratio=decrease,pad=%d:%d:(ow-iw)/2:(oh-ih)/2,format=yuv420p%s",
+		width, height, width, height, hwFilter)
+
+	return []string{
+		"-f", "avfoundation",
+		"-framerate", "30",
+		"-capture_cursor", "1",
+		"-i", s.cfg().CaptureDevice + ":none", // video only
+		"-vf", filter,
+	}
+}
+
+// mp4Flags makes MP4 files playable even when FFmpeg is killed before it
+// can write the index at the end
+const mp4Flags = "frag_keyframe+empty_moov"
+
+// outputFormat returns the FFmpeg muxer for an output, guessing it from
+// the URL when no format is given
+func outputFormat(output OutputSpec) string {
+	if output.Format != "" {
+		return output.Format
+	}
+
+	url := strings.ToLower(output.URL)
+	switch {
+	case strings.HasSuffix(url, ".mp4"):
+		return "mp4"
+	case strings.HasSuffix(url, ".mkv"):
+		return "matroska"
+	case strings.HasSuffix(url, ".m3u8"):
+		return "hls"
+	case strings.HasSuffix(url, ".ts"):
+		return "mpegts"
+	case strings.HasSuffix(url, ".gif"):
+		return "gif"
+	default:
+		return "flv"
+	}
+}
+
+// rtmpTarget returns the RTMP URL streamed to, if any, with the stream
+// key masked
+func (s *ShellCast) rtmpTarget() string {
+	url := s.cfg().RTMPUrl
+	if url == "" && s.cfg().streamBase() != "" {
+		url = joinStreamKey(s.cfg().streamBase(), s.cfg().StreamKey)
+	}
+	return maskStreamKeys(url, s.cfg().StreamKey)
+}
+
+// streamTargets lists the stream destinations for messages, with stream
+// keys masked
+func (s *ShellCast) streamTargets() string {
+	var urls []string
+	for _, output := range s.cfg().StreamOutputs() {
+		urls = append(urls, output.URL)
+	}
+	if s.cfg().RecordVideo {
+		urls = append(urls, s.videoRecordPath())
+	}
+	return maskStreamKeys(strings.Join(urls, ", "), s.cfg().StreamKey)
+}
+
+// videoRecordPath returns the MP4 file the current stream is recorded
+// to. When not streaming, it is the name a stream started now would get.
+func (s *ShellCast) videoRecordPath() string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.videoPath != "" {
+		return s.videoPath
+	}
+	return s.newVideoPath()
+}
+
+// newVideoPath returns a new timestamped MP4 file name in RecordPath
+func (s *ShellCast) newVideoPath() string {
+	timestamp := time.Now().Format("2006-01-02_15-04-05")
+	return filepath.Join(s.cfg().RecordPath, fmt.Sprintf("shellcast_%s.mp4", timestamp))
+}
+
+// FFmpegCommandLine returns the FFmpeg invocation StartStreaming would
+// run, quoted so it can be pasted into a shell. Stream keys are masked.
+func (s *ShellCast) FFmpegCommandLine() string {
+	textFile := s.cfg().OutputFile
+	if textFile == "" {
+		textFile = filepath.Join(os.TempDir(), "shellcast_output.txt")
+	}
+	ffmpegPath, args := s.ffmpegCommand(textFile)
+
+	quoted := []string{shellQuote(ffmpegPath)}
+	for _, arg := range args {
+		quoted = append(quoted, shellQuote(arg))
+	}
+	return maskStreamKeys(strings.Join(quoted, " "), s.cfg().StreamKey)
+}
+
+// StartStreaming starts the configured renderer, normally FFmpeg, to
+// stream terminal output. In dry-run mode it only prints the FFmpeg
+// command line.
+func (s *ShellCast) StartStreaming() error {
+	return s.StartStreamingContext(context.Background())
+}
+
+// StartStreamingContext is like StartStreaming, but stops the stream when
+// ctx is cancelled
+func (s *ShellCast) StartStreamingContext(ctx context.Context) error {
+	// A new stream starts at full quality, a restart keeps what adaptive
+	// mode chose
+	s.mutex.Lock()
+	s.adaptiveStep = 0
+	s.mutex.Unlock()
+	return s.startStreaming(ctx, true)
+}
+
+// startStreaming starts the renderer and, if intro is set and configured,
+// shows the intro countdown before returning
+func (s *ShellCast) startStreaming(ctx context.Context, intro bool) error {
+	if s.isStreaming() {
+		return fmt.Errorf("already streaming")
 	}
 
-	stderr, err := cmd.StderrPipe()
-	if err != nil {
-		return fmt.Errorf("error creating stderr pipe: %v", err)
+	if s.cfg().DryRun {
+		fmt.Fprintln(s.Stdout, s.FFmpegCommandLine())
+		return nil
+	}
+
+	// Don't leave the output file behind if the renderer doesn't start
+	started := false
+	defer func() {
+		if !started {
+			s.closeStreamFile()
+			s.mutex.Lock()
+			s.videoPath = ""
+			s.mutex.Unlock()
+		}
+	}()
+
+	// Seed the file with the buffer and start appending to it in one step,
+	// so no line is written twice or lost in between
+	s.mutex.Lock()
+
+	// Name the video once, so the command line stays the same while streaming
+	if s.cfg().RecordVideo {
+		s.videoPath = s.newVideoPath()
+	}
+
+	// Create output file if it doesn't exist
+	if s.cfg().OutputFile == "" {
+		tmpFile, err := os.CreateTemp("", tempFilePattern)
+		if err != nil {
+			s.mutex.Unlock()
+			return fmt.Errorf("error creating temp file: %v", err)
+		}
+		s.updateConfig(func(c *Config) { c.OutputFile = tmpFile.Name() })
+		tmpFile.Close()
+	}
+	initialData := s.outputBuffer.String()
+	if initialData == "" {
+		initialData = "ShellCast Streaming Initialized\n"
+	}
+	intro = intro && s.cfg().IntroDuration > 0
+	if intro {
+		initialData = introFrame(s.cfg().IntroText, introSeconds(time.Duration(s.cfg().IntroDuration)))
+	}
+	file, err := os.OpenFile(s.cfg().OutputFile, os.O_WRONLY|os.O_CREATE|os.O_TRUNC|os.O_APPEND, 0644)
+	if err == nil {
+		if _, err = file.WriteString(initialData); err != nil {
+			file.Close()
+		} else {
+			s.streamOut = newStreamFile(file, int64(len(initialData)))
+			s.streamActivity = time.Now()
+			if !intro {
+				s.openStreamLen = s.openBufLen
+			}
+		}
+	}
+	s.mutex.Unlock()
+	if err != nil {
+		return fmt.Errorf("error writing to output file: %v", err)
+	}
+
+	s.mutex.Lock()
+	s.streamStats = StreamStats{}
+	s.mutex.Unlock()
+
+	renderer := s.newRenderer()
+	if err := renderer.Start(s.cfg().OutputFile); err != nil {
+		return err
+	}
+	started = true
+
+	done := make(chan struct{})
+	s.mutex.Lock()
+	s.renderer = renderer
+	s.streaming = true
+	s.streamCtx = ctx
+	s.streamDone = done
+	s.mutex.Unlock()
+
+	if ctx.Done() != nil {
+		go func() {
+			select {
+			case <-ctx.Done():
+				s.logger.Debugf("Stream context cancelled: %v", ctx.Err())
+				s.StopStreaming()
+			case <-done:
+			}
+		}()
+	}
+	go s.flushStreamLoop(done)
+	if s.cfg().KeepaliveInterval > 0 {
+		go s.keepalive(done)
+	}
+	if s.cfg().Adaptive {
+		go s.adapt(done)
+	}
+
+	s.logger.Infof("Streaming started to %s", s.streamTargets())
+	s.events.OnStreamStart(s.streamTargets())
+
+	if intro {
+		s.playIntro(ctx)
+	}
+	return nil
+}
+
+// playIntro counts down on the stream for IntroDuration, then replaces
+// the countdown with the buffered output
+func (s *ShellCast) playIntro(ctx context.Context) {
+	deadline := time.Now().Add(time.Duration(s.cfg().IntroDuration))
+	for {
+		left := time.Until(deadline)
+		if left <= 0 {
+			break
+		}
+		seconds := introSeconds(left)
+
+		s.mutex.Lock()
+		err := s.rewriteStreamFile(introFrame(s.cfg().IntroText, seconds))
+		s.mutex.Unlock()
+		if err != nil {
+			s.logger.Errorf("Error writing intro: %v", err)
+			break
+		}
+
+		// Wake up when the displayed number changes
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(left - time.Duration(seconds-1)*time.Second):
+		}
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if err := s.rewriteStreamFile(s.outputBuffer.String()); err != nil {
+		s.logger.Errorf("Error writing output: %v", err)
+	}
+	s.openStreamLen = s.openBufLen
+}
+
+// introSeconds rounds d up to whole seconds for the countdown
+func introSeconds(d time.Duration) int {
+	return int((d + time.Second - 1) / time.Second)
+}
+
+// introFrame is the stream text shown during the intro
+func introFrame(text string, seconds int) string {
+	frame := fmt.Sprintf("Starting in %d...\n", seconds)
+	if text != "" {
+		frame = text + "\n\n" + frame
+	}
+	return frame
+}
+
+// rewriteStreamFile replaces the contents of the stream file with text.
+// The caller must hold s.mutex.
+func (s *ShellCast) rewriteStreamFile(text string) error {
+	if s.streamOut == nil {
+		return nil
+	}
+	s.keepaliveLen = 0
+	if err := s.streamOut.Truncate(0); err != nil {
+		return fmt.Errorf("error clearing output file: %v", err)
+	}
+	if _, err := s.streamOut.WriteString(text); err != nil {
+		return fmt.Errorf("error writing to output file: %v", err)
+	}
+	return nil
+}
+
+// RestartStreaming restarts FFmpeg so configuration changes such as
+// colors and font size take effect on an active stream. Viewers see a
+// short interruption while the new process connects.
+func (s *ShellCast) RestartStreaming() error {
+	s.mutex.Lock()
+	ctx := s.streamCtx
+	s.mutex.Unlock()
+	if err := s.StopStreaming(); err != nil {
+		return err
+	}
+	return s.startStreaming(ctx, false)
+}
+
+// SetTheme applies a theme preset, restarting an active stream so the
+// new colors show up
+func (s *ShellCast) SetTheme(name string) error {
+	var err error
+	s.updateConfig(func(c *Config) { err = c.ApplyTheme(name) })
+	if err != nil {
+		return err
+	}
+
+	if s.isStreaming() {
+		s.logger.Infof("Restarting stream to apply theme %s", name)
+		if err := s.RestartStreaming(); err != nil {
+			return fmt.Errorf("error restarting stream: %v", err)
+		}
+	}
+	return nil
+}
+
+// createVideoFilter creates the FFmpeg video filter string that renders
+// the text file, scaled down by adaptive mode, followed by any filters
+// the encoder needs
+func (s *ShellCast) createVideoFilter(textFile, hwFilter string) string {
+	font := ""
+	if fontFile := s.fontFile(); fontFile != "" {
+		font = ":fontfile=" + escapeFilterPath(fontFile)
+	}
+
+	return fmt.Sprintf("drawtext=textfile=%s%s:reload=1:fontcolor=%s:fontsize=%d:x=%s:y=%s%s%s%s",
+		escapeFilterPath(textFile),
+		font,
+		s.cfg().FontColor,
+		s.cfg().FontSize,
+		s.textPosition(s.cfg().TextX),
+		s.textPosition(s.cfg().TextY),
+		s.textBoxOptions(),
+		s.adaptiveScaleFilter(),
+		hwFilter)
+}
+
+// fontFile returns the font file drawtext renders with, or "" to leave
+// the choice to FFmpeg. Once that failed to load, it is the embedded
+// font.
+func (s *ShellCast) fontFile() string {
+	fontFile := s.chooseFontFile()
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.fontFailed && fontFile == s.failedFont {
+		return s.embeddedFontPath
+	}
+	return fontFile
+}
+
+// textBoxOptions returns the drawtext options for the box behind the
+// text: the background color at BoxOpacity, replacing any opacity the
+// color has, or nothing when TextBox is off
+func (s *ShellCast) textBoxOptions() string {
+	if !s.cfg().TextBox {
+		return ""
 	}
+	color, _, _ := strings.Cut(s.cfg().BackgroundColor, "@")
+	color = strings.ReplaceAll(color, "#", "0x")
+	return fmt.Sprintf(":box=1:boxcolor=%s@%g:boxborderw=%d",
+		color, s.cfg().BoxOpacity, s.cfg().TextPadding/2)
+}
 
-	// Start the command
-	if err := cmd.Start(); err != nil {
-		return fmt.Errorf("error starting command: %v", err)
+// textPosition returns a drawtext x or y value: the padding when pos is
+// empty, else pos quoted, so expressions may contain commas and colons
+func (s *ShellCast) textPosition(pos string) string {
+	if pos == "" {
+		return fmt.Sprintf("%d", s.cfg().TextPadding)
 	}
+	return "'" + pos + "'"
+}
 
-	// Handle output in goroutines
-	var wg sync.WaitGroup
-	wg.Add(2)
+// escapeFilterPath makes a file path usable as a filter option value.
+// Windows paths get forward slashes, and the colon after the drive
+// letter would otherwise end the option.
+func escapeFilterPath(path string) string {
+	path = filepath.ToSlash(path)
+	return strings.NewReplacer(`\`, `\\`, `:`, `\:`, `'`, `\'`).Replace(path)
+}
 
-	// Process stdout
-	go func() {
-		defer wg.Done()
-		scanner := bufio.NewScanner(stdout)
-		for scanner.Scan() {
-			line := scanner.Text()
-			formattedLine := s.formatOutput(line)
-			fmt.Println(formattedLine)
+// isStreaming reports whether FFmpeg is running
+func (s *ShellCast) isStreaming() bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.streaming
+}
 
-			// Store in buffer
-			s.mutex.Lock()
-			s.outputBuffer += formattedLine + "\n"
-			s.mutex.Unlock()
+// isRecording reports whether the session is being recorded
+func (s *ShellCast) isRecording() bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.recording
+}
 
-			// If streaming, append to output file
-			if s.streaming && s.config.OutputFile != "" {
-				appendToFile(s.config.OutputFile, formattedLine+"\n")
-			}
+// StopStreaming stops the streaming process
+func (s *ShellCast) StopStreaming() error {
+	// Take the stream over under the mutex, so a concurrent stop, e.g.
+	// from the stream context, finds it already stopped
+	s.mutex.Lock()
+	renderer, done, video := s.renderer, s.streamDone, s.videoPath
+	if !s.streaming || renderer == nil {
+		s.mutex.Unlock()
+		return fmt.Errorf("not streaming")
+	}
+	s.streaming = false
+	s.renderer = nil
+	s.streamDone = nil
+	s.videoPath = ""
+	s.mutex.Unlock()
 
-			// If recording, save to record file
-			if s.recording && s.recordPath != "" {
-				appendToFile(s.recordPath, formattedLine+"\n")
-			}
-		}
-	}()
+	stopErr := renderer.Stop()
+	if done != nil {
+		close(done)
+	}
 
-	go func() {
-		defer wg.Done()
-		scanner := bufio.NewScanner(stderr)
-		for scanner.Scan() {
-			line := scanner.Text()
-			formattedLine := s.formatOutput(line)
-			fmt.Fprintln(os.Stderr, formattedLine)
+	// Stop appending output and clean up the output file
+	s.closeStreamFile()
 
-			// Store in buffer
-			s.mutex.Lock()
-			s.outputBuffer += formattedLine + "\n"
-			s.mutex.Unlock()
+	if video != "" {
+		s.logger.Infof("Video saved: %s", video)
+	}
 
-			// If streaming, append to output file
-			if s.streaming && s.config.OutputFile != "" {
-				appendToFile(s.config.OutputFile, formattedLine+"\n")
-			}
+	s.logger.Infof("Streaming stopped")
+	s.events.OnStreamStop()
+	return stopErr
+}
 
-			// If recording, save to record file
-			if s.recording && s.recordPath != "" {
-				appendToFile(s.recordPath, formattedLine+"\n")
-			}
-		}
-	}()
+// pausedBanner is shown in the video while streaming is paused
+const pausedBanner = "\n*** PAUSED ***\n"
 
-	// Wait for command to finish
-	wg.Wait()
-	return cmd.Wait()
-}
+// PauseStreaming stops sending output to the stream, the buffer and live
+// viewers without stopping FFmpeg. The video shows a paused banner.
+func (s *ShellCast) PauseStreaming() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
 
-// formatOutput adds timestamp and other formatting to the output
-func (s *ShellCast) formatOutput(line string) string {
-	if s.config.ShowTimestamp {
-		timestamp := time.Now().Format(s.config.TimestampFormat)
-		return fmt.Sprintf("[%s] %s", timestamp, line)
+	if s.streamOut == nil {
+		return fmt.Errorf("not streaming")
+	}
+	if s.streamPaused {
+		return fmt.Errorf("streaming already paused")
 	}
-	return line
-}
-
-func (s *ShellCast) selectEncoder() string {
-    checkEncoder := func(enc string) bool {
-        cmd := exec.Command(s.config.FFmpegPath, "-hide_banner", "-encoders")
-        output, _ := cmd.CombinedOutput()
-        return strings.Contains(string(output), enc)
-    }
 
-    for _, enc := range s.config.EncoderPriority {
-        if checkEncoder(enc) {
-            return enc
-        }
-    }
-    return "libx264" 
+	s.closeOpenLine()
+	s.clearKeepalive()
+	s.streamPaused = true
+	if _, err := s.streamOut.WriteString(pausedBanner); err != nil {
+		return fmt.Errorf("error writing to output file: %v", err)
+	}
+	return nil
 }
 
-// StartStreaming starts the FFmpeg process to stream terminal output
-func (s *ShellCast) StartStreaming() error {
-	if s.streaming {
-		return fmt.Errorf("already streaming")
-	}
+// ResumeStreaming continues streaming after PauseStreaming. Output
+// produced while paused is not shown.
+func (s *ShellCast) ResumeStreaming() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
 
-	// Create output file if it doesn't exist
-	if s.config.OutputFile == "" {
-		tmpFile, err := os.CreateTemp("", "shellcast_*.txt")
-		if err != nil {
-			return fmt.Errorf("error creating temp file: %v", err)
-		}
-		s.config.OutputFile = tmpFile.Name()
-		tmpFile.Close()
+	if !s.streamPaused {
+		return fmt.Errorf("streaming not paused")
 	}
 
-    initialData := "ShellCast Streaming Initialized\n"
-    if err := os.WriteFile(s.config.OutputFile, []byte(initialData), 0644); err != nil {
-        return fmt.Errorf("error writing initial data to output file: %v", err)
-    }
+	s.streamPaused = false
 
-	s.mutex.Lock()
-	err := os.WriteFile(s.config.OutputFile, []byte(s.outputBuffer), 0644)
-	s.mutex.Unlock()
-	if err != nil {
-		return fmt.Errorf("error writing to output file: %v", err)
+	// Replace the banner with the buffered output
+	if err := s.rewriteStreamFile(s.outputBuffer.String()); err != nil {
+		return err
 	}
+	s.openStreamLen = s.openBufLen
+	return nil
+}
 
-	// Prepare FFmpeg command
-	ffmpegPath := s.config.FFmpegPath
-	if ffmpegPath == "" {
-		ffmpegPath = "ffmpeg" // Use from PATH
+// recordLine writes a line to the recording, if one is active and not
+// paused. The caller must hold s.mutex.
+func (s *ShellCast) recordLine(line string) {
+	if s.recordWriter == nil || s.recordPaused {
+		return
+	}
+	if s.cfg().isSubtitleFormat() {
+		s.recordCue(line)
+		return
+	}
+	if _, err := s.recordWriter.WriteString(line + "\n"); err != nil {
+		s.logger.Errorf("Error writing recording: %v", err)
 	}
+}
 
+// PauseRecording stops writing output to the recording until
+// ResumeRecording is called
+func (s *ShellCast) PauseRecording() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
 
+	if s.recordWriter == nil {
+		return fmt.Errorf("not recording")
+	}
+	if s.recordPaused {
+		return fmt.Errorf("recording already paused")
+	}
+	s.recordPaused = true
+	return nil
+}
 
+// ResumeRecording continues recording after PauseRecording
+func (s *ShellCast) ResumeRecording() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
 
-    encoder := s.selectEncoder()
+	if !s.recordPaused {
+		return fmt.Errorf("recording not paused")
+	}
+	s.recordPaused = false
+	return nil
+}
 
-    args := []string{
-        "-f", "lavfi",
-        "-re",
-        "-i", fmt.Sprintf("color=size=%dx%d:rate=30:color=%s",
-            s.config.ScreenWidth,
-            s.config.ScreenHeight,
-            strings.ReplaceAll(s.config.BackgroundColor, "#", "0x")),
-        "-vf", fmt.Sprintf("drawtext=textfile=%s:reload=1:fontcolor=%s:fontsize=%d:x=20:y=20",
-            s.config.OutputFile,
-            s.config.FontColor,
-            s.config.FontSize),
-        "-c:v", encoder,
-        "-preset", "ultrafast",
-	"-strict", "-1",
+// closeStreamFile stops appending output to FFmpeg's text file and
+// removes it. Both happen under the mutex, so no output goroutine can
+// write to the file, or create it again, once it is gone.
+func (s *ShellCast) closeStreamFile() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
 
-        "-f", "flv",
-        s.config.RTMPUrl,
-    }
+	if s.streamOut != nil {
+		s.streamOut.Close()
+		s.streamOut = nil
+	}
+	s.streamPaused = false
+	s.keepaliveLen = 0
+	s.removeStreamFile()
+}
 
+// StartRecording starts recording the session to a file
+func (s *ShellCast) StartRecording() error {
+	// Check and claim the recording under one lock, so two concurrent
+	// starts can't both open a file. Like RotateRecording, output is held
+	// back while the file is opened.
+	s.mutex.Lock()
+	if s.recording {
+		s.mutex.Unlock()
+		return fmt.Errorf("already recording")
+	}
 
+	path := s.newRecordingPath()
 
+	// Resume an existing recording without a new header
+	resume := false
+	if s.cfg().RecordAppend {
+		if _, err := os.Stat(path); err == nil {
+			resume = true
+		}
+	}
 
-	cmd := exec.Command(ffmpegPath, args...)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
+	file, gz, writer, err := s.openRecording(path, resume)
+	if err != nil {
+		s.mutex.Unlock()
+		return err
+	}
 
-	if err := cmd.Start(); err != nil {
-		return fmt.Errorf("error starting FFmpeg: %v", err)
+	var done chan struct{}
+	if s.cfg().RecordFlushInterval > 0 {
+		done = make(chan struct{})
 	}
 
-	s.streamProc = cmd.Process
-	s.streaming = true
+	s.recordOut = file
+	s.recordGzip = gz
+	s.recordWriter = writer
+	s.recordPath = path
+	s.recordDone = done
+	s.recordStart = time.Now()
+	s.recordCommands = nil
+	s.recording = true
+	video := s.videoPath
+	s.mutex.Unlock()
 
-	fmt.Printf("Streaming started to %s\n", s.config.RTMPUrl)
+	// Flush periodically so a crash loses at most one interval
+	if done != nil {
+		go func() {
+			ticker := time.NewTicker(time.Duration(s.cfg().RecordFlushInterval))
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ticker.C:
+					if err := s.flushRecording(); err != nil {
+						s.logger.Errorf("Error flushing recording: %v", err)
+					}
+				case <-done:
+					return
+				}
+			}
+		}()
+	}
+
+	if resume {
+		s.logger.Infof("Recording resumed: %s", path)
+	} else {
+		s.logger.Infof("Recording started: %s", path)
+	}
+	s.pruneRecordings(path, video)
+	s.events.OnRecordStart(path)
 	return nil
 }
 
-// createVideoFilter creates the FFmpeg video filter string
-func (s *ShellCast) createVideoFilter() string {
-	// Basic text display
-	filter := fmt.Sprintf("drawtext=fontfile=/usr/share/fonts/truetype/dejavu/DejaVuSansMono.ttf:/etc/alternatives/fonts-conf:fontcolor=%s:fontsize=%d:box=1:boxcolor=%s:x=20:y=20:text='%s'",
-		s.config.FontColor,
-		s.config.FontSize,
-		s.config.BackgroundColor,
-		"%{eif\\:n\\:d}") // Line number will be added by FFmpeg
+// newRecordingPath returns the configured record file, or a new file in
+// RecordPath named after the current time
+func (s *ShellCast) newRecordingPath() string {
+	if s.cfg().RecordFile != "" {
+		return s.compressedPath(s.cfg().RecordFile)
+	}
 
-	// Add timestamp if requested
-if s.config.ShowTimestamp {
-    filter += ",drawtext=fontfile=/usr/share/fonts/truetype/dejavu/DejaVuSansMono.ttf:/etc/alternatives/fonts-conf:"+fmt.Sprintf("fontcolor=%s:fontsize=%d:box=1:boxcolor=%s:x=w-200:y=20:text='%%{pts\\:localtime\\:%s}'", 
-        s.config.FontColor, 
-        s.config.FontSize, 
-        s.config.BackgroundColor,
-        s.config.TimestampFormat)
+	timestamp := time.Now().Format("2006-01-02_15-04-05")
+	extension := "txt"
+	if s.cfg().OutputFormat == "json" {
+		extension = "jsonl"
+	} else if s.cfg().isSubtitleFormat() {
+		extension = s.cfg().RecordFormat
+	}
+	filename := fmt.Sprintf("shellcast_%s.%s", timestamp, extension)
+	return s.compressedPath(filepath.Join(s.cfg().RecordPath, filename))
 }
 
-	return filter
+// compressedPath adds .gz to a recording path when CompressRecording is
+// set and the path doesn't end in .gz already
+func (s *ShellCast) compressedPath(path string) string {
+	if s.cfg().CompressRecording && !strings.HasSuffix(path, ".gz") {
+		return path + ".gz"
+	}
+	return path
 }
 
-// StopStreaming stops the streaming process
-func (s *ShellCast) StopStreaming() error {
-	if !s.streaming || s.streamProc == nil {
-		return fmt.Errorf("not streaming")
+// recordingExt returns the extension of a recording path, including the
+// extension before .gz for a compressed one, e.g. ".txt.gz"
+func recordingExt(path string) string {
+	ext := filepath.Ext(path)
+	if ext == ".gz" {
+		ext = filepath.Ext(strings.TrimSuffix(path, ext)) + ext
 	}
+	return ext
+}
 
-	// Kill FFmpeg process
-	if err := s.streamProc.Kill(); err != nil {
-		return fmt.Errorf("error killing FFmpeg process: %v", err)
+// openRecording opens a recording file, creating its directory, and
+// writes the header unless an existing recording is resumed. A path
+// ending in .gz is written through a gzip writer, which is also
+// returned; a resumed one gets a new gzip member, which gzip readers
+// read as one stream.
+func (s *ShellCast) openRecording(path string, resume bool) (*os.File, *gzip.Writer, *bufio.Writer, error) {
+	// Create recordings directory if it doesn't exist
+	recordDir := filepath.Dir(path)
+	if _, err := os.Stat(recordDir); os.IsNotExist(err) {
+		if err := os.MkdirAll(recordDir, 0755); err != nil {
+			return nil, nil, nil, fmt.Errorf("error creating recordings directory: %v", err)
+		}
 	}
 
-	s.streaming = false
-	s.streamProc = nil
-
-	// Clean up output file
-	if s.config.OutputFile != "" {
-		os.Remove(s.config.OutputFile)
-		s.config.OutputFile = ""
+	flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	if resume {
+		flags = os.O_WRONLY | os.O_APPEND
+	}
+	file, err := os.OpenFile(path, flags, 0644)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("error opening record file: %v", err)
+	}
+	var gz *gzip.Writer
+	writer := bufio.NewWriter(s.cfg().lineEndingWriter(file))
+	if strings.HasSuffix(path, ".gz") {
+		gz = gzip.NewWriter(file)
+		writer = bufio.NewWriter(s.cfg().lineEndingWriter(gz))
 	}
 
-	fmt.Println("Streaming stopped")
-	return nil
+	// Write header to recording file. JSON recordings hold only output
+	// lines so every line of the file parses, subtitles only cues, and
+	// raw recordings nothing but the output.
+	if !resume && s.cfg().isSubtitleFormat() {
+		writer.WriteString(s.cfg().subtitleHeader())
+	} else if !resume && s.cfg().OutputFormat != "json" && !s.cfg().RawRecording {
+		header := fmt.Sprintf("ShellCast Recording - Started at %s\n",
+			time.Now().Format(s.cfg().TimestampFormat))
+		header += fmt.Sprintf("Command: %s\n", maskStreamKeys(strings.Join(os.Args, " "), s.cfg().StreamKey))
+		header += strings.Repeat("-", 80) + "\n\n"
+		writer.WriteString(header)
+	}
+	return file, gz, writer, nil
 }
 
-// StartRecording starts recording the session to a file
-func (s *ShellCast) StartRecording() error {
-	if s.recording {
-		return fmt.Errorf("already recording")
+// closeRecording writes the footer and closes the open recording file.
+// The caller must hold s.mutex.
+func (s *ShellCast) closeRecording() error {
+	if s.cfg().isSubtitleFormat() {
+		s.writeCue(time.Since(s.startTime) + cueLinger)
+	} else if s.cfg().OutputFormat != "json" && !s.cfg().RawRecording {
+		footer := fmt.Sprintf("\n\n%s\n", strings.Repeat("-", 80))
+		footer += fmt.Sprintf("Recording ended at %s\n",
+			time.Now().Format(s.cfg().TimestampFormat))
+		footer += fmt.Sprintf("Duration: %s\n", time.Since(s.recordStart).Round(time.Second))
+		s.recordWriter.WriteString(footer)
 	}
 
-	// Create recordings directory if it doesn't exist
-	if _, err := os.Stat(s.config.RecordPath); os.IsNotExist(err) {
-		if err := os.MkdirAll(s.config.RecordPath, 0755); err != nil {
-			return fmt.Errorf("error creating recordings directory: %v", err)
+	// Closing the gzip writer writes the gzip footer
+	err := s.recordWriter.Flush()
+	if s.recordGzip != nil {
+		if closeErr := s.recordGzip.Close(); err == nil {
+			err = closeErr
 		}
 	}
+	if closeErr := s.recordOut.Close(); err == nil {
+		err = closeErr
+	}
+	s.recordOut = nil
+	s.recordGzip = nil
+	s.recordWriter = nil
+	return err
+}
 
-	// Generate record filename based on timestamp
-	timestamp := time.Now().Format("2006-01-02_15-04-05")
-	filename := fmt.Sprintf("shellcast_%s.txt", timestamp)
-	s.recordPath = filepath.Join(s.config.RecordPath, filename)
+// RotateRecording finishes the current recording and continues in a new
+// file, like logrotate. Output is held back while the files are swapped,
+// so no line is lost or lands in both. With RecordFile set, the finished
+// recording is renamed with a timestamp and RecordFile starts over.
+func (s *ShellCast) RotateRecording() error {
+	// Events are sent once the mutex is released; deferred calls run in
+	// reverse order
+	var finished, started string
+	defer func() {
+		if finished != "" {
+			s.events.OnRecordStop(finished)
+		}
+		if started != "" {
+			s.events.OnRecordStart(started)
+		}
+	}()
 
-	// Write header to recording file
-	header := fmt.Sprintf("ShellCast Recording - Started at %s\n",
-		time.Now().Format(s.config.TimestampFormat))
-	header += fmt.Sprintf("Command: %s\n", strings.Join(os.Args, " "))
-	header += strings.Repeat("-", 80) + "\n\n"
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
 
-	if err := os.WriteFile(s.recordPath, []byte(header), 0644); err != nil {
-		return fmt.Errorf("error writing to record file: %v", err)
+	if !s.recording {
+		return fmt.Errorf("not recording")
 	}
 
-	s.recording = true
-	fmt.Printf("Recording started: %s\n", s.recordPath)
+	finished = s.recordPath
+	err := s.closeRecording()
+	if err == nil && s.cfg().RecordFile != "" {
+		finished = uniquePath(timestampedPath(s.recordPath))
+		if renameErr := os.Rename(s.recordPath, finished); renameErr != nil {
+			err = fmt.Errorf("error renaming recording: %v", renameErr)
+		}
+	}
+
+	if err == nil {
+		if manifestErr := s.writeManifest(finished, s.recordStart); manifestErr != nil {
+			s.logger.Errorf("Error writing recording manifest: %v", manifestErr)
+		}
+	}
+	s.recordStart = time.Now()
+	s.recordCommands = nil
+
+	path := s.newRecordingPath()
+	if s.cfg().RecordFile == "" {
+		path = uniquePath(path)
+	}
+	file, gz, writer, openErr := s.openRecording(path, false)
+	if openErr != nil {
+		// Nothing is recorded until recording is restarted
+		return openErr
+	}
+	s.recordOut = file
+	s.recordGzip = gz
+	s.recordWriter = writer
+	s.recordPath = path
+	started = path
+
+	if err != nil {
+		return fmt.Errorf("error finishing recording: %v", err)
+	}
+	s.logger.Infof("Recording saved: %s", finished)
+	s.logger.Infof("Recording continues in: %s", path)
+	s.pruneRecordings(path, s.videoPath)
 	return nil
 }
 
+// timestampedPath inserts the current time before the extension of path
+func timestampedPath(path string) string {
+	ext := recordingExt(path)
+	return fmt.Sprintf("%s_%s%s", strings.TrimSuffix(path, ext),
+		time.Now().Format("2006-01-02_15-04-05"), ext)
+}
+
+// uniquePath returns path, or path with a number before the extension if
+// a file by that name already exists
+func uniquePath(path string) string {
+	ext := recordingExt(path)
+	base := strings.TrimSuffix(path, ext)
+	for i := 2; ; i++ {
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			return path
+		}
+		path = fmt.Sprintf("%s_%d%s", base, i, ext)
+	}
+}
+
 // StopRecording stops the recording process
 func (s *ShellCast) StopRecording() error {
+	s.mutex.Lock()
 	if !s.recording {
+		s.mutex.Unlock()
 		return fmt.Errorf("not recording")
 	}
 
-	// Write footer to recording file
-	footer := fmt.Sprintf("\n\n%s\n", strings.Repeat("-", 80))
-	footer += fmt.Sprintf("Recording ended at %s\n",
-		time.Now().Format(s.config.TimestampFormat))
-	footer += fmt.Sprintf("Duration: %s\n", time.Since(s.startTime).Round(time.Second))
+	if s.recordDone != nil {
+		close(s.recordDone)
+		s.recordDone = nil
+	}
+
+	var err, manifestErr error
+	if s.recordOut != nil {
+		err = s.closeRecording()
+		if err == nil {
+			manifestErr = s.writeManifest(s.recordPath, s.recordStart)
+		}
+	}
+	s.recordCommands = nil
+	s.recordPaused = false
+	s.recording = false
+	path := s.recordPath
+	s.mutex.Unlock()
 
-	if err := appendToFile(s.recordPath, footer); err != nil {
+	if err != nil {
 		return fmt.Errorf("error writing to record file: %v", err)
 	}
+	if manifestErr != nil {
+		s.logger.Errorf("Error writing recording manifest: %v", manifestErr)
+	}
+	s.logger.Infof("Recording stopped: %s", path)
+	s.events.OnRecordStop(path)
+	return nil
+}
 
-	s.recording = false
-	fmt.Printf("Recording stopped: %s\n", s.recordPath)
+// flushRecording writes buffered recording output to the file, and syncs
+// it to disk when RecordFsync is set. A compressed recording is flushed
+// so everything written so far can be decompressed, even if the footer
+// is never written.
+func (s *ShellCast) flushRecording() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.recordWriter == nil {
+		return nil
+	}
+	if err := s.recordWriter.Flush(); err != nil {
+		return err
+	}
+	if s.recordGzip != nil {
+		if err := s.recordGzip.Flush(); err != nil {
+			return err
+		}
+	}
+	if s.cfg().RecordFsync {
+		return s.recordOut.Sync()
+	}
 	return nil
 }
 
-// ExecuteSplitCommands executes multiple commands in a split screen view
+// ExecuteSplitCommands executes multiple commands in a split screen view.
+// All commands run to completion; if any fail, the returned error lists
+// each failed command and why.
 func (s *ShellCast) ExecuteSplitCommands(commands []string) error {
-	if len(commands) == 0 {
+	return s.ExecuteSplitSpecsContext(context.Background(), SplitSpecs(commands))
+}
+
+// ExecuteSplitCommandsContext is like ExecuteSplitCommands, but kills the
+// remaining commands when ctx is cancelled
+func (s *ShellCast) ExecuteSplitCommandsContext(ctx context.Context, commands []string) error {
+	return s.ExecuteSplitSpecsContext(ctx, SplitSpecs(commands))
+}
+
+// ExecuteSplitSpecs is like ExecuteSplitCommands, with a label and color
+// for each command
+func (s *ShellCast) ExecuteSplitSpecs(specs []SplitCommandSpec) error {
+	return s.ExecuteSplitSpecsContext(context.Background(), specs)
+}
+
+// ExecuteSplitSpecsContext is like ExecuteSplitSpecs, but kills the
+// remaining commands when ctx is cancelled
+func (s *ShellCast) ExecuteSplitSpecsContext(ctx context.Context, specs []SplitCommandSpec) error {
+	if len(specs) == 0 {
 		return fmt.Errorf("no commands provided for split screen")
 	}
+	// The whole session runs with the settings it started with, even
+	// when the interactive prompt changes them meanwhile
+	config := s.cfg()
+	if len(specs) > config.MaxSplitCommands {
+		return fmt.Errorf("too many split commands: %d given, maximum is %d (see max_split_commands)",
+			len(specs), config.MaxSplitCommands)
+	}
+
+	// Commands without a label are numbered by their position
+	sources := make([]string, len(specs))
+	colors := make([]string, len(specs))
+	seen := make(map[string]bool)
+	for i, spec := range specs {
+		sources[i] = spec.Label
+		if sources[i] == "" {
+			sources[i] = fmt.Sprintf("CMD%d", i+1)
+		}
+		if seen[sources[i]] {
+			return fmt.Errorf("split command label '%s' is used twice", sources[i])
+		}
+		seen[sources[i]] = true
+
+		if spec.Color != "" {
+			color, err := NormalizeColor(spec.Color)
+			if err != nil {
+				return fmt.Errorf("%s color: %v", sources[i], err)
+			}
+			colors[i] = color
+		}
+	}
+
+	// Each command gets its own context, so it can be killed on its own
+	jobs := make([]*splitJob, len(specs))
+	for i, spec := range specs {
+		jobCtx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		jobs[i] = &splitJob{label: sources[i], command: spec.Command, config: config, ctx: jobCtx, cancel: cancel}
+	}
+	if err := s.startSplitJobs(jobs); err != nil {
+		return err
+	}
+	defer s.endSplitJobs()
 
 	// Create a wait group for all commands
 	var wg sync.WaitGroup
-	wg.Add(len(commands))
+	wg.Add(len(specs))
+
+	var failuresMutex sync.Mutex
+	failures := make([]string, 0, len(specs))
 
 	// Execute each command in a separate goroutine
-	for i, cmd := range commands {
+	for i, spec := range specs {
 		go func(idx int, command string) {
 			defer wg.Done()
 
-			// Create a prefix for this command output
-			prefix := fmt.Sprintf("[CMD%d] ", idx+1)
-
-			parts := strings.Split(command, " ")
-			if len(parts) == 0 {
-				fmt.Printf("%sEmpty command\n", prefix)
-				return
-			}
+			// Label this command's output
+			source := sources[idx]
+			prefix := "[" + source + "] "
 
-			// Create and execute the command
-			cmd := exec.Command(parts[0], parts[1:]...)
-			cmd.Stdin = os.Stdin
-			// Get pipes for stdout and stderr
-			stdout, err := cmd.StdoutPipe()
-			if err != nil {
-				fmt.Fprintf(os.Stderr, "%sError creating stdout pipe: %v\n", prefix, err)
-				return
+			job := jobs[idx]
+			err := s.runSplitCommand(job, colors[idx])
+			if s.finishSplitJob(job) && ctx.Err() == nil {
+				err = fmt.Errorf("killed")
 			}
-
-			stderr, err := cmd.StderrPipe()
 			if err != nil {
-				fmt.Fprintf(os.Stderr, "%sError creating stderr pipe: %v\n", prefix, err)
-				return
-			}
+				s.logger.Errorf("%sCommand failed: %v", prefix, err)
 
-			// Start the command
-			if err := cmd.Start(); err != nil {
-				fmt.Fprintf(os.Stderr, "%sError starting command: %v\n", prefix, err)
+				failuresMutex.Lock()
+				failures = append(failures, fmt.Sprintf("%s (%s): %v", source, command, err))
+				failuresMutex.Unlock()
 				return
 			}
+			s.logger.Infof("%sCommand completed", prefix)
+		}(i, spec.Command)
+	}
 
-			// Process stdout
-			go func() {
-				scanner := bufio.NewScanner(stdout)
-				for scanner.Scan() {
-					line := scanner.Text()
-					formattedLine := s.formatOutput(prefix + line)
-					fmt.Println(formattedLine)
-
-					// Add to buffer and recording if active
-					s.mutex.Lock()
-					s.outputBuffer += formattedLine + "\n"
-					s.mutex.Unlock()
+	// Wait for all commands to complete
+	wg.Wait()
 
-					if s.streaming && s.config.OutputFile != "" {
-//						appendToFile(s.config.OutputFile, formattedLine+"\n")
-err := appendToFileWithFlush(s.config.OutputFile, formattedLine+"\n")
-if err != nil {
-    fmt.Fprintf(os.Stderr, "Error writing output: %v\n", err)
+	if len(failures) > 0 {
+		sort.Strings(failures)
+		return fmt.Errorf("%d of %d split commands failed: %s",
+			len(failures), len(specs), strings.Join(failures, "; "))
+	}
+	return nil
 }
 
-					}
-
-					if s.recording && s.recordPath != "" {
-						appendToFile(s.recordPath, formattedLine+"\n")
-					}
-				}
-			}()
-
-			// Process stderr
-			go func() {
-				scanner := bufio.NewScanner(stderr)
-				for scanner.Scan() {
-					line := scanner.Text()
-					formattedLine := s.formatOutput(prefix + line)
-					fmt.Fprintln(os.Stderr, formattedLine)
-
-					// Add to buffer and recording if active
-					s.mutex.Lock()
-					s.outputBuffer += formattedLine + "\n"
-					s.mutex.Unlock()
-
-					if s.streaming && s.config.OutputFile != "" {
-						appendToFile(s.config.OutputFile, formattedLine+"\n")
-					}
+// runSplitCommand runs one split command, labelling its output with its
+// label and tinting it with color on the terminal. Split commands don't
+// read the terminal, which they would have to share with each other and
+// the interactive prompt.
+func (s *ShellCast) runSplitCommand(job *splitJob, color string) error {
+	parent, source, command, config := job.ctx, job.label, job.command, job.config
+	parts, err := config.commandArgs(command)
+	if err != nil {
+		return fmt.Errorf("error parsing command: %v", err)
+	}
+	if len(parts) == 0 {
+		return fmt.Errorf("empty command")
+	}
 
-					if s.recording && s.recordPath != "" {
-						appendToFile(s.recordPath, formattedLine+"\n")
-					}
-				}
-			}()
+	ctx, cancel := config.commandContext(parent)
+	defer cancel()
 
-			// Wait for command to finish
-			cmd.Wait()
-			fmt.Printf("%sCommand completed\n", prefix)
-		}(i, cmd)
+	// Create and execute the command
+	spec := CommandSpec{
+		Name: parts[0],
+		Args: parts[1:],
+		Env:  append(os.Environ(), config.terminalEnv()...),
 	}
-
-	// Wait for all commands to complete
-	wg.Wait()
-	return nil
+	proc, err := s.runPiped(ctx, spec, command, source, color, func(proc Process) {
+		s.setSplitJobProcess(job, proc)
+	})
+	if proc != nil {
+		s.commandExited(command, proc.ExitCode())
+	}
+	if parent.Err() != nil {
+		return parent.Err()
+	}
+	if ctx.Err() == context.DeadlineExceeded {
+		return fmt.Errorf("timed out after %s", config.CommandTimeout)
+	}
+	if err != nil && proc != nil && proc.ExitCode() > 0 {
+		return fmt.Errorf("exit code %d", proc.ExitCode())
+	}
+	return err
 }
 
 // Cleanup performs cleanup operations
 func (s *ShellCast) Cleanup() {
-	if s.streaming {
+	s.killCommands()
+
+	if s.isStreaming() {
 		s.StopStreaming()
 	}
 
-	if s.recording {
+	if s.isRecording() {
 		s.StopRecording()
 	}
+
+	if s.isServing() {
+		s.StopServer()
+	}
+
+	s.mutex.Lock()
+	s.removeEmbeddedFont()
+	s.mutex.Unlock()
 }
 
-// Helper function to append text to a file
-func appendToFile(filename, text string) error {
-	file, err := os.OpenFile(filename, os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0644)
-	if err != nil {
-		return err
+// commandArgs turns a command line into the program and its arguments.
+// In shell mode the shell parses it, so pipes, redirection and variables
+// work; otherwise it is split like a shell would split words.
+func (c *Config) commandArgs(command string) ([]string, error) {
+	if !c.ShellMode {
+		return splitArgs(command)
+	}
+	if strings.TrimSpace(command) == "" {
+		return nil, nil
 	}
-	defer file.Close()
+	shell, args := c.shellCommand(command)
+	return append([]string{shell}, args...), nil
+}
 
-	_, err = file.WriteString(text)
-	return err
+// shellCommand returns the program and arguments running script in
+// ShellProgram, or the system shell if that is empty
+func (c *Config) shellCommand(script string) (string, []string) {
+	shell := c.ShellProgram
+	if shell == "" {
+		shell = defaultShell
+	}
+	return shell, append(shellFlags(shell), script)
+}
+
+// shellFlags returns the arguments telling shell to run the script that
+// follows them
+func shellFlags(shell string) []string {
+	name := strings.ToLower(filepath.Base(filepath.ToSlash(shell)))
+	name = strings.TrimSuffix(name, ".exe")
+	switch name {
+	case "cmd":
+		return []string{"/C"}
+	case "powershell", "pwsh":
+		return []string{"-NoProfile", "-Command"}
+	default:
+		return []string{"-c"}
+	}
+}
+
+// splitArgs splits a command line into arguments like a POSIX shell would.
+// Single quotes keep everything literal, double quotes allow \" and \\
+// escapes, and outside quotes a backslash escapes any character.
+func splitArgs(line string) ([]string, error) {
+	var args []string
+	var current strings.Builder
+	var quote rune
+	inArg := false
+	escaped := false
+
+	for _, r := range line {
+		switch {
+		case escaped:
+			if quote == '"' && r != '"' && r != '\\' {
+				current.WriteRune('\\')
+			}
+			current.WriteRune(r)
+			escaped = false
+		case r == '\\' && quote != '\'':
+			escaped = true
+			inArg = true
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			} else {
+				current.WriteRune(r)
+			}
+		case r == '"' || r == '\'':
+			quote = r
+			inArg = true
+		case unicode.IsSpace(r):
+			if inArg {
+				args = append(args, current.String())
+				current.Reset()
+				inArg = false
+			}
+		default:
+			current.WriteRune(r)
+			inArg = true
+		}
+	}
+
+	if escaped {
+		return nil, fmt.Errorf("trailing backslash in command")
+	}
+	if quote != 0 {
+		return nil, fmt.Errorf("unterminated %c quote in command", quote)
+	}
+	if inArg {
+		args = append(args, current.String())
+	}
+	return args, nil
 }
 
-func appendToFileWithFlush(filename, text string) error {
-    file, err := os.OpenFile(filename, os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0644)
-    if err != nil {
-        return err
-    }
-    defer file.Close()
-    
-    if _, err := file.WriteString(text); err != nil {
-        return err
-    }
-    return file.Sync()  // バッファを即時フラッシュ
+// shellQuote quotes an argument for a POSIX shell when needed
+func shellQuote(arg string) string {
+	if arg != "" && strings.IndexFunc(arg, func(r rune) bool {
+		return !(unicode.IsLetter(r) || unicode.IsDigit(r) || strings.ContainsRune("-_./:=,+@%", r))
+	}) < 0 {
+		return arg
+	}
+	return "'" + strings.ReplaceAll(arg, "'", `'\''`) + "'"
 }
@@ -0,0 +1,1054 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeCommand is the scripted result of a program for fakeRunner
+type fakeCommand struct {
+	stdout string
+	stderr string
+	code   int
+}
+
+// fakeProcess is a Process writing scripted output instead of running
+type fakeProcess struct {
+	code int
+	done chan struct{}
+}
+
+func (p *fakeProcess) Pid() int { return 4242 }
+
+func (p *fakeProcess) Wait() error {
+	<-p.done
+	if p.code != 0 {
+		return fmt.Errorf("exit status %d", p.code)
+	}
+	return nil
+}
+
+func (p *fakeProcess) ExitCode() int { return p.code }
+
+func (p *fakeProcess) Kill() error { return nil }
+
+// fakeRunner is a CommandRunner answering each program with its scripted
+// output from commands, without starting processes. Other programs are
+// not found. The specs it was called with are recorded in calls.
+type fakeRunner struct {
+	commands map[string]fakeCommand
+
+	mu    sync.Mutex
+	calls []CommandSpec
+}
+
+func (r *fakeRunner) run(ctx context.Context, spec CommandSpec) (Process, error) {
+	r.mu.Lock()
+	r.calls = append(r.calls, spec)
+	r.mu.Unlock()
+
+	command, ok := r.commands[spec.Name]
+	if !ok {
+		return nil, &exec.Error{Name: spec.Name, Err: exec.ErrNotFound}
+	}
+	proc := &fakeProcess{code: command.code, done: make(chan struct{})}
+	go func() {
+		defer close(proc.done)
+		writeScripted(spec.Stdout, command.stdout)
+		writeScripted(spec.Stderr, command.stderr)
+	}()
+	return proc, nil
+}
+
+// writeScripted writes scripted output to w, which may be nil
+func writeScripted(w io.Writer, output string) {
+	if w != nil && output != "" {
+		io.WriteString(w, output)
+	}
+}
+
+// syncBuffer is a bytes.Buffer safe for concurrent use
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+// newTestShellCast returns a ShellCast running commands through a
+// fakeRunner with the given scripts, with its output in buffers
+func newTestShellCast(t *testing.T, config Config, commands map[string]fakeCommand) (*ShellCast, *fakeRunner, *syncBuffer) {
+	t.Helper()
+	stdout, stderr := &syncBuffer{}, &syncBuffer{}
+	s := NewShellCastWithOutput(config, stdout, stderr)
+	runner := &fakeRunner{commands: commands}
+	s.SetCommandRunner(runner.run)
+	t.Cleanup(func() {
+		if t.Failed() {
+			t.Logf("stderr:\n%s", stderr)
+		}
+	})
+	return s, runner, stdout
+}
+
+// exitRecorder records the exit codes of commands
+type exitRecorder struct {
+	NopEventHandler
+	mu    sync.Mutex
+	codes map[string]int
+}
+
+func (r *exitRecorder) OnCommandExit(command string, code int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.codes[command] = code
+}
+
+// lineRecorder records the lines passed to OnLine
+type lineRecorder struct {
+	NopEventHandler
+	mu    sync.Mutex
+	lines []string
+}
+
+func (r *lineRecorder) OnLine(stream, text string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.lines = append(r.lines, stream+": "+text)
+}
+
+func TestFormatOutput(t *testing.T) {
+	tests := []struct {
+		name   string
+		setup  func(*Config)
+		reader outputReader
+		line   string
+		want   string
+	}{
+		{"stdout", nil, outputReader{stream: "stdout"}, "hello", "hello"},
+		{"stderr", nil, outputReader{stream: "stderr"}, "oops", "[stderr] oops"},
+		{"merged streams", func(c *Config) { c.MergeStreams = true }, outputReader{stream: "stderr"}, "oops", "oops"},
+		{"split source", nil, outputReader{stream: "stderr", source: "CMD1"}, "oops", "[CMD1] [stderr] oops"},
+		{"highlight", func(c *Config) { c.Highlight = []string{"ERROR"} }, outputReader{stream: "stdout"}, "ERROR: disk full", highlightMarker + "ERROR: disk full"},
+		{"redaction", func(c *Config) { c.Redact = []string{`token=\w+`} }, outputReader{stream: "stdout"}, "token=abc123 ok", "token=**** ok"},
+		{"timestamp", func(c *Config) { c.ShowTimestamp = true; c.TimestampFormat = "TS" }, outputReader{stream: "stdout"}, "hello", "[TS] hello"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := GetDefaultConfig()
+			if tt.setup != nil {
+				tt.setup(&config)
+			}
+			s, _, _ := newTestShellCast(t, config, nil)
+			reader := tt.reader
+			if got := s.formatOutput(&reader, tt.line); got != tt.want {
+				t.Errorf("formatOutput(%q) = %q, want %q", tt.line, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFormatOutputJSON(t *testing.T) {
+	config := GetDefaultConfig()
+	config.OutputFormat = "json"
+	s, _, _ := newTestShellCast(t, config, nil)
+
+	got := s.formatOutput(&outputReader{stream: "stderr", source: "CMD2"}, "oops")
+	for _, want := range []string{`"stream":"stderr"`, `"source":"CMD2"`, `"line":"oops"`} {
+		if !strings.Contains(got, want) {
+			t.Errorf("formatOutput = %s, want it to contain %s", got, want)
+		}
+	}
+}
+
+func TestExecuteCommandBuffersOutput(t *testing.T) {
+	s, runner, stdout := newTestShellCast(t, GetDefaultConfig(), map[string]fakeCommand{
+		"build": {stdout: "one\ntwo\nno newline", stderr: "warning\n"},
+	})
+
+	if err := s.ExecuteCommand("build --fast 'two words'"); err != nil {
+		t.Fatalf("ExecuteCommand: %v", err)
+	}
+
+	lines := s.Lines()
+	index := map[string]int{}
+	for i, line := range lines {
+		index[line] = i
+	}
+	for _, want := range []string{"one", "two", "no newline", "[stderr] warning"} {
+		if _, ok := index[want]; !ok {
+			t.Errorf("buffer lacks %q: %q", want, lines)
+		}
+	}
+	if index["one"] > index["two"] || index["two"] > index["no newline"] {
+		t.Errorf("stdout lines out of order: %q", lines)
+	}
+	if len(lines) != 4 {
+		t.Errorf("buffer has %d lines, want 4: %q", len(lines), lines)
+	}
+	if got := stdout.String(); !strings.Contains(got, "one\ntwo\n") {
+		t.Errorf("stdout = %q, want the output echoed", got)
+	}
+
+	// The buffer accumulates over commands
+	if err := s.ExecuteCommand("build"); err != nil {
+		t.Fatalf("ExecuteCommand: %v", err)
+	}
+	if got := len(s.Lines()); got != 8 {
+		t.Errorf("buffer has %d lines after two commands, want 8", got)
+	}
+
+	if len(runner.calls) != 2 {
+		t.Fatalf("runner called %d times, want 2", len(runner.calls))
+	}
+	if got, want := runner.calls[0].Args, []string{"--fast", "two words"}; strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Errorf("args = %q, want %q", got, want)
+	}
+}
+
+func TestRecordingHeaderAndFooter(t *testing.T) {
+	config := GetDefaultConfig()
+	config.RecordFile = filepath.Join(t.TempDir(), "session.txt")
+	s, _, _ := newTestShellCast(t, config, map[string]fakeCommand{
+		"hello": {stdout: "hello world\n"},
+	})
+
+	if err := s.StartRecording(); err != nil {
+		t.Fatalf("StartRecording: %v", err)
+	}
+	if err := s.ExecuteCommand("hello"); err != nil {
+		t.Fatalf("ExecuteCommand: %v", err)
+	}
+	if err := s.StopRecording(); err != nil {
+		t.Fatalf("StopRecording: %v", err)
+	}
+
+	data, err := os.ReadFile(config.RecordFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recording := string(data)
+	if !strings.HasPrefix(recording, "ShellCast Recording - Started at ") {
+		t.Errorf("recording lacks the header:\n%s", recording)
+	}
+	header, body, ok := strings.Cut(recording, strings.Repeat("-", 80)+"\n\n")
+	if !ok || !strings.Contains(header, "\nCommand: ") {
+		t.Fatalf("recording lacks the command line and separator:\n%s", recording)
+	}
+	if !strings.HasPrefix(body, "hello world\n") {
+		t.Errorf("recording body = %q, want the output first", body)
+	}
+	footer := body[strings.Index(body, "hello world\n")+len("hello world\n"):]
+	for _, want := range []string{strings.Repeat("-", 80), "Recording ended at ", "Duration: "} {
+		if !strings.Contains(footer, want) {
+			t.Errorf("footer lacks %q:\n%s", want, footer)
+		}
+	}
+}
+
+func TestRecordingJSONHasNoHeader(t *testing.T) {
+	config := GetDefaultConfig()
+	config.OutputFormat = "json"
+	config.RecordFile = filepath.Join(t.TempDir(), "session.jsonl")
+	s, _, _ := newTestShellCast(t, config, map[string]fakeCommand{
+		"hello": {stdout: "hello world\n"},
+	})
+
+	if err := s.StartRecording(); err != nil {
+		t.Fatalf("StartRecording: %v", err)
+	}
+	if err := s.ExecuteCommand("hello"); err != nil {
+		t.Fatalf("ExecuteCommand: %v", err)
+	}
+	if err := s.StopRecording(); err != nil {
+		t.Fatalf("StopRecording: %v", err)
+	}
+
+	data, err := os.ReadFile(config.RecordFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	if len(lines) != 1 || !strings.Contains(lines[0], `"line":"hello world"`) {
+		t.Errorf("recording = %q, want only the output line", lines)
+	}
+}
+
+func TestExecuteCommandExitHandling(t *testing.T) {
+	s, _, _ := newTestShellCast(t, GetDefaultConfig(), map[string]fakeCommand{
+		"ok":   {stdout: "fine\n"},
+		"fail": {stderr: "broken\n", code: 3},
+	})
+	exits := &exitRecorder{codes: map[string]int{}}
+	s.SetEventHandler(exits)
+
+	if err := s.ExecuteCommand("ok"); err != nil {
+		t.Errorf("ExecuteCommand(ok) = %v, want nil", err)
+	}
+	if err := s.ExecuteCommand("fail now"); err == nil {
+		t.Error("ExecuteCommand(fail) = nil, want an error")
+	}
+
+	var notFound *CommandNotFoundError
+	if err := s.ExecuteCommand("missing"); !errors.As(err, &notFound) || notFound.Name != "missing" {
+		t.Errorf("ExecuteCommand(missing) = %v, want a CommandNotFoundError", err)
+	}
+
+	exits.mu.Lock()
+	defer exits.mu.Unlock()
+	if got := exits.codes["ok"]; got != 0 {
+		t.Errorf("exit code of ok = %d, want 0", got)
+	}
+	if got, ok := exits.codes["fail now"]; !ok || got != 3 {
+		t.Errorf("exit code of fail = %d (reported %v), want 3", got, ok)
+	}
+	if _, ok := exits.codes["missing"]; ok {
+		t.Error("exit reported for a command that never started")
+	}
+}
+
+// TestOnLineRedacted checks that event handlers get output lines with
+// secrets redacted, like the terminal and the recording
+func TestOnLineRedacted(t *testing.T) {
+	s, _, _ := newTestShellCast(t, GetDefaultConfig(), map[string]fakeCommand{
+		"login": {stdout: "token=abc123 ok\n", stderr: "password=hunter2\n"},
+	})
+	lines := &lineRecorder{}
+	s.SetEventHandler(lines)
+
+	if err := s.ExecuteCommand("login"); err != nil {
+		t.Fatalf("ExecuteCommand: %v", err)
+	}
+
+	lines.mu.Lock()
+	defer lines.mu.Unlock()
+	got := strings.Join(lines.lines, "\n")
+	if strings.Contains(got, "abc123") || strings.Contains(got, "hunter2") {
+		t.Errorf("OnLine got a secret:\n%s", got)
+	}
+	for _, want := range []string{"stdout: token=**** ok", "stderr: password=****"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("OnLine lines lack %q:\n%s", want, got)
+		}
+	}
+}
+
+func TestSplitCommandsExitHandling(t *testing.T) {
+	s, _, _ := newTestShellCast(t, GetDefaultConfig(), map[string]fakeCommand{
+		"ok":   {stdout: "fine\n"},
+		"fail": {code: 2},
+	})
+
+	err := s.ExecuteSplitCommands([]string{"ok", "fail", "missing"})
+	if err == nil {
+		t.Fatal("ExecuteSplitCommands = nil, want an error")
+	}
+	for _, want := range []string{"2 of 3 split commands failed", "CMD2 (fail): exit code 2", "command not found: missing"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q lacks %q", err, want)
+		}
+	}
+	if lines := s.Lines(); len(lines) != 1 || lines[0] != "[CMD1] fine" {
+		t.Errorf("buffer = %q, want the labelled output of the first command", lines)
+	}
+}
+
+// TestConfigChangesWhileRunning changes the configuration the ways the
+// interactive commands and SIGHUP do while commands produce output,
+// recordings start and stop and viewers load the page. Run it with
+// -race.
+func TestConfigChangesWhileRunning(t *testing.T) {
+	config := GetDefaultConfig()
+	config.RecordFile = filepath.Join(t.TempDir(), "session.txt")
+	s, _, _ := newTestShellCast(t, config, map[string]fakeCommand{
+		"spam": {stdout: strings.Repeat("line of output\n", 500), stderr: "done\n"},
+	})
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; ; i++ {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			s.updateConfig(func(c *Config) { c.ShowTimestamp = i%2 == 0 })
+			s.updateConfig(func(c *Config) { c.FontSize = 20 + i%10 })
+			if err := s.SetTheme([]string{"default", "hacker", "monokai"}[i%3]); err != nil {
+				t.Error(err)
+			}
+			s.AddHighlight("output")
+			s.ClearHighlights()
+			if updated, err := SetConfigValue(*s.cfg(), "merge_streams", fmt.Sprint(i%2 == 0)); err == nil {
+				s.UseConfig(updated)
+			}
+			s.ReloadConfig(*s.cfg())
+			s.Status()
+
+			recorder := httptest.NewRecorder()
+			s.handleViewer(recorder, httptest.NewRequest("GET", "/", nil))
+		}
+	}()
+
+	for i := 0; i < 5; i++ {
+		if err := s.StartRecording(); err != nil {
+			t.Fatalf("StartRecording: %v", err)
+		}
+		if err := s.ExecuteCommand("spam"); err != nil {
+			t.Errorf("ExecuteCommand: %v", err)
+		}
+		if err := s.ExecuteSplitCommands([]string{"spam", "spam"}); err != nil {
+			t.Errorf("ExecuteSplitCommands: %v", err)
+		}
+		if err := s.StopRecording(); err != nil {
+			t.Fatalf("StopRecording: %v", err)
+		}
+	}
+	close(done)
+	wg.Wait()
+
+	if got, want := len(s.Lines()), 5*3*501; got != want {
+		t.Errorf("buffer has %d lines, want %d", got, want)
+	}
+}
+
+// TestUpdateConfigLeavesSnapshots checks that a configuration read before
+// a change keeps its values, including its slices and maps
+func TestUpdateConfigLeavesSnapshots(t *testing.T) {
+	config := GetDefaultConfig()
+	config.Aliases = map[string]string{"ll": "ls -l"}
+	s, _, _ := newTestShellCast(t, config, nil)
+
+	before := s.cfg()
+	s.AddHighlight("ERROR")
+	s.updateConfig(func(c *Config) {
+		c.FontSize = 99
+		c.Aliases = setAlias(c.Aliases, "la", "ls -a")
+	})
+	s.updateConfig(func(c *Config) { c.Aliases = setAlias(c.Aliases, "ll", "") })
+
+	if before.FontSize == 99 || len(before.Highlight) != 0 {
+		t.Errorf("snapshot changed: font size %d, highlights %q", before.FontSize, before.Highlight)
+	}
+	if len(before.Aliases) != 1 || before.Aliases["ll"] != "ls -l" {
+		t.Errorf("snapshot aliases changed: %v", before.Aliases)
+	}
+	after := s.cfg()
+	if after.FontSize != 99 || len(after.Highlight) != 1 || len(after.Aliases) != 1 || after.Aliases["la"] != "ls -a" {
+		t.Errorf("changes missing: font size %d, highlights %q, aliases %v", after.FontSize, after.Highlight, after.Aliases)
+	}
+}
+
+// TestEmbeddedFontFallback checks that a font FFmpeg failed to load is
+// replaced by a copy of the embedded font, which is written again if it
+// goes missing and removed by Cleanup
+func TestEmbeddedFontFallback(t *testing.T) {
+	font := filepath.Join(t.TempDir(), "broken.ttf")
+	if err := os.WriteFile(font, []byte("not a font"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	config := GetDefaultConfig()
+	config.FontFile = font
+	s, _, _ := newTestShellCast(t, config, nil)
+
+	if got := s.fontFile(); got != font {
+		t.Fatalf("font file %q before the failure, want %q", got, font)
+	}
+
+	s.mutex.Lock()
+	embedded, err := s.embeddedFontFile()
+	s.failedFont, s.fontFailed = font, true
+	s.mutex.Unlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := s.fontFile(); got != embedded {
+		t.Fatalf("font file %q after the failure, want the embedded font %q", got, embedded)
+	}
+
+	os.Remove(embedded)
+	s.mutex.Lock()
+	embedded, err = s.embeddedFontFile()
+	s.mutex.Unlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(embedded)
+	if err != nil || !bytes.Equal(data, embeddedFont) {
+		t.Fatalf("embedded font not written again: %v", err)
+	}
+
+	s.Cleanup()
+	if _, err := os.Stat(embedded); !os.IsNotExist(err) {
+		t.Errorf("embedded font %s left after Cleanup: %v", embedded, err)
+	}
+}
+
+// TestMissingFontsUseEmbeddedFont checks that the embedded font is used
+// when none of the listed font files exist
+func TestMissingFontsUseEmbeddedFont(t *testing.T) {
+	dir := t.TempDir()
+	config := GetDefaultConfig()
+	config.FontFile = filepath.Join(dir, "a.ttf") + "," + filepath.Join(dir, "b.ttf")
+	s, _, _ := newTestShellCast(t, config, nil)
+	defer s.Cleanup()
+
+	font := s.fontFile()
+	data, err := os.ReadFile(font)
+	if err != nil || !bytes.Equal(data, embeddedFont) {
+		t.Fatalf("font file %q is not the embedded font: %v", font, err)
+	}
+}
+
+// TestConfigDurations checks that durations are written to the config
+// file as text and read back from text or nanoseconds
+func TestConfigDurations(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	config := GetDefaultConfig()
+	config.CommandTimeout = Duration(30 * time.Second)
+	if err := config.SaveConfig(path); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{`"command_timeout": "30s"`, `"typing_speed": "50ms"`} {
+		if !strings.Contains(string(data), want) {
+			t.Errorf("config file lacks %s:\n%s", want, data)
+		}
+	}
+
+	loaded, err := LoadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.CommandTimeout != config.CommandTimeout || loaded.TypingSpeed != config.TypingSpeed {
+		t.Errorf("loaded timeout %s and typing speed %s, want %s and %s", loaded.CommandTimeout, loaded.TypingSpeed, config.CommandTimeout, config.TypingSpeed)
+	}
+
+	var old struct {
+		TypingSpeed Duration `json:"typing_speed"`
+	}
+	if err := json.Unmarshal([]byte(`{"typing_speed": 20000000}`), &old); err != nil || old.TypingSpeed != Duration(20*time.Millisecond) {
+		t.Errorf("nanoseconds read as %s: %v", old.TypingSpeed, err)
+	}
+	if err := json.Unmarshal([]byte(`{"typing_speed": "fast"}`), &old); err == nil {
+		t.Error("invalid duration accepted")
+	}
+}
+
+// newStreamingTestShellCast returns a ShellCast streaming to a text file
+// in a temp directory with the "none" renderer, so no FFmpeg is needed
+func newStreamingTestShellCast(t *testing.T) (*ShellCast, string) {
+	t.Helper()
+	config := GetDefaultConfig()
+	config.Renderer = "none"
+	config.OutputFile = filepath.Join(t.TempDir(), "stream.txt")
+	s, _, _ := newTestShellCast(t, config, nil)
+	return s, config.OutputFile
+}
+
+// TestStartStreamingMidOutput checks that each line reaches the stream
+// file exactly once when streaming starts while output is written, once
+// through the initial dump of the buffer or once appended
+func TestStartStreamingMidOutput(t *testing.T) {
+	s, path := newStreamingTestShellCast(t)
+
+	// Write lines until streaming has started and more followed
+	var written atomic.Int64
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; ; i++ {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			s.writeOutput(io.Discard, fmt.Sprintf("line %d", i))
+			written.Store(int64(i + 1))
+		}
+	}()
+
+	for written.Load() < 500 {
+		runtime.Gosched()
+	}
+	if err := s.StartStreaming(); err != nil {
+		t.Fatal(err)
+	}
+	defer s.StopStreaming()
+	for started := written.Load(); written.Load() < started+500; {
+		runtime.Gosched()
+	}
+	close(stop)
+	<-done
+	s.flushStreamFile()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	if want := int(written.Load()); len(got) != want {
+		t.Errorf("stream file has %d lines, want %d", len(got), want)
+	}
+	for i, line := range got {
+		if want := fmt.Sprintf("line %d", i); line != want {
+			t.Fatalf("stream file line %d is %q, want %q", i+1, line, want)
+		}
+	}
+}
+
+// TestStopStreamingWhileWriting checks that output written while
+// streaming stops neither recreates the removed stream file nor, when it
+// is kept, changes it after StopStreaming returned
+func TestStopStreamingWhileWriting(t *testing.T) {
+	for _, keep := range []bool{false, true} {
+		t.Run(fmt.Sprintf("keep_temp=%v", keep), func(t *testing.T) {
+			s, path := newStreamingTestShellCast(t)
+			s.updateConfig(func(c *Config) { c.KeepTemp = keep })
+			if err := s.StartStreaming(); err != nil {
+				t.Fatal(err)
+			}
+
+			var wg sync.WaitGroup
+			stop := make(chan struct{})
+			for w := 0; w < 4; w++ {
+				wg.Add(1)
+				go func(w int) {
+					defer wg.Done()
+					for i := 0; ; i++ {
+						select {
+						case <-stop:
+							return
+						default:
+						}
+						s.writeOutput(io.Discard, fmt.Sprintf("writer %d line %d", w, i))
+					}
+				}(w)
+			}
+
+			time.Sleep(20 * time.Millisecond)
+			if err := s.StopStreaming(); err != nil {
+				t.Fatal(err)
+			}
+			before, beforeErr := os.ReadFile(path)
+			time.Sleep(20 * time.Millisecond)
+			close(stop)
+			wg.Wait()
+			after, afterErr := os.ReadFile(path)
+
+			if !keep {
+				if !os.IsNotExist(beforeErr) || !os.IsNotExist(afterErr) {
+					t.Errorf("stream file exists after StopStreaming: %v, then %v", beforeErr, afterErr)
+				}
+				return
+			}
+			if beforeErr != nil || afterErr != nil {
+				t.Fatalf("kept stream file missing: %v, %v", beforeErr, afterErr)
+			}
+			if !bytes.Equal(before, after) {
+				t.Errorf("kept stream file grew from %d to %d bytes after StopStreaming", len(before), len(after))
+			}
+		})
+	}
+}
+
+// TestVideoPathWhileStreaming checks that printing the command line
+// doesn't pick the video file and, run with -race, that it can happen
+// while streams start and stop
+func TestVideoPathWhileStreaming(t *testing.T) {
+	s, _ := newStreamingTestShellCast(t)
+	s.updateConfig(func(c *Config) {
+		c.RecordVideo = true
+		c.RecordPath = t.TempDir()
+	})
+
+	s.FFmpegCommandLine()
+	if s.videoPath != "" {
+		t.Fatalf("FFmpegCommandLine set the video path to %q", s.videoPath)
+	}
+
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			s.FFmpegCommandLine()
+			s.streamTargets()
+		}
+	}()
+	for i := 0; i < 20; i++ {
+		if err := s.StartStreaming(); err != nil {
+			t.Fatal(err)
+		}
+		if video := s.videoRecordPath(); !strings.HasPrefix(video, s.cfg().RecordPath) {
+			t.Errorf("video path %q is outside RecordPath", video)
+		}
+		if err := s.StopStreaming(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	close(stop)
+	wg.Wait()
+
+	if s.videoPath != "" {
+		t.Errorf("video path %q left after StopStreaming", s.videoPath)
+	}
+}
+
+// benchmarkLines is how many output lines each benchmark iteration writes
+const benchmarkLines = 100000
+
+// BenchmarkWriteOutput writes 100k lines while streaming and recording,
+// through the files ShellCast keeps open. Compare with
+// BenchmarkReopenPerLine, which appends them the way ShellCast used to.
+func BenchmarkWriteOutput(b *testing.B) {
+	config := GetDefaultConfig()
+	config.Renderer = "none"
+	config.OutputFile = filepath.Join(b.TempDir(), "stream.txt")
+	config.RecordPath = b.TempDir()
+	s := NewShellCastWithOutput(config, io.Discard, io.Discard)
+	if err := s.StartStreaming(); err != nil {
+		b.Fatal(err)
+	}
+	defer s.StopStreaming()
+	if err := s.StartRecording(); err != nil {
+		b.Fatal(err)
+	}
+	defer s.StopRecording()
+
+	line := strings.Repeat("x", 80)
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		for i := 0; i < benchmarkLines; i++ {
+			s.writeOutput(io.Discard, line)
+		}
+		// Keep the buffer from growing across iterations
+		s.ClearBuffer()
+	}
+}
+
+// BenchmarkReopenPerLine appends 100k lines to a stream and a recording
+// file, opening and closing both for each line
+func BenchmarkReopenPerLine(b *testing.B) {
+	dir := b.TempDir()
+	files := []string{filepath.Join(dir, "stream.txt"), filepath.Join(dir, "recording.txt")}
+	line := strings.Repeat("x", 80) + "\n"
+
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		for i := 0; i < benchmarkLines; i++ {
+			for _, name := range files {
+				file, err := os.OpenFile(name, os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0644)
+				if err != nil {
+					b.Fatal(err)
+				}
+				file.WriteString(line)
+				file.Close()
+			}
+		}
+	}
+}
+
+// TestCommandExitCode checks the exit status ShellCast ends with when the
+// command failed
+func TestCommandExitCode(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want int
+	}{
+		{"not found", &CommandNotFoundError{Name: "missing"}, 127},
+		{"timeout", &CommandTimeoutError{Timeout: time.Second}, 124},
+		{"wrapped timeout", fmt.Errorf("run: %w", &CommandTimeoutError{Timeout: time.Second}), 124},
+		{"other", errors.New("exit status 3"), 1},
+	}
+	for _, tt := range tests {
+		if got := commandExitCode(tt.err); got != tt.want {
+			t.Errorf("%s: commandExitCode(%v) = %d, want %d", tt.name, tt.err, got, tt.want)
+		}
+	}
+}
+
+// TestHasEncoder checks that encoder names are matched exactly
+func TestHasEncoder(t *testing.T) {
+	encoders := `Encoders:
+ V..... = Video
+ ------
+ V....D libx264rgb           libx264 H.264 / AVC (RGB) (codec h264)
+ V....D h264_nvenc           NVIDIA NVENC H.264 encoder (codec h264)
+`
+	tests := []struct {
+		name string
+		want bool
+	}{
+		{"h264_nvenc", true},
+		{"libx264rgb", true},
+		{"libx264", false},
+		{"h264", false},
+		{"nvenc", false},
+	}
+	for _, tt := range tests {
+		if got := hasEncoder(encoders, tt.name); got != tt.want {
+			t.Errorf("hasEncoder(%q) = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+// TestSelectEncoderCachesEncoders checks that automatic encoder selection
+// asks FFmpeg for its encoders once, not for every command line
+func TestSelectEncoderCachesEncoders(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("needs a shell script standing in for FFmpeg")
+	}
+	dir := t.TempDir()
+	calls := filepath.Join(dir, "calls")
+	ffmpeg := filepath.Join(dir, "ffmpeg")
+	script := "#!/bin/sh\necho run >> " + calls + "\necho ' V....D libx264rgb   RGB'\necho ' V....D h264_vaapi   VAAPI'\n"
+	if err := os.WriteFile(ffmpeg, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	config := GetDefaultConfig()
+	config.FFmpegPath = ffmpeg
+	config.Encoder = "auto"
+	config.EncoderPriority = []string{"h264_nvenc", "libx264", "h264_vaapi"}
+	s, _, _ := newTestShellCast(t, config, nil)
+
+	for i := 0; i < 3; i++ {
+		if got := s.selectEncoder(); got != "h264_vaapi" {
+			t.Fatalf("selectEncoder() = %q, want h264_vaapi", got)
+		}
+		s.FFmpegCommandLine()
+	}
+	data, err := os.ReadFile(calls)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if runs := strings.Count(string(data), "run"); runs != 1 {
+		t.Errorf("FFmpeg ran %d times, want once", runs)
+	}
+}
+
+// TestWebSocketOrigin checks that /ws refuses pages from other origins
+func TestWebSocketOrigin(t *testing.T) {
+	s, _, _ := newTestShellCast(t, GetDefaultConfig(), nil)
+	tests := []struct {
+		origin string
+		want   bool
+	}{
+		{"", true},
+		{"http://localhost:8080", true},
+		{"http://LOCALHOST:8080", true},
+		{"http://localhost:9090", false},
+		{"https://evil.example", false},
+		{"null", false},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "http://localhost:8080/ws", nil)
+		if tt.origin != "" {
+			r.Header.Set("Origin", tt.origin)
+		}
+		if got := sameOrigin(r); got != tt.want {
+			t.Errorf("sameOrigin(%q) = %v, want %v", tt.origin, got, tt.want)
+		}
+		if tt.want {
+			continue
+		}
+		recorder := httptest.NewRecorder()
+		s.handleWebSocket(recorder, r)
+		if recorder.Code != http.StatusForbidden {
+			t.Errorf("/ws from %q answered %d, want %d", tt.origin, recorder.Code, http.StatusForbidden)
+		}
+	}
+}
+
+// TestServerStartStop checks that starting and stopping the server is
+// safe while the session status is read
+func TestServerStartStop(t *testing.T) {
+	s, _, _ := newTestShellCast(t, GetDefaultConfig(), nil)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			s.Status()
+		}
+	}()
+	for i := 0; i < 5; i++ {
+		if err := s.StartServer("127.0.0.1:0"); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.StartServer("127.0.0.1:0"); err == nil {
+			t.Error("second StartServer succeeded")
+		}
+		if err := s.StopServer(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	<-done
+	if err := s.StopServer(); err == nil {
+		t.Error("StopServer succeeded without a server")
+	}
+}
+
+// TestRenderTypedCommand checks that an offline render shows a typed
+// command one character at a time, ending in a single complete line
+func TestRenderTypedCommand(t *testing.T) {
+	config := GetDefaultConfig()
+	config.TypingEffect = true
+	config.TypingSpeed = Duration(40 * time.Millisecond)
+	s, _, _ := newTestShellCast(t, config, map[string]fakeCommand{"echo": {stdout: "hi\n"}})
+
+	s.StartCapture()
+	if err := s.ExecuteCommand("echo hi"); err != nil {
+		t.Fatal(err)
+	}
+	s.mutex.Lock()
+	lines := s.captured
+	s.mutex.Unlock()
+
+	frames := s.renderFrames(lines)
+	var screens []string
+	for _, frame := range frames {
+		screens = append(screens, frame.text)
+	}
+	for _, want := range []string{"$\n", "$ e\n", "$ ec\n", "$ echo h\n", "$ echo hi\nhi\n"} {
+		found := false
+		for _, screen := range screens {
+			found = found || screen == want
+		}
+		if !found {
+			t.Errorf("no frame shows %q, frames: %q", want, screens)
+		}
+	}
+}
+
+// TestRotatedRecordingFooter checks that the footer of each rotated
+// recording gives that file's duration, not the session's
+func TestRotatedRecordingFooter(t *testing.T) {
+	config := GetDefaultConfig()
+	config.RecordFile = filepath.Join(t.TempDir(), "session.txt")
+	s, _, _ := newTestShellCast(t, config, nil)
+
+	// The session has been running for an hour when recording starts
+	s.mutex.Lock()
+	s.startTime = time.Now().Add(-time.Hour)
+	s.mutex.Unlock()
+
+	if err := s.StartRecording(); err != nil {
+		t.Fatal(err)
+	}
+	s.writeOutput(io.Discard, "first file")
+	if err := s.RotateRecording(); err != nil {
+		t.Fatal(err)
+	}
+	s.writeOutput(io.Discard, "second file")
+	if err := s.StopRecording(); err != nil {
+		t.Fatal(err)
+	}
+
+	files, err := filepath.Glob(filepath.Join(filepath.Dir(config.RecordFile), "session*.txt"))
+	if err != nil || len(files) != 2 {
+		t.Fatalf("recordings %v, want 2: %v", files, err)
+	}
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(string(data), "Duration: 0s\n") {
+			t.Errorf("%s footer doesn't give the file's own duration:\n%s", filepath.Base(file), data)
+		}
+	}
+}
+
+// TestStartRecordingConcurrently checks that of several recordings
+// started at once exactly one opens a file, and that a recording whose
+// file can't be opened leaves nothing behind to stop
+func TestStartRecordingConcurrently(t *testing.T) {
+	config := GetDefaultConfig()
+	config.RecordPath = t.TempDir()
+	s, _, _ := newTestShellCast(t, config, nil)
+
+	var started atomic.Int32
+	var wg sync.WaitGroup
+	ready := make(chan struct{})
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-ready
+			if err := s.StartRecording(); err == nil {
+				started.Add(1)
+			} else if err.Error() != "already recording" {
+				t.Errorf("StartRecording: %v", err)
+			}
+		}()
+	}
+	close(ready)
+	wg.Wait()
+	if n := started.Load(); n != 1 {
+		t.Fatalf("%d recordings started, want 1", n)
+	}
+	if err := s.StopRecording(); err != nil {
+		t.Fatal(err)
+	}
+
+	// A file in place of the recordings directory
+	blocked := filepath.Join(t.TempDir(), "file")
+	if err := os.WriteFile(blocked, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	s.updateConfig(func(c *Config) { c.RecordPath = filepath.Join(blocked, "recordings") })
+	if err := s.StartRecording(); err == nil {
+		t.Fatal("StartRecording succeeded without a recordings directory")
+	}
+	if s.isRecording() {
+		t.Fatal("failed StartRecording left the session recording")
+	}
+	s.updateConfig(func(c *Config) { c.RecordPath = config.RecordPath })
+	if err := s.StartRecording(); err != nil {
+		t.Fatalf("StartRecording after a failed start: %v", err)
+	}
+	if err := s.StopRecording(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"os"
+	"time"
+)
+
+// streamFrameInterval is how long one frame of the rendered video lasts.
+// The stream file is written at most this often.
+const streamFrameInterval = time.Second / 30
+
+// streamFile is the text file FFmpeg renders. Writes and truncations are
+// collected in memory and reach the file once per frame, see
+// flushStreamLoop, so a burst of output makes FFmpeg reload the file once
+// rather than for every line. Guarded by ShellCast's mutex.
+type streamFile struct {
+	file     *os.File // opened with O_APPEND
+	size     int64    // size of the file once truncate is applied
+	truncate bool     // the file must be cut to size before writing
+	pending  []byte   // text to append
+}
+
+// newStreamFile wraps file, which already holds size bytes
+func newStreamFile(file *os.File, size int64) *streamFile {
+	return &streamFile{file: file, size: size}
+}
+
+// WriteString appends text with the next flush
+func (f *streamFile) WriteString(text string) (int, error) {
+	f.pending = append(f.pending, text...)
+	return len(text), nil
+}
+
+// Size returns the size the file will have after the next flush
+func (f *streamFile) Size() int64 {
+	return f.size + int64(len(f.pending))
+}
+
+// Truncate cuts the file to size with the next flush, dropping pending
+// text first, so text that is written and removed again between two
+// frames never reaches the file
+func (f *streamFile) Truncate(size int64) error {
+	if size >= f.size {
+		f.pending = f.pending[:size-f.size]
+		return nil
+	}
+	f.pending = f.pending[:0]
+	f.size = size
+	f.truncate = true
+	return nil
+}
+
+// Flush applies the pending truncation and writes the pending text
+func (f *streamFile) Flush() error {
+	if f.truncate {
+		if err := f.file.Truncate(f.size); err != nil {
+			return err
+		}
+		f.truncate = false
+	}
+	if len(f.pending) == 0 {
+		return nil
+	}
+	n, err := f.file.Write(f.pending)
+	f.size += int64(n)
+	f.pending = f.pending[:0]
+	return err
+}
+
+// Close flushes and closes the file
+func (f *streamFile) Close() error {
+	err := f.Flush()
+	if closeErr := f.file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// flushStreamLoop writes the stream file once per frame until done is
+// closed
+func (s *ShellCast) flushStreamLoop(done <-chan struct{}) {
+	ticker := time.NewTicker(streamFrameInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			s.flushStreamFile()
+		case <-done:
+			return
+		}
+	}
+}
+
+// flushStreamFile writes pending output to the stream file right away,
+// e.g. when a command has finished
+func (s *ShellCast) flushStreamFile() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.streamOut == nil {
+		return
+	}
+	if err := s.streamOut.Flush(); err != nil {
+		s.logger.Errorf("Error writing output: %v", err)
+	}
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+)
+
+// StreamKeyEnv is the environment variable the stream key is read from
+// when RTMPBase or a platform is set
+const StreamKeyEnv = "SHELLCAST_STREAM_KEY"
+
+// maskedKey replaces stream keys in logs and recordings
+const maskedKey = "****"
+
+// rtmpKeyPattern matches the last path element of an RTMP URL with an
+// application path, which is where services put the stream key
+var rtmpKeyPattern = regexp.MustCompile(`(?i)(rtmps?://[^/\s]+/[^\s]*/)[^/\s"']+`)
+
+// joinStreamKey builds the full RTMP URL from a base URL and a key
+func joinStreamKey(base, key string) string {
+	return strings.TrimSuffix(base, "/") + "/" + key
+}
+
+// maskStreamKeys hides the stream key of every RTMP URL in text, and key
+// itself wherever it appears, for messages and recording headers
+func maskStreamKeys(text, key string) string {
+	if key != "" {
+		text = strings.ReplaceAll(text, key, maskedKey)
+	}
+	return rtmpKeyPattern.ReplaceAllString(text, "${1}"+maskedKey)
+}
+
+// readStreamKey prompts for the stream key on out and reads it from in
+func readStreamKey(in io.Reader, out io.Writer) (string, error) {
+	fmt.Fprint(out, "Stream key: ")
+	line, err := bufio.NewReader(in).ReadString('\n')
+	if err != nil && line == "" {
+		return "", fmt.Errorf("error reading stream key: %v", err)
+	}
+	return strings.TrimSpace(line), nil
+}
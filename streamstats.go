@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// StreamStats is FFmpeg's latest progress report for the stream
+type StreamStats struct {
+	Frames        int64
+	FPS           float64
+	Bitrate       string // as reported, e.g. "2510.3kbits/s"
+	TotalSize     int64  // bytes written
+	OutTime       time.Duration
+	DroppedFrames int64
+	DupFrames     int64
+	Speed         string // e.g. "1x"; below 1x the encoder can't keep up
+	Updated       time.Time
+}
+
+// StreamStats returns the latest progress report of the running stream.
+// ok is false when not streaming or before FFmpeg's first report.
+func (s *ShellCast) StreamStats() (stats StreamStats, ok bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.streamStats, s.streaming && !s.streamStats.Updated.IsZero()
+}
+
+// readProgress parses the key=value blocks FFmpeg writes with
+// -progress, storing each complete block as the current stream stats.
+// It returns at EOF, when FFmpeg exits. started is closed on the first
+// report.
+func (s *ShellCast) readProgress(r io.Reader, started chan<- struct{}) {
+	var stats StreamStats
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(strings.TrimSpace(scanner.Text()), "=")
+		if !ok {
+			continue
+		}
+		value = strings.TrimSpace(value)
+
+		switch key {
+		case "frame":
+			stats.Frames, _ = strconv.ParseInt(value, 10, 64)
+		case "fps":
+			stats.FPS, _ = strconv.ParseFloat(value, 64)
+		case "bitrate":
+			stats.Bitrate = value
+		case "total_size":
+			stats.TotalSize, _ = strconv.ParseInt(value, 10, 64)
+		case "out_time_us":
+			if us, err := strconv.ParseInt(value, 10, 64); err == nil {
+				stats.OutTime = time.Duration(us) * time.Microsecond
+			}
+		case "drop_frames":
+			stats.DroppedFrames, _ = strconv.ParseInt(value, 10, 64)
+		case "dup_frames":
+			stats.DupFrames, _ = strconv.ParseInt(value, 10, 64)
+		case "speed":
+			stats.Speed = value
+		case "progress":
+			// Each report ends with progress=continue or progress=end
+			stats.Updated = time.Now()
+			s.mutex.Lock()
+			s.streamStats = stats
+			s.mutex.Unlock()
+			if started != nil {
+				close(started)
+				started = nil
+			}
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// SupportedRecordFormats lists the formats a recording can be written
+// in. "srt" and "vtt" write subtitle cues timed from the session start,
+// for captions over a separately recorded video.
+var SupportedRecordFormats = []string{"text", "srt", "vtt"}
+
+const (
+	// cueWindow is how long a cue collects lines after its first one, so
+	// bursts of output become one cue instead of one per line
+	cueWindow = time.Second
+	// cueMaxLines is how many lines a cue shows; a burst keeps its last
+	// lines, like a terminal
+	cueMaxLines = 4
+	// cueLinger is how long a cue stays up after its last line when no
+	// other cue follows sooner
+	cueLinger = 3 * time.Second
+)
+
+// subtitleCue is a cue collecting output lines, see recordCue
+type subtitleCue struct {
+	start, last time.Duration // since the session started
+	lines       []string
+}
+
+// isSubtitleFormat reports whether recordings are written as subtitles
+func (c *Config) isSubtitleFormat() bool {
+	return c.RecordFormat == "srt" || c.RecordFormat == "vtt"
+}
+
+// subtitleHeader starts a new subtitle recording
+func (c *Config) subtitleHeader() string {
+	if c.RecordFormat == "vtt" {
+		return "WEBVTT\n\n"
+	}
+	return ""
+}
+
+// recordCue adds an output line to the current cue, writing the cue out
+// first when the line comes after its window. The caller must hold
+// s.mutex.
+func (s *ShellCast) recordCue(line string) {
+	if strings.TrimSpace(line) == "" {
+		return // an empty line would end the cue early
+	}
+
+	at := time.Since(s.startTime)
+	if s.cue != nil && at-s.cue.start >= cueWindow {
+		s.writeCue(at)
+	}
+	if s.cue == nil {
+		s.cue = &subtitleCue{start: at}
+	}
+	s.cue.last = at
+	s.cue.lines = append(s.cue.lines, line)
+	if len(s.cue.lines) > cueMaxLines {
+		s.cue.lines = s.cue.lines[1:]
+	}
+}
+
+// writeCue writes the current cue to the recording, ending when the next
+// one starts at next or cueLinger after its last line, whichever is
+// earlier. The caller must hold s.mutex.
+func (s *ShellCast) writeCue(next time.Duration) {
+	cue := s.cue
+	if cue == nil {
+		return
+	}
+	s.cue = nil
+
+	end := cue.last + cueLinger
+	if next < end {
+		end = next
+	}
+	// Players skip cues without a duration
+	if end <= cue.start {
+		end = cue.start + time.Millisecond
+	}
+
+	s.cueCount++
+	var text string
+	if s.cfg().RecordFormat == "vtt" {
+		text = fmt.Sprintf("%d\n%s --> %s\n%s\n\n", s.cueCount,
+			cueTime(cue.start, "."), cueTime(end, "."), vttEscape(strings.Join(cue.lines, "\n")))
+	} else {
+		text = fmt.Sprintf("%d\n%s --> %s\n%s\n\n", s.cueCount,
+			cueTime(cue.start, ","), cueTime(end, ","), strings.Join(cue.lines, "\n"))
+	}
+	if _, err := s.recordWriter.WriteString(text); err != nil {
+		s.logger.Errorf("Error writing recording: %v", err)
+	}
+}
+
+// cueTime formats d as HH:MM:SS followed by sep and milliseconds, the
+// timestamp of both SRT (sep ",") and WebVTT (sep ".")
+func cueTime(d time.Duration, sep string) string {
+	ms := d.Milliseconds()
+	return fmt.Sprintf("%02d:%02d:%02d%s%03d",
+		ms/3600000, ms/60000%60, ms/1000%60, sep, ms%1000)
+}
+
+// vttEscape escapes the characters WebVTT cue text gives a meaning to
+func vttEscape(text string) string {
+	return strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;").Replace(text)
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// tailPollInterval is how often a followed file is checked for new data,
+// truncation and rotation
+const tailPollInterval = 250 * time.Millisecond
+
+// tailInitialLines is how many existing lines TailFile shows before
+// following the file, like tail -f
+const tailInitialLines = 10
+
+// tailReader reads a file like tail -f: at the end of the file it waits
+// for more data instead of returning io.EOF. A truncated file is read
+// again from the start, and when the path is renamed or replaced, as log
+// rotation does, the rest of the old file is read and the new file is
+// followed from its start. Read returns io.EOF once ctx is done.
+type tailReader struct {
+	ctx    context.Context
+	path   string
+	file   *os.File
+	offset int64
+}
+
+// openTail opens path for following, positioned before its last lines
+func openTail(ctx context.Context, path string, lines int) (*tailReader, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("error opening file to tail: %v", err)
+	}
+	offset, err := lastLinesOffset(file, lines)
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("error reading file to tail: %v", err)
+	}
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("error reading file to tail: %v", err)
+	}
+	return &tailReader{ctx: ctx, path: path, file: file, offset: offset}, nil
+}
+
+// lastLinesOffset returns where the last n lines of file start. Only the
+// end of the file is searched, so huge logs start quickly.
+func lastLinesOffset(file *os.File, n int) (int64, error) {
+	info, err := file.Stat()
+	if err != nil {
+		return 0, err
+	}
+	size := info.Size()
+	start := size - 64*1024
+	if start < 0 {
+		start = 0
+	}
+	data := make([]byte, size-start)
+	if _, err := file.ReadAt(data, start); err != nil && err != io.EOF {
+		return 0, err
+	}
+
+	// Skip a final newline, then count newlines backwards
+	end := len(data)
+	if end > 0 && data[end-1] == '\n' {
+		end--
+	}
+	for i := 0; i < n; i++ {
+		pos := bytes.LastIndexByte(data[:end], '\n')
+		if pos < 0 {
+			return start, nil
+		}
+		end = pos
+	}
+	return start + int64(end) + 1, nil
+}
+
+// Read returns the next data from the file, waiting for it if necessary
+func (t *tailReader) Read(p []byte) (int, error) {
+	for {
+		n, err := t.file.Read(p)
+		t.offset += int64(n)
+		if n > 0 {
+			return n, nil
+		}
+		if err != nil && err != io.EOF {
+			return 0, err
+		}
+
+		// At the end: wait, then check whether the file was truncated
+		// or replaced
+		select {
+		case <-t.ctx.Done():
+			return 0, io.EOF
+		case <-time.After(tailPollInterval):
+		}
+		if err := t.checkRotation(); err != nil {
+			return 0, err
+		}
+	}
+}
+
+// checkRotation reopens the path if it now names another file, and
+// starts over if the file shrank below the read position
+func (t *tailReader) checkRotation() error {
+	current, err := t.file.Stat()
+	if err != nil {
+		return err
+	}
+	if current.Size() < t.offset {
+		t.offset = 0
+		_, err := t.file.Seek(0, io.SeekStart)
+		return err
+	}
+
+	// A missing path means the log is being rotated; keep reading the
+	// old file until the new one appears
+	info, err := os.Stat(t.path)
+	if err != nil || os.SameFile(info, current) {
+		return nil
+	}
+	// Anything written to the old file since the last read comes first
+	if current.Size() > t.offset {
+		return nil
+	}
+	file, err := os.Open(t.path)
+	if err != nil {
+		return nil
+	}
+	t.file.Close()
+	t.file = file
+	t.offset = 0
+	return nil
+}
+
+// Close closes the followed file
+func (t *tailReader) Close() error {
+	return t.file.Close()
+}
+
+// TailFile follows the file at path like tail -f, sending its last lines
+// and then every line appended to it through the output pipeline, as if
+// a command printed them. It returns when ctx is cancelled.
+func (s *ShellCast) TailFile(ctx context.Context, path string) error {
+	tail, err := openTail(ctx, path, tailInitialLines)
+	if err != nil {
+		return err
+	}
+	defer tail.Close()
+
+	s.logger.Infof("Following %s", path)
+	reader := &outputReader{stream: "stdout", command: filepath.Base(path), lines: s.newLineCounter(), w: s.Stdout}
+	s.readOutput(tail, reader, nil)
+	s.flushStreamFile()
+	return nil
+}
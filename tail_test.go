@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLastLinesOffset(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		n       int
+		want    string
+	}{
+		{"empty file", "", 10, ""},
+		{"more lines than n", "a\nb\nc\nd\n", 2, "c\nd\n"},
+		{"no trailing newline", "a\nb\nc", 2, "b\nc"},
+		{"fewer lines than n", "a\nb\n", 10, "a\nb\n"},
+		{"fewer lines without trailing newline", "a\nb", 10, "a\nb"},
+		{"exactly n lines", "a\nb\n", 2, "a\nb\n"},
+		{"empty lines", "a\n\n\n", 2, "\n\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "log")
+			if err := os.WriteFile(path, []byte(tt.content), 0644); err != nil {
+				t.Fatal(err)
+			}
+			file, err := os.Open(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer file.Close()
+
+			offset, err := lastLinesOffset(file, tt.n)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if offset < 0 || offset > int64(len(tt.content)) {
+				t.Fatalf("lastLinesOffset(%q, %d) = %d, outside the file", tt.content, tt.n, offset)
+			}
+			if got := tt.content[offset:]; got != tt.want {
+				t.Errorf("lastLinesOffset(%q, %d) starts at %q, want %q", tt.content, tt.n, got, tt.want)
+			}
+		})
+	}
+}
+
+// TestTailTruncateThenAppend checks that a followed file truncated and
+// written again, as copytruncate log rotation does, is read from its new
+// start
+func TestTailTruncateThenAppend(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "log")
+	if err := os.WriteFile(path, []byte("old one\nold two\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	tail, err := openTail(context.Background(), path, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tail.Close()
+
+	readAll := func(want string) {
+		t.Helper()
+		buf := make([]byte, len(want))
+		if _, err := io.ReadFull(tail, buf); err != nil {
+			t.Fatal(err)
+		}
+		if string(buf) != want {
+			t.Fatalf("read %q, want %q", buf, want)
+		}
+	}
+	readAll("old one\nold two\n")
+
+	// Nothing changed yet
+	if err := tail.checkRotation(); err != nil {
+		t.Fatal(err)
+	}
+	if tail.offset != int64(len("old one\nold two\n")) {
+		t.Fatalf("offset = %d after an unchanged check", tail.offset)
+	}
+
+	if err := os.Truncate(path, 0); err != nil {
+		t.Fatal(err)
+	}
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := file.WriteString("new\n"); err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+
+	if err := tail.checkRotation(); err != nil {
+		t.Fatal(err)
+	}
+	if tail.offset != 0 {
+		t.Fatalf("offset = %d after truncation, want 0", tail.offset)
+	}
+	readAll("new\n")
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// tempFilePattern names the stream text files StartStreaming creates in
+// the temp directory
+const tempFilePattern = "shellcast_*.txt"
+
+// staleTempAge is how long a temp file must have gone unmodified before
+// SweepTempFiles treats it as left behind by a crashed ShellCast
+const staleTempAge = 24 * time.Hour
+
+// SweepTempFiles removes ShellCast temp files in dir, stream text files
+// and copies of the embedded font, that haven't been modified for maxAge
+// and returns how many were removed
+func SweepTempFiles(dir string, maxAge time.Duration) (int, error) {
+	var matches []string
+	for _, pattern := range []string{tempFilePattern, embeddedFontPattern} {
+		found, err := filepath.Glob(filepath.Join(dir, pattern))
+		if err != nil {
+			return 0, err
+		}
+		matches = append(matches, found...)
+	}
+
+	removed := 0
+	for _, path := range matches {
+		info, err := os.Stat(path)
+		if err != nil || !info.Mode().IsRegular() || time.Since(info.ModTime()) < maxAge {
+			continue
+		}
+		if err := os.Remove(path); err == nil {
+			removed++
+		}
+	}
+	return removed, nil
+}
+
+// removeStreamFile deletes the stream text file unless KeepTemp is set.
+// The caller must hold the mutex.
+func (s *ShellCast) removeStreamFile() {
+	if s.cfg().OutputFile == "" {
+		return
+	}
+	if s.cfg().KeepTemp {
+		s.logger.Infof("Keeping stream text file: %s", s.cfg().OutputFile)
+	} else {
+		os.Remove(s.cfg().OutputFile)
+	}
+	s.updateConfig(func(c *Config) { c.OutputFile = "" })
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+)
+
+// customThemes holds the themes loaded with LoadThemes, which
+// GetThemePresets adds to the built-in ones
+var (
+	customThemesMu sync.RWMutex
+	customThemes   map[string]ThemePreset
+)
+
+// addCustomThemes adds the loaded themes to presets, replacing built-in
+// themes of the same name
+func addCustomThemes(presets map[string]ThemePreset) {
+	customThemesMu.RLock()
+	defer customThemesMu.RUnlock()
+	for name, theme := range customThemes {
+		presets[name] = theme
+	}
+}
+
+// LoadThemes reads a themes file, a JSON object of theme presets by name
+// as written by ExportThemes, and makes its themes available next to the
+// built-in ones. They replace the themes of an earlier LoadThemes.
+func LoadThemes(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("error reading themes file: %v", err)
+	}
+	var themes map[string]ThemePreset
+	if err := json.Unmarshal(data, &themes); err != nil {
+		return fmt.Errorf("error parsing themes file %s: %v", path, err)
+	}
+	for name, theme := range themes {
+		if name == "" {
+			return fmt.Errorf("theme without a name in %s", path)
+		}
+		if theme.Name == "" {
+			theme.Name = name
+			themes[name] = theme
+		}
+		if err := theme.Validate(); err != nil {
+			return fmt.Errorf("%s: %v", path, err)
+		}
+	}
+
+	customThemesMu.Lock()
+	customThemes = themes
+	customThemesMu.Unlock()
+	return nil
+}
+
+// ExportThemes writes every available theme, built-in and loaded, to
+// path in the format LoadThemes reads
+func ExportThemes(path string) error {
+	data, err := json.MarshalIndent(GetThemePresets(), "", "  ")
+	if err != nil {
+		return fmt.Errorf("error encoding themes: %v", err)
+	}
+	if err := os.WriteFile(path, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("error writing themes file: %v", err)
+	}
+	return nil
+}
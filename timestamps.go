@@ -0,0 +1,75 @@
+package main
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+// SupportedTimestampLines lists which lines get a timestamp. "per-line"
+// stamps every line; "per-burst" only the first of lines arriving within
+// TimestampInterval of each other; "interval" at most one line per
+// TimestampInterval.
+var SupportedTimestampLines = []string{"per-line", "per-burst", "interval"}
+
+// lineStamp is whether a line shows its timestamp, decided once for a
+// line so an unfinished line keeps it when completed
+type lineStamp int
+
+const (
+	stampUndecided lineStamp = iota
+	stampShown
+	stampHidden
+)
+
+// timestampClock remembers when lines were written and stamped, for
+// the per-burst and interval timestamp lines. Shared by all output
+// streams of the session.
+type timestampClock struct {
+	mu        sync.Mutex
+	lastLine  time.Time
+	lastStamp time.Time
+}
+
+// stamp reports whether a line written at now gets a timestamp
+func (c *timestampClock) stamp(lines string, interval time.Duration, now time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var show bool
+	switch lines {
+	case "per-burst":
+		show = now.Sub(c.lastLine) >= interval
+	case "interval":
+		show = now.Sub(c.lastStamp) >= interval
+	default:
+		show = true
+	}
+	c.lastLine = now
+	if show {
+		c.lastStamp = now
+	}
+	return show
+}
+
+// timestampPrefix returns the timestamp for reader's current line, or
+// as many spaces when the line goes without one, so the text of stamped
+// and unstamped lines stays aligned
+func (s *ShellCast) timestampPrefix(reader *outputReader, now time.Time) string {
+	timestamp := now.Format(s.cfg().TimestampFormat)
+	if s.cfg().TimestampMode == "relative" {
+		timestamp = formatElapsed(now.Sub(s.startTime))
+	}
+	prefix := "[" + timestamp + "] "
+
+	if reader.stamp == stampUndecided {
+		reader.stamp = stampHidden
+		if s.timestamps.stamp(s.cfg().TimestampLines, time.Duration(s.cfg().TimestampInterval), now) {
+			reader.stamp = stampShown
+		}
+	}
+	if reader.stamp == stampHidden {
+		return strings.Repeat(" ", len(prefix))
+	}
+	return prefix
+}
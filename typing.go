@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// typingPrompt is shown in front of typed commands
+const typingPrompt = "$ "
+
+// typeCommand shows command at a prompt one character at a time, as if
+// it were being typed, before it runs. Cancelling ctx finishes the line
+// at once.
+func (s *ShellCast) typeCommand(ctx context.Context, command string) {
+	line := s.formatOutput(&outputReader{stream: "command", command: commandName(command)}, typingPrompt+command)
+	if !s.passesFilters(typingPrompt + command) {
+		s.mutex.Lock()
+		fmt.Fprintln(s.Stdout, line)
+		s.mutex.Unlock()
+		return
+	}
+
+	// Partial JSON objects would be unreadable, so they are written whole
+	if s.cfg().OutputFormat == "json" {
+		s.writeOutput(s.Stdout, line)
+		return
+	}
+
+	typed := ""
+	for _, r := range line {
+		typed += string(r)
+		s.writeTyped(string(r), typed)
+		if ctx.Err() != nil {
+			continue
+		}
+		select {
+		case <-ctx.Done():
+		case <-time.After(time.Duration(s.cfg().TypingSpeed)):
+		}
+	}
+	s.finishTypedLine(line)
+}
+
+// writeTyped adds text to the current line of the terminal, buffer,
+// stream and connected clients, and line, the current line with text,
+// to an offline render, so each character appears when it was typed. The
+// recording gets the line once it is complete.
+func (s *ShellCast) writeTyped(text, line string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	fmt.Fprint(s.Stdout, text)
+	if s.streamPaused {
+		return
+	}
+	s.clearKeepalive()
+	s.outputBuffer.WriteString(text)
+	s.broadcast(text)
+	if strings.HasSuffix(text, "\n") {
+		s.captureLine(line)
+	} else {
+		s.captureOpenLine(line)
+	}
+	if s.streamOut != nil {
+		if _, err := s.streamOut.WriteString(text); err != nil {
+			s.logger.Errorf("Error writing output: %v", err)
+		}
+	}
+}
+
+// finishTypedLine ends a typed line and hands the whole line to the
+// recording
+func (s *ShellCast) finishTypedLine(line string) {
+	s.writeTyped("\n", line)
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if !s.cfg().RawRecording {
+		s.recordLine(line)
+	}
+}
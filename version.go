@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"runtime"
+	"runtime/debug"
+)
+
+// Build information, set at build time with
+// -ldflags "-X main.Version=... -X main.GitCommit=... -X main.BuildDate=..."
+var (
+	Version   = "dev"
+	GitCommit = ""
+	BuildDate = ""
+)
+
+// BuildInfo describes the running binary
+type BuildInfo struct {
+	Version   string
+	GitCommit string
+	BuildDate string
+	GoVersion string
+}
+
+// GetBuildInfo returns the build information. Values not set at build
+// time are taken from what the Go toolchain embedded, if anything.
+func GetBuildInfo() BuildInfo {
+	info := BuildInfo{
+		Version:   Version,
+		GitCommit: GitCommit,
+		BuildDate: BuildDate,
+		GoVersion: runtime.Version(),
+	}
+
+	embedded, ok := debug.ReadBuildInfo()
+	if !ok {
+		return info
+	}
+	if info.Version == "dev" && embedded.Main.Version != "" && embedded.Main.Version != "(devel)" {
+		info.Version = embedded.Main.Version
+	}
+	var revision, modified, vcsTime string
+	for _, setting := range embedded.Settings {
+		switch setting.Key {
+		case "vcs.revision":
+			revision = setting.Value
+		case "vcs.modified":
+			modified = setting.Value
+		case "vcs.time":
+			vcsTime = setting.Value
+		}
+	}
+	if info.GitCommit == "" && revision != "" {
+		info.GitCommit = revision
+		if modified == "true" {
+			info.GitCommit += "-dirty"
+		}
+	}
+	if info.BuildDate == "" {
+		info.BuildDate = vcsTime
+	}
+	return info
+}
+
+// PrintVersion writes the build information to w
+func PrintVersion(w io.Writer) {
+	info := GetBuildInfo()
+	unknown := func(value string) string {
+		if value == "" {
+			return "unknown"
+		}
+		return value
+	}
+
+	fmt.Fprintf(w, "ShellCast %s\n", info.Version)
+	fmt.Fprintf(w, "Commit:     %s\n", unknown(info.GitCommit))
+	fmt.Fprintf(w, "Built:      %s\n", unknown(info.BuildDate))
+	fmt.Fprintf(w, "Go version: %s\n", info.GoVersion)
+}
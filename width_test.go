@@ -0,0 +1,104 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestDisplayWidth(t *testing.T) {
+	tests := []struct {
+		text string
+		want int
+	}{
+		{"", 0},
+		{"hello", 5},
+		{"日本語", 6},
+		{"ｶﾀｶﾅ", 4},                       // halfwidth katakana
+		{"ＡＢ", 4},                         // fullwidth latin
+		{"한국어", 6},                        // hangul
+		{"ok 👍", 5},                       // emoji
+		{"🎉🚀", 4},                         // emoji
+		{"e\u0301", 1},                    // combining accent
+		{"\U0001F469\u200D\U0001F4BB", 4}, // joiner adds nothing
+		{"こんにちは world", 16},               // mixed
+		{"\x1b", 0},                       // control character
+	}
+	for _, tt := range tests {
+		if got := displayWidth(tt.text); got != tt.want {
+			t.Errorf("displayWidth(%q) = %d, want %d (%d bytes, %d runes)",
+				tt.text, got, tt.want, len(tt.text), utf8.RuneCountInString(tt.text))
+		}
+	}
+}
+
+func TestWrapLine(t *testing.T) {
+	tests := []struct {
+		line    string
+		columns int
+		want    []string
+	}{
+		{"", 4, []string{""}},
+		{"abcdef", 4, []string{"abcd", "ef"}},
+		{"日本語です", 4, []string{"日本", "語で", "す"}},
+		// A wide character never straddles two rows
+		{"a日本", 4, []string{"a日", "本"}},
+		{"🎉🎉🎉", 5, []string{"🎉🎉", "🎉"}},
+	}
+	for _, tt := range tests {
+		got := wrapLine(tt.line, tt.columns)
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("wrapLine(%q, %d) = %q, want %q", tt.line, tt.columns, got, tt.want)
+		}
+		for _, row := range got {
+			if displayWidth(row) > tt.columns {
+				t.Errorf("wrapLine(%q, %d): row %q is %d columns wide", tt.line, tt.columns, row, displayWidth(row))
+			}
+		}
+	}
+}
+
+// TestRenderFramesWideText checks that render frames wrap and scroll by
+// display width, so Japanese text and emoji stay on screen
+func TestRenderFramesWideText(t *testing.T) {
+	config := GetDefaultConfig()
+	// 10 columns and 3 rows
+	config.FontSize = 10
+	config.TextPadding = 0
+	config.ScreenWidth = 60
+	config.ScreenHeight = 36
+	s, _, _ := newTestShellCast(t, config, nil)
+	if columns, rows := config.visibleColumns(), config.visibleLines(); columns != 10 || rows != 3 {
+		t.Fatalf("screen is %dx%d, want 10x3", columns, rows)
+	}
+
+	// 7 runes and 21 bytes, but 14 columns: two rows
+	japanese := "こんにちは世界"
+	emoji := "🎉🎉🎉🎉🎉🎉"
+	frames := s.renderFrames([]capturedLine{
+		{at: 0, text: "first"},
+		{at: streamFrameInterval, text: japanese},
+		{at: 2 * streamFrameInterval, text: emoji},
+	})
+
+	want := []string{
+		"first\n",
+		"first\nこんにちは\n世界\n",
+		"世界\n🎉🎉🎉🎉🎉\n🎉\n",
+	}
+	var got []string
+	for _, frame := range frames[1:] {
+		got = append(got, frame.text)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("frames = %q, want %q", got, want)
+	}
+	for _, frame := range got {
+		for _, row := range strings.Split(strings.TrimSuffix(frame, "\n"), "\n") {
+			if displayWidth(row) > 10 {
+				t.Errorf("row %q is %d columns wide", row, displayWidth(row))
+			}
+		}
+	}
+}